@@ -0,0 +1,619 @@
+package config
+
+import (
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/marknguyen85/server-api/tomochain"
+)
+
+// Connection is an upstream the fetcher read the chain from
+type Connection struct {
+	Endpoint string `json:"endPoint"`
+	Type     string `json:"type"`
+	Apikey   string `json:"api_key"`
+	// requests per second of a tomoscan connection, zero for its default
+	RateLimit float64 `json:"rate_limit"`
+}
+
+// Chain describe the network contract and the services around it
+type Chain struct {
+	ApiUsd      string               `json:"api_usd"`
+	CoinMarket  []string             `json:"coin_market"`
+	Tokens      []tomochain.TokenAPI `json:"tokens"`
+	Connections []Connection         `json:"connections"`
+
+	Network          string `json:"network"`
+	TradeTopic       string `json:"trade_topic"`
+	AverageBlockTime int64  `json:"averageBlockTime"`
+	// ABI of the network contract, empty for the ChainTeX network ABI
+	NetworkAbi string `json:"network_abi"`
+
+	GasStationEndpoint string `json:"gasstation_endpoint"`
+	APIEndpoint        string `json:"api_endpoint"`
+	ConfigEndpoint     string `json:"config_endpoint"`
+	UserStatsEndpoint  string `json:"user_stats_endpoint"`
+
+	TokenSources TokenSources `json:"token_sources"`
+}
+
+// Token sources a chain read its token list from
+const (
+	// TokenSourceConfig is the list of config_endpoint
+	TokenSourceConfig = "config"
+	// TokenSourceRegistry is the registry contract
+	TokenSourceRegistry = "registry"
+	// TokenSourceList is a signed token list in the Uniswap format
+	TokenSourceList = "list"
+)
+
+// TokenSources configure where the token list of a chain come from. Every
+// refresh read each source and merge them by precedence: the first source
+// listing a token id or an address decide its token, the next ones only add
+// tokens and fill empty fields.
+type TokenSources struct {
+	// sources from the highest precedence, config alone when empty
+	Precedence []string `json:"precedence"`
+	// contract answering getListedTokens, the network contract when empty
+	Registry string `json:"registry"`
+	// url of the token list
+	List string `json:"list"`
+	// url of the detached ed25519 signature of the list, the list url with
+	// .sig appended when empty
+	Signature string `json:"signature"`
+	// key signing the list, hex or base64
+	PublicKey string `json:"public_key"`
+	// chain id of the tokens kept from the list, zero for every token
+	ChainID int `json:"chain_id"`
+}
+
+// Order return the sources from the highest precedence
+func (sources TokenSources) Order() []string {
+	if len(sources.Precedence) == 0 {
+		return []string{TokenSourceConfig}
+	}
+	return sources.Precedence
+}
+
+// SignatureURL return the url of the signature of the list
+func (sources TokenSources) SignatureURL() string {
+	if sources.Signature != "" {
+		return sources.Signature
+	}
+	return sources.List + ".sig"
+}
+
+// Key decode the public key signing the list
+func (sources TokenSources) Key() ([]byte, error) {
+	key, err := decodeKey(sources.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != ed25519KeySize {
+		return nil, fmt.Errorf("public key is %d bytes, want %d", len(key), ed25519KeySize)
+	}
+	return key, nil
+}
+
+// ed25519KeySize is the size of an ed25519 public key
+const ed25519KeySize = 32
+
+// decodeKey decode value, hex with or without 0x, or standard base64
+func decodeKey(value string) ([]byte, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, errors.New("must not be empty")
+	}
+	if key, err := hex.DecodeString(strings.TrimPrefix(value, "0x")); err == nil {
+		return key, nil
+	}
+	key, err := base64.StdEncoding.DecodeString(value)
+	if err != nil {
+		return nil, errors.New("neither hex nor base64")
+	}
+	return key, nil
+}
+
+// Log configure the logger
+type Log struct {
+	Level      string `json:"level"`
+	ToStdout   bool   `json:"to_stdout"`
+	File       string `json:"file"`
+	MaxSizeMB  int    `json:"max_size_mb"`
+	MaxBackups int    `json:"max_backups"`
+	MaxAgeDays int    `json:"max_age_days"`
+}
+
+// Intervals between two runs of each fetch job
+type Intervals struct {
+	ListToken Duration `json:"list_token"`
+	RateUSD   Duration `json:"rate_usd"`
+	// zero derive the interval from the number of tokens
+	GeneralInfo  Duration `json:"general_info"`
+	Rate7d       Duration `json:"rate_7d"`
+	Rate         Duration `json:"rate"`
+	RateFallback Duration `json:"rate_fallback"`
+	// latest block and the trades it brings
+	Block Duration `json:"block"`
+	// health of every connection, see /admin/connections
+	Connections Duration `json:"connections"`
+}
+
+// Limit is a token bucket: Rate requests per second, up to Burst at once
+type Limit struct {
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// APIKey give the client sending it its own quota in every route group
+type APIKey struct {
+	Name  string  `json:"name"`
+	Key   string  `json:"key"`
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+}
+
+// RateLimit throttle the public routes. Groups set the limit of anonymous
+// clients, by ip, for each route group: data, history and stream. A group
+// without limit is not throttled.
+type RateLimit struct {
+	// memory or redis, to share the buckets between servers
+	Store         string           `json:"store"`
+	RedisAddr     string           `json:"redis_addr"`
+	RedisPassword string           `json:"redis_password"`
+	Groups        map[string]Limit `json:"groups"`
+	APIKeys       []APIKey         `json:"api_keys"`
+	// TrustedProxies are the addresses or CIDRs of the proxies in front of
+	// the server, whose X-Forwarded-For is believed
+	TrustedProxies []string `json:"trusted_proxies"`
+}
+
+// Proxies parse TrustedProxies, an address is a network of one
+func (rateLimit *RateLimit) Proxies() ([]*net.IPNet, error) {
+	nets := make([]*net.IPNet, 0, len(rateLimit.TrustedProxies))
+	for _, proxy := range rateLimit.TrustedProxies {
+		if ip := net.ParseIP(proxy); ip != nil {
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q", proxy)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+// CORS is the cross origin policy of the public routes. An origin may hold
+// one * matching any part, e.g. https://*.example.com, or be a browser
+// extension, e.g. chrome-extension://<id>.
+type CORS struct {
+	AllowOrigins []string `json:"allow_origins"`
+	AllowMethods []string `json:"allow_methods"`
+	AllowHeaders []string `json:"allow_headers"`
+	// how long browsers cache a preflight response
+	MaxAge Duration `json:"max_age"`
+	// ids of the Chrome extensions allowed, as chrome-extension://<id>
+	ExtensionIDs []string `json:"extension_ids"`
+	// allow every moz-extension:// origin, Firefox give each install of an
+	// extension its own random id
+	FirefoxExtensions bool `json:"firefox_extensions"`
+}
+
+// Origins return the allowed origins with the ones of the extensions
+func (cors *CORS) Origins() []string {
+	origins := append([]string(nil), cors.AllowOrigins...)
+	for _, id := range cors.ExtensionIDs {
+		origins = append(origins, "chrome-extension://"+id)
+	}
+	if cors.FirefoxExtensions {
+		origins = append(origins, "moz-extension://*")
+	}
+	return origins
+}
+
+// corsProfile return the default policy of env: every origin except in
+// production, which allow none until the wallet origins are configured
+func corsProfile(env string) CORS {
+	cors := CORS{
+		AllowOrigins: []string{"*"},
+		AllowMethods: []string{"GET", "HEAD"},
+		AllowHeaders: []string{"Origin", "Content-Type", "X-API-Key", "X-Request-ID", "If-None-Match", "If-Modified-Since"},
+		MaxAge:       Duration(12 * time.Hour),
+	}
+	if env == "production" {
+		cors.AllowOrigins = nil
+	}
+	return cors
+}
+
+// TLS serve https with a PEM certificate and key, reloaded when the files
+// change. Plain http when CertFile is empty.
+type TLS struct {
+	CertFile string `json:"cert_file"`
+	KeyFile  string `json:"key_file"`
+	// plain http listener redirecting to https, e.g. ":80", empty for none
+	RedirectAddr string `json:"redirect_addr"`
+}
+
+// HTTPClient configure the calls to the http upstreams: config and api
+// endpoints, tomoscan, coingecko and coinmarketcap
+type HTTPClient struct {
+	// timeout of one attempt, by host name in host_timeouts
+	Timeout      Duration            `json:"timeout"`
+	HostTimeouts map[string]Duration `json:"host_timeouts"`
+	// retries of network errors, 429 and 5xx, waiting up to max_backoff
+	Retries    int      `json:"retries"`
+	MaxBackoff Duration `json:"max_backoff"`
+	// consecutive failures stopping the calls to a host for breaker_cooldown
+	BreakerFailures int      `json:"breaker_failures"`
+	BreakerCooldown Duration `json:"breaker_cooldown"`
+}
+
+// Verification compare the answers of the connections of a network to the
+// same calls at the same block
+type Verification struct {
+	// zero disable the verification
+	Interval Duration `json:"interval"`
+	// token rates checked each time, with max gas price
+	Pairs int `json:"pairs"`
+	// how long a connection disagreeing with the majority is not called
+	Quarantine Duration `json:"quarantine"`
+}
+
+// Upstream modes
+const (
+	UpstreamRecord = "record"
+	UpstreamReplay = "replay"
+)
+
+// Upstream let the http calls to the upstreams and nodes be recorded to
+// fixture files, or be answered from them without network
+type Upstream struct {
+	// empty to call the upstreams, UpstreamRecord or UpstreamReplay
+	Mode     string `json:"mode"`
+	Fixtures string `json:"fixtures"`
+}
+
+// MockEnv is the environment serving synthetic data, without upstream
+const MockEnv = "mock"
+
+// Mock configure the synthetic data of the mock environment. The same seed
+// give the same tokens, rates and history.
+type Mock struct {
+	Seed int `json:"seed"`
+	// generated tokens, TOMO excluded
+	Tokens int `json:"tokens"`
+	// time between two moves of the rates, zero keep the seeded ones
+	Step Duration `json:"step"`
+}
+
+// Webhook receive the token list changes of Networks, every network when
+// empty, as a POST. With a Secret the body is signed by HMAC-SHA256.
+type Webhook struct {
+	URL      string   `json:"url"`
+	Secret   string   `json:"secret"`
+	Networks []string `json:"networks"`
+}
+
+// Config of the whole server. The chain settings sit at the top level of
+// the file so the existing env/*.json files stay valid.
+type Config struct {
+	Env  string `json:"-"`
+	Path string `json:"-"`
+
+	ListenAddr  string    `json:"listen_addr"`
+	BoltPath    string    `json:"bolt_path"`
+	MaxDataAge  Duration  `json:"max_data_age"`
+	AdminToken  string    `json:"admin_token"`
+	AdminSecret string    `json:"admin_secret"`
+	Log         Log       `json:"log"`
+	Intervals   Intervals `json:"intervals"`
+
+	// how often the config file is checked for changes, zero disable it
+	ReloadInterval Duration `json:"reload_interval"`
+	// messages queued for a /v2/stream client before it is disconnected
+	StreamBuffer int `json:"stream_buffer"`
+
+	RateLimit RateLimit `json:"rate_limit"`
+	CORS      CORS      `json:"cors"`
+	TLS       TLS       `json:"tls"`
+
+	HTTPClient   HTTPClient   `json:"http_client"`
+	Verification Verification `json:"verification"`
+	Upstream     Upstream     `json:"upstream"`
+	Mock         Mock         `json:"mock"`
+
+	// Webhooks notified of the token list changes
+	Webhooks []Webhook `json:"webhooks"`
+
+	// Networks served by name. When empty the chain at the top level is the
+	// only network, named after Env.
+	Networks map[string]Chain `json:"networks"`
+	// DefaultNetwork is served by the routes without network, default Env
+	DefaultNetwork string `json:"default_network"`
+
+	Chain
+}
+
+// Mocked tell whether the server run the mock environment
+func (cfg *Config) Mocked() bool {
+	return cfg.Env == MockEnv
+}
+
+// Chains return every network served, by name
+func (cfg *Config) Chains() map[string]Chain {
+	if len(cfg.Networks) == 0 {
+		return map[string]Chain{cfg.Env: cfg.Chain}
+	}
+	return cfg.Networks
+}
+
+// NetworkNames return the name of every network served, sorted
+func (cfg *Config) NetworkNames() []string {
+	chains := cfg.Chains()
+	names := make([]string, 0, len(chains))
+	for name := range chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Default return settings used when neither file, environment nor flag set them
+func Default() *Config {
+	return &Config{
+		Env:            "testnet",
+		ListenAddr:     ":3001",
+		BoltPath:       "./persister/db/market.db",
+		MaxDataAge:     Duration(900 * time.Second),
+		ReloadInterval: Duration(10 * time.Second),
+		Log: Log{
+			Level:      "info",
+			File:       "log/error.log",
+			MaxSizeMB:  100,
+			MaxBackups: 10,
+			MaxAgeDays: 30,
+		},
+		Intervals: Intervals{
+			ListToken:    Duration(300 * time.Second),
+			RateUSD:      Duration(300 * time.Second),
+			Rate7d:       Duration(300 * time.Second),
+			Rate:         Duration(15 * time.Second),
+			RateFallback: Duration(300 * time.Second),
+			Block:        Duration(5 * time.Second),
+			Connections:  Duration(30 * time.Second),
+		},
+		StreamBuffer: 64,
+		RateLimit: RateLimit{
+			Store: "memory",
+		},
+		CORS: corsProfile("testnet"),
+		HTTPClient: HTTPClient{
+			Timeout:         Duration(5 * time.Second),
+			Retries:         2,
+			MaxBackoff:      Duration(10 * time.Second),
+			BreakerFailures: 5,
+			BreakerCooldown: Duration(30 * time.Second),
+		},
+		Verification: Verification{
+			Pairs:      3,
+			Quarantine: Duration(10 * time.Minute),
+		},
+		Mock: Mock{
+			Seed:   1,
+			Tokens: 12,
+			Step:   Duration(15 * time.Second),
+		},
+	}
+}
+
+// DefaultPath return the config file of an environment
+func DefaultPath(env string) string {
+	switch env {
+	case "staging", "production", MockEnv:
+		return fmt.Sprintf("env/%s.json", env)
+	}
+	return "env/testnet.json"
+}
+
+type flags struct {
+	set map[string]bool
+
+	path       string
+	env        string
+	listenAddr string
+	boltPath   string
+	logLevel   string
+	logStdout  bool
+	mock       bool
+}
+
+func parseFlags(args []string) (*flags, error) {
+	f := &flags{set: make(map[string]bool)}
+	fs := flag.NewFlagSet("server-api", flag.ContinueOnError)
+	fs.StringVar(&f.path, "config", "", "config file (default env/<CHAINTEX_ENV>.json)")
+	fs.StringVar(&f.env, "env", "", "environment: testnet, staging, production or mock (overrides CHAINTEX_ENV)")
+	fs.BoolVar(&f.mock, "mock", false, "serve synthetic data without upstream, as -env mock")
+	fs.StringVar(&f.listenAddr, "listen", "", "HTTP listen address (overrides LISTEN_ADDR)")
+	fs.StringVar(&f.boltPath, "bolt-path", "", "bolt database file (overrides BOLT_PATH)")
+	fs.StringVar(&f.logLevel, "log-level", "", "debug, info, warn or error (overrides LOG_LEVEL)")
+	fs.BoolVar(&f.logStdout, "log-stdout", false, "log to stdout instead of file (overrides LOG_TO_STDOUT)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	fs.Visit(func(fl *flag.Flag) {
+		f.set[fl.Name] = true
+	})
+	return f, nil
+}
+
+// Load read the config file, overlay environment variables then command line
+// flags, and validate the result. Every problem found is reported in the
+// returned Errors.
+func Load(args []string) (*Config, error) {
+	f, err := parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := Default()
+	if env := os.Getenv("CHAINTEX_ENV"); env != "" {
+		cfg.Env = env
+	}
+	if f.set["env"] {
+		cfg.Env = f.env
+	}
+	if f.mock {
+		cfg.Env = MockEnv
+	}
+	cfg.Path = DefaultPath(cfg.Env)
+	cfg.CORS = corsProfile(cfg.Env)
+	if f.set["config"] {
+		cfg.Path = f.path
+	}
+
+	file, err := ioutil.ReadFile(cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	if err = json.Unmarshal(file, cfg); err != nil {
+		return nil, fmt.Errorf("%s: %v", cfg.Path, err)
+	}
+
+	var errs Errors
+	errs = append(errs, cfg.applyEnv()...)
+	cfg.applyFlags(f)
+	if cfg.DefaultNetwork == "" {
+		cfg.DefaultNetwork = cfg.Env
+	}
+	errs = append(errs, cfg.Validate()...)
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return cfg, nil
+}
+
+func (cfg *Config) applyEnv() Errors {
+	var errs Errors
+	str := func(name string, dst *string) {
+		if value, ok := os.LookupEnv(name); ok {
+			*dst = value
+		}
+	}
+	integer := func(name string, dst *int) {
+		if value, ok := os.LookupEnv(name); ok {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: invalid integer %q", name, value))
+				return
+			}
+			*dst = n
+		}
+	}
+	duration := func(name string, dst *Duration) {
+		if value, ok := os.LookupEnv(name); ok {
+			d, err := ParseDuration(value)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %v", name, err))
+				return
+			}
+			*dst = d
+		}
+	}
+	// list read comma separated values
+	list := func(name string, dst *[]string) {
+		if value, ok := os.LookupEnv(name); ok {
+			*dst = nil
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); item != "" {
+					*dst = append(*dst, item)
+				}
+			}
+		}
+	}
+
+	str("LISTEN_ADDR", &cfg.ListenAddr)
+	str("BOLT_PATH", &cfg.BoltPath)
+	str("ADMIN_TOKEN", &cfg.AdminToken)
+	str("ADMIN_SECRET", &cfg.AdminSecret)
+	duration("MAX_DATA_AGE", &cfg.MaxDataAge)
+	duration("CONFIG_RELOAD_INTERVAL", &cfg.ReloadInterval)
+
+	str("LOG_LEVEL", &cfg.Log.Level)
+	str("LOG_FILE", &cfg.Log.File)
+	if value, ok := os.LookupEnv("LOG_TO_STDOUT"); ok {
+		cfg.Log.ToStdout = value == "true"
+	}
+	integer("LOG_MAX_SIZE_MB", &cfg.Log.MaxSizeMB)
+	integer("LOG_MAX_BACKUPS", &cfg.Log.MaxBackups)
+	integer("LOG_MAX_AGE_DAYS", &cfg.Log.MaxAgeDays)
+
+	duration("INTERVAL_LIST_TOKEN", &cfg.Intervals.ListToken)
+	duration("INTERVAL_RATE_USD", &cfg.Intervals.RateUSD)
+	duration("INTERVAL_GENERAL_INFO", &cfg.Intervals.GeneralInfo)
+	duration("INTERVAL_RATE_7D", &cfg.Intervals.Rate7d)
+	duration("INTERVAL_RATE", &cfg.Intervals.Rate)
+	duration("INTERVAL_RATE_FALLBACK", &cfg.Intervals.RateFallback)
+	duration("INTERVAL_BLOCK", &cfg.Intervals.Block)
+	duration("INTERVAL_CONNECTIONS", &cfg.Intervals.Connections)
+	integer("STREAM_BUFFER", &cfg.StreamBuffer)
+	str("RATE_LIMIT_STORE", &cfg.RateLimit.Store)
+	str("RATE_LIMIT_REDIS_ADDR", &cfg.RateLimit.RedisAddr)
+	str("RATE_LIMIT_REDIS_PASSWORD", &cfg.RateLimit.RedisPassword)
+	list("RATE_LIMIT_TRUSTED_PROXIES", &cfg.RateLimit.TrustedProxies)
+	list("CORS_ALLOW_ORIGINS", &cfg.CORS.AllowOrigins)
+	list("CORS_EXTENSION_IDS", &cfg.CORS.ExtensionIDs)
+	if value, ok := os.LookupEnv("CORS_FIREFOX_EXTENSIONS"); ok {
+		cfg.CORS.FirefoxExtensions = value == "true"
+	}
+	duration("CORS_MAX_AGE", &cfg.CORS.MaxAge)
+	str("TLS_CERT_FILE", &cfg.TLS.CertFile)
+	str("TLS_KEY_FILE", &cfg.TLS.KeyFile)
+	str("TLS_REDIRECT_ADDR", &cfg.TLS.RedirectAddr)
+	duration("HTTP_CLIENT_TIMEOUT", &cfg.HTTPClient.Timeout)
+	integer("HTTP_CLIENT_RETRIES", &cfg.HTTPClient.Retries)
+	duration("VERIFY_INTERVAL", &cfg.Verification.Interval)
+	duration("VERIFY_QUARANTINE", &cfg.Verification.Quarantine)
+	str("UPSTREAM_MODE", &cfg.Upstream.Mode)
+	str("UPSTREAM_FIXTURES", &cfg.Upstream.Fixtures)
+	integer("MOCK_SEED", &cfg.Mock.Seed)
+	integer("MOCK_TOKENS", &cfg.Mock.Tokens)
+	duration("MOCK_STEP", &cfg.Mock.Step)
+
+	str("DEFAULT_NETWORK", &cfg.DefaultNetwork)
+	// only the top level chain, networks set their own endpoint
+	str("CONFIG_ENDPOINT", &cfg.ConfigEndpoint)
+	return errs
+}
+
+func (cfg *Config) applyFlags(f *flags) {
+	if f.set["listen"] {
+		cfg.ListenAddr = f.listenAddr
+	}
+	if f.set["bolt-path"] {
+		cfg.BoltPath = f.boltPath
+	}
+	if f.set["log-level"] {
+		cfg.Log.Level = f.logLevel
+	}
+	if f.set["log-stdout"] {
+		cfg.Log.ToStdout = f.logStdout
+	}
+}
@@ -0,0 +1,54 @@
+package config
+
+import "testing"
+
+func TestCORSProfile(t *testing.T) {
+	// production ship no origin of its own, they are configured
+	production := corsProfile("production")
+	if origins := production.Origins(); len(origins) != 0 {
+		t.Errorf("production profile allows %v", origins)
+	}
+	if errs := production.validate(true); len(errs) == 0 {
+		t.Error("production accepted without origins")
+	}
+
+	production.AllowOrigins = []string{"https://wallet.example.com", "https://*.wallet.example.com"}
+	production.ExtensionIDs = []string{"abcdefghijklmnopabcdefghijklmnop"}
+	production.FirefoxExtensions = true
+	if errs := production.validate(true); len(errs) > 0 {
+		t.Errorf("configured production profile invalid: %v", errs)
+	}
+	want := []string{
+		"https://wallet.example.com",
+		"https://*.wallet.example.com",
+		"chrome-extension://abcdefghijklmnopabcdefghijklmnop",
+		"moz-extension://*",
+	}
+	origins := production.Origins()
+	if len(origins) != len(want) {
+		t.Fatalf("origins %v, want %v", origins, want)
+	}
+	for i := range want {
+		if origins[i] != want[i] {
+			t.Errorf("origins[%d] %q, want %q", i, origins[i], want[i])
+		}
+	}
+
+	// extensions alone are enough
+	production.AllowOrigins = nil
+	if errs := production.validate(true); len(errs) > 0 {
+		t.Errorf("extension only production profile invalid: %v", errs)
+	}
+	production.ExtensionIDs = []string{"chrome-extension://abcdefghijklmnopabcdefghijklmnop"}
+	if errs := production.validate(true); len(errs) == 0 {
+		t.Error("invalid extension id accepted")
+	}
+
+	testnet := corsProfile("testnet")
+	if errs := testnet.validate(false); len(errs) > 0 {
+		t.Errorf("testnet profile invalid: %v", errs)
+	}
+	if errs := testnet.validate(true); len(errs) == 0 {
+		t.Error("* accepted in production")
+	}
+}
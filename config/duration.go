@@ -0,0 +1,54 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// Duration is a time.Duration read from "15s" style strings or plain seconds
+type Duration time.Duration
+
+// ParseDuration accept a Go duration string or a number of seconds
+func ParseDuration(value string) (Duration, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return Duration(time.Duration(seconds) * time.Second), nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	return Duration(d), nil
+}
+
+// UnmarshalJSON implement json.Unmarshaler
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(b, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(time.Duration(v * float64(time.Second)))
+		return nil
+	case string:
+		parsed, err := ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = parsed
+		return nil
+	}
+	return fmt.Errorf("invalid duration %s", b)
+}
+
+// MarshalJSON implement json.Marshaler
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Std return the value as time.Duration
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
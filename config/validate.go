@@ -0,0 +1,436 @@
+package config
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/tomochain/tomochain/accounts/abi"
+	"github.com/tomochain/tomochain/common"
+)
+
+var (
+	topicRegexp = regexp.MustCompile(`^0x[0-9a-fA-F]{64}$`)
+	// network names are used in urls
+	networkNameRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+	// Chrome extension ids are 32 letters a to p
+	extensionIDRegexp = regexp.MustCompile(`^[a-p]{32}$`)
+)
+
+// connectionTypes are the fetchers NewFetcherIns know how to build, with the
+// url schemes of their endpoint
+var connectionTypes = map[string][]string{
+	"node":     {"http", "https"},
+	"tomoscan": {"http", "https"},
+	"ws":       {"ws", "wss"},
+	// in-process network contract, sim://<name>
+	"simulated": {"sim"},
+}
+
+// rateLimitGroups are the route groups the http server throttle
+var rateLimitGroups = map[string]bool{
+	"data":    true,
+	"history": true,
+	"stream":  true,
+}
+
+// Errors collect every problem found in a config
+type Errors []error
+
+func (errs Errors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, err := range errs {
+		msgs = append(msgs, err.Error())
+	}
+	return fmt.Sprintf("invalid config (%d errors):\n  %s", len(errs), strings.Join(msgs, "\n  "))
+}
+
+func validURL(value string, schemes ...string) bool {
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return false
+	}
+	for _, scheme := range schemes {
+		if u.Scheme == scheme {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate check the whole config and return every error found
+func (cfg *Config) Validate() Errors {
+	var errs Errors
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.ListenAddr); err != nil {
+		add("listen_addr: %v", err)
+	}
+	if cfg.BoltPath == "" {
+		add("bolt_path: must not be empty")
+	}
+	if cfg.MaxDataAge <= 0 {
+		add("max_data_age: must be positive")
+	}
+	if cfg.ReloadInterval < 0 {
+		add("reload_interval: must not be negative")
+	}
+	if cfg.StreamBuffer <= 0 {
+		add("stream_buffer: must be positive")
+	}
+
+	errs = append(errs, cfg.RateLimit.validate()...)
+	errs = append(errs, cfg.CORS.validate(cfg.Env == "production")...)
+	errs = append(errs, cfg.TLS.validate()...)
+	if cfg.HTTPClient.Timeout <= 0 || cfg.HTTPClient.MaxBackoff <= 0 || cfg.HTTPClient.BreakerCooldown <= 0 {
+		add("http_client: timeout, max_backoff and breaker_cooldown must be positive")
+	}
+	for host, timeout := range cfg.HTTPClient.HostTimeouts {
+		if timeout <= 0 {
+			add("http_client.host_timeouts.%s: must be positive", host)
+		}
+	}
+	if cfg.HTTPClient.Retries < 0 || cfg.HTTPClient.BreakerFailures < 1 {
+		add("http_client: retries must not be negative and breaker_failures at least 1")
+	}
+
+	if cfg.Verification.Interval < 0 {
+		add("verification.interval: must not be negative")
+	}
+	if cfg.Verification.Interval > 0 && (cfg.Verification.Pairs < 0 || cfg.Verification.Quarantine <= 0) {
+		add("verification: pairs must not be negative and quarantine must be positive")
+	}
+
+	if cfg.Mocked() {
+		if cfg.Mock.Tokens < 1 || cfg.Mock.Tokens > 200 {
+			add("mock.tokens: must be between 1 and 200")
+		}
+		if cfg.Mock.Step < 0 {
+			add("mock.step: must not be negative")
+		}
+		if cfg.Upstream.Mode != "" {
+			add("upstream.mode: must be empty in the mock environment")
+		}
+	}
+
+	switch cfg.Upstream.Mode {
+	case "":
+	case UpstreamRecord, UpstreamReplay:
+		if cfg.Upstream.Fixtures == "" {
+			add("upstream.fixtures: must not be empty in %s mode", cfg.Upstream.Mode)
+		}
+	default:
+		add("upstream.mode: must be empty, %s or %s", UpstreamRecord, UpstreamReplay)
+	}
+
+	if _, err := log.ParseLevel(cfg.Log.Level); err != nil {
+		add("log.level: %v", err)
+	}
+	if !cfg.Log.ToStdout && cfg.Log.File == "" {
+		add("log.file: must not be empty when not logging to stdout")
+	}
+	if cfg.Log.MaxSizeMB <= 0 || cfg.Log.MaxBackups < 0 || cfg.Log.MaxAgeDays < 0 {
+		add("log: max_size_mb must be positive, max_backups and max_age_days not negative")
+	}
+
+	intervals := []struct {
+		name  string
+		value Duration
+	}{
+		{"list_token", cfg.Intervals.ListToken},
+		{"rate_usd", cfg.Intervals.RateUSD},
+		{"rate_7d", cfg.Intervals.Rate7d},
+		{"rate", cfg.Intervals.Rate},
+		{"rate_fallback", cfg.Intervals.RateFallback},
+		{"block", cfg.Intervals.Block},
+		{"connections", cfg.Intervals.Connections},
+	}
+	for _, interval := range intervals {
+		if interval.value <= 0 {
+			add("intervals.%s: must be positive", interval.name)
+		}
+	}
+	if cfg.Intervals.GeneralInfo < 0 {
+		add("intervals.general_info: must not be negative")
+	}
+
+	if len(cfg.Networks) == 0 {
+		errs = append(errs, cfg.Chain.Validate()...)
+	} else {
+		for _, name := range cfg.NetworkNames() {
+			chain := cfg.Networks[name]
+			for _, err := range chain.Validate() {
+				add("networks.%s.%v", name, err)
+			}
+		}
+	}
+	for _, name := range cfg.NetworkNames() {
+		if !networkNameRegexp.MatchString(name) {
+			add("network %q: name must match %s", name, networkNameRegexp)
+		}
+	}
+	if _, ok := cfg.Chains()[cfg.DefaultNetwork]; !ok {
+		add("default_network: unknown network %q", cfg.DefaultNetwork)
+	}
+	for i, hook := range cfg.Webhooks {
+		if !validURL(hook.URL, "http", "https") {
+			add("webhooks[%d].url: must be an http or https url", i)
+		}
+		for _, name := range hook.Networks {
+			if _, ok := cfg.Chains()[name]; !ok {
+				add("webhooks[%d].networks: unknown network %q", i, name)
+			}
+		}
+	}
+	return errs
+}
+
+func (t *TLS) validate() Errors {
+	var errs Errors
+	if t.CertFile == "" && t.KeyFile == "" {
+		if t.RedirectAddr != "" {
+			errs = append(errs, fmt.Errorf("tls.redirect_addr: needs cert_file and key_file"))
+		}
+		return errs
+	}
+	if _, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile); err != nil {
+		errs = append(errs, fmt.Errorf("tls: %v", err))
+	}
+	if t.RedirectAddr != "" {
+		if _, _, err := net.SplitHostPort(t.RedirectAddr); err != nil {
+			errs = append(errs, fmt.Errorf("tls.redirect_addr: %v", err))
+		}
+	}
+	return errs
+}
+
+// originSchemes are the schemes a CORS origin may use
+var originSchemes = []string{
+	"https://", "http://",
+	"chrome-extension://", "moz-extension://", "safari-extension://", "ms-browser-extension://",
+}
+
+// corsMethods are the methods of the public routes
+var corsMethods = map[string]bool{
+	"GET":     true,
+	"HEAD":    true,
+	"OPTIONS": true,
+	"POST":    true,
+}
+
+// validate check the policy, production only accept https and extension
+// origins and refuse *
+func (cors *CORS) validate(production bool) Errors {
+	var errs Errors
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if len(cors.Origins()) == 0 {
+		add("cors.allow_origins: must not be empty, list the wallet origins or set cors.extension_ids")
+	}
+	for i, id := range cors.ExtensionIDs {
+		if !extensionIDRegexp.MatchString(id) {
+			add("cors.extension_ids[%d]: invalid Chrome extension id %q", i, id)
+		}
+	}
+	for i, origin := range cors.AllowOrigins {
+		if origin == "*" {
+			if production {
+				add("cors.allow_origins[%d]: * is not allowed in production", i)
+			}
+			continue
+		}
+		if strings.Count(origin, "*") > 1 {
+			add("cors.allow_origins[%d]: only one * is allowed in %q", i, origin)
+		}
+		scheme := ""
+		for _, prefix := range originSchemes {
+			if strings.HasPrefix(origin, prefix) {
+				scheme = prefix
+				break
+			}
+		}
+		if scheme == "" || len(origin) == len(scheme) || strings.HasSuffix(origin, "/") {
+			add("cors.allow_origins[%d]: invalid origin %q", i, origin)
+		} else if production && scheme == "http://" {
+			add("cors.allow_origins[%d]: %q must use https in production", i, origin)
+		}
+	}
+	if len(cors.AllowMethods) == 0 {
+		add("cors.allow_methods: must not be empty")
+	}
+	for i, method := range cors.AllowMethods {
+		if !corsMethods[method] {
+			add("cors.allow_methods[%d]: unsupported method %q", i, method)
+		}
+	}
+	if cors.MaxAge < 0 {
+		add("cors.max_age: must not be negative")
+	}
+	return errs
+}
+
+func (rateLimit *RateLimit) validate() Errors {
+	var errs Errors
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	switch rateLimit.Store {
+	case "memory":
+	case "redis":
+		if _, _, err := net.SplitHostPort(rateLimit.RedisAddr); err != nil {
+			add("rate_limit.redis_addr: %v", err)
+		}
+	default:
+		add("rate_limit.store: unknown store %q, memory or redis", rateLimit.Store)
+	}
+	for name, limit := range rateLimit.Groups {
+		if !rateLimitGroups[name] {
+			add("rate_limit.groups.%s: unknown group, data, history or stream", name)
+		}
+		if limit.Rate <= 0 || limit.Burst < 1 {
+			add("rate_limit.groups.%s: rate must be positive and burst at least 1", name)
+		}
+	}
+	seen := make(map[string]bool, len(rateLimit.APIKeys))
+	for i, apiKey := range rateLimit.APIKeys {
+		if apiKey.Name == "" || apiKey.Key == "" {
+			add("rate_limit.api_keys[%d]: name and key must not be empty", i)
+		}
+		if seen[apiKey.Key] {
+			add("rate_limit.api_keys[%d]: duplicate key", i)
+		}
+		seen[apiKey.Key] = true
+		if apiKey.Rate <= 0 || apiKey.Burst < 1 {
+			add("rate_limit.api_keys[%d]: rate must be positive and burst at least 1", i)
+		}
+	}
+	if _, err := rateLimit.Proxies(); err != nil {
+		add("rate_limit.trusted_proxies: %v", err)
+	}
+	return errs
+}
+
+// Validate check addresses, connections and tokens of a chain
+func (chain *Chain) Validate() Errors {
+	var errs Errors
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	if !common.IsHexAddress(chain.Network) {
+		add("network: invalid contract address %q", chain.Network)
+	}
+	if chain.TradeTopic != "" && !topicRegexp.MatchString(chain.TradeTopic) {
+		add("trade_topic: invalid topic %q", chain.TradeTopic)
+	}
+	if chain.AverageBlockTime <= 0 {
+		add("averageBlockTime: must be positive")
+	}
+	if chain.NetworkAbi != "" {
+		if _, err := abi.JSON(strings.NewReader(chain.NetworkAbi)); err != nil {
+			add("network_abi: %v", err)
+		}
+	}
+
+	if len(chain.Connections) == 0 {
+		add("connections: must not be empty")
+	}
+	for i, connection := range chain.Connections {
+		schemes, ok := connectionTypes[connection.Type]
+		if !ok {
+			add("connections[%d].type: unknown type %q", i, connection.Type)
+			continue
+		}
+		if !validURL(connection.Endpoint, schemes...) {
+			add("connections[%d].endPoint: invalid %s url %q", i, connection.Type, connection.Endpoint)
+		}
+		if connection.RateLimit < 0 {
+			add("connections[%d].rate_limit: must not be negative", i)
+		}
+	}
+
+	endpoints := []struct {
+		name  string
+		value string
+	}{
+		{"gasstation_endpoint", chain.GasStationEndpoint},
+		{"api_endpoint", chain.APIEndpoint},
+		{"config_endpoint", chain.ConfigEndpoint},
+	}
+	for _, endpoint := range endpoints {
+		if endpoint.value != "" && !validURL(endpoint.value, "http", "https") {
+			add("%s: invalid url %q", endpoint.name, endpoint.value)
+		}
+	}
+
+	errs = append(errs, chain.TokenSources.validate(chain.ConfigEndpoint)...)
+
+	symbols := make(map[string]bool)
+	for i, token := range chain.Tokens {
+		if token.Symbol == "" {
+			add("tokens[%d].symbol: must not be empty", i)
+		} else if symbols[token.Symbol] {
+			add("tokens[%d].symbol: duplicate symbol %s", i, token.Symbol)
+		}
+		symbols[token.Symbol] = true
+		if !common.IsHexAddress(token.Address) {
+			add("tokens[%d] (%s).address: invalid address %q", i, token.Symbol, token.Address)
+		}
+		if token.Decimals < 0 || token.Decimals > 77 {
+			add("tokens[%d] (%s).decimals: out of range", i, token.Symbol)
+		}
+	}
+	return errs
+}
+
+// validate check the sources listed and the settings they need
+func (sources *TokenSources) validate(configEndpoint string) Errors {
+	var errs Errors
+	add := func(format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, args...))
+	}
+
+	seen := make(map[string]bool)
+	for i, source := range sources.Precedence {
+		switch source {
+		case TokenSourceConfig:
+			if configEndpoint == "" {
+				add("token_sources.precedence[%d]: %s needs config_endpoint", i, source)
+			}
+		case TokenSourceRegistry:
+		case TokenSourceList:
+			if !validURL(sources.List, "http", "https") {
+				add("token_sources.list: invalid url %q", sources.List)
+			}
+			if sources.Signature != "" && !validURL(sources.Signature, "http", "https") {
+				add("token_sources.signature: invalid url %q", sources.Signature)
+			}
+			if _, err := sources.Key(); err != nil {
+				add("token_sources.public_key: %v", err)
+			}
+		default:
+			add("token_sources.precedence[%d]: unknown source %q, %s, %s or %s",
+				i, source, TokenSourceConfig, TokenSourceRegistry, TokenSourceList)
+		}
+		if seen[source] {
+			add("token_sources.precedence[%d]: duplicate source %s", i, source)
+		}
+		seen[source] = true
+	}
+	if sources.Registry != "" && !common.IsHexAddress(sources.Registry) {
+		add("token_sources.registry: invalid contract address %q", sources.Registry)
+	}
+	if sources.ChainID < 0 {
+		add("token_sources.chain_id: must not be negative")
+	}
+	return errs
+}
@@ -0,0 +1,84 @@
+package config
+
+import (
+	"context"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Watcher reload the config when its file change or when asked to, and
+// hand every valid new version to OnChange
+type Watcher struct {
+	mu       sync.Mutex
+	args     []string
+	current  *Config
+	modTime  time.Time
+	onChange func(*Config) error
+}
+
+// NewWatcher start from cfg, loaded from args, and call onChange on reload
+func NewWatcher(cfg *Config, args []string, onChange func(*Config) error) *Watcher {
+	watcher := &Watcher{
+		args:     args,
+		current:  cfg,
+		onChange: onChange,
+	}
+	if info, err := os.Stat(cfg.Path); err == nil {
+		watcher.modTime = info.ModTime()
+	}
+	return watcher
+}
+
+// Current return the last config applied
+func (watcher *Watcher) Current() *Config {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	return watcher.current
+}
+
+// Reload read the config again. An invalid file is reported and the running
+// config is kept.
+func (watcher *Watcher) Reload() error {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	if info, err := os.Stat(watcher.current.Path); err == nil {
+		watcher.modTime = info.ModTime()
+	}
+	cfg, err := Load(watcher.args)
+	if err != nil {
+		return err
+	}
+	if err = watcher.onChange(cfg); err != nil {
+		return err
+	}
+	watcher.current = cfg
+	log.WithField("config", cfg.Path).Info("config reloaded")
+	return nil
+}
+
+// Watch poll the file modification time every interval until ctx is done
+func (watcher *Watcher) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		watcher.mu.Lock()
+		path, last := watcher.current.Path, watcher.modTime
+		watcher.mu.Unlock()
+		info, err := os.Stat(path)
+		if err != nil || !info.ModTime().After(last) {
+			continue
+		}
+		log.WithField("config", path).Info("config file changed, reloading")
+		if err := watcher.Reload(); err != nil {
+			log.WithField("config", path).WithError(err).Error("cannot reload config")
+		}
+	}
+}
@@ -2,11 +2,16 @@ package bfetcher
 
 import (
 	"context"
-	"log"
+	"fmt"
+	"math/big"
+	"net/http"
 	"time"
 
 	// "strconv"
 
+	"github.com/marknguyen85/server-api/httpclient"
+	"github.com/marknguyen85/server-api/metrics"
+	"github.com/marknguyen85/server-api/tomochain"
 	"github.com/tomochain/tomochain/common/hexutil"
 	"github.com/tomochain/tomochain/rpc"
 )
@@ -20,11 +25,15 @@ type BlockchainFetcher struct {
 }
 
 func NewBlockchainFetcher(typeName string, endpoint string, apiKey string) (*BlockchainFetcher, error) {
-	client, err := rpc.DialHTTP(endpoint)
+	// through the transport of the upstream client, recorded or replayed with it
+	client, err := rpc.DialHTTPWithClient(endpoint, &http.Client{Transport: httpclient.Default().Transport()})
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
+	return newBlockchainFetcher(client, typeName, endpoint), nil
+}
+
+func newBlockchainFetcher(client *rpc.Client, typeName string, endpoint string) *BlockchainFetcher {
 	timeout := 5 * time.Second
 	blockchain := BlockchainFetcher{
 		client:   client,
@@ -32,51 +41,51 @@ func NewBlockchainFetcher(typeName string, endpoint string, apiKey string) (*Blo
 		TypeName: typeName,
 		timeout:  timeout,
 	}
-	return &blockchain, nil
+	return &blockchain
 }
 
-//TomoCall func
-func (blcFetcher *BlockchainFetcher) TomoCall(to string, data string) (string, error) {
-	params := make(map[string]string)
-	params["data"] = "0x" + data
-	params["to"] = to
-
-	ctx, cancel := context.WithTimeout(context.Background(), blcFetcher.timeout)
+// call run a json rpc method within fetcher timeout and record it in upstream metrics
+func (blcFetcher *BlockchainFetcher) call(ctx context.Context, result interface{}, method string, args ...interface{}) error {
+	start := time.Now()
+	ctx, cancel := context.WithTimeout(ctx, blcFetcher.timeout)
 	defer cancel()
-	var result string
-	err := blcFetcher.client.CallContext(ctx, &result, "eth_call", params, "latest")
-	if err != nil {
-		log.Print(err)
-		return "", err
-	}
+	err := blcFetcher.client.CallContext(ctx, result, method, args...)
+	metrics.ObserveUpstream(blcFetcher.TypeName, start, err)
+	return err
+}
 
-	return result, nil
+//TomoCall func
+func (blcFetcher *BlockchainFetcher) TomoCall(ctx context.Context, to string, data string, block string) (string, error) {
+	return blcFetcher.ethCall(ctx, to, data, block)
 }
 
 //GetRate func
-func (blcFetcher *BlockchainFetcher) GetRate(to string, data string) (string, error) {
+func (blcFetcher *BlockchainFetcher) GetRate(ctx context.Context, to string, data string, block string) (string, error) {
+	return blcFetcher.ethCall(ctx, to, data, block)
+}
+
+func (blcFetcher *BlockchainFetcher) ethCall(ctx context.Context, to string, data string, block string) (string, error) {
+	tag, err := blockTag(block)
+	if err != nil {
+		return "", err
+	}
 	params := make(map[string]string)
 	params["data"] = "0x" + data
 	params["to"] = to
 
-	ctx, cancel := context.WithTimeout(context.Background(), blcFetcher.timeout)
-	defer cancel()
 	var result string
-	err := blcFetcher.client.CallContext(ctx, &result, "eth_call", params, "latest")
+	err = blcFetcher.call(ctx, &result, "eth_call", params, tag)
 	if err != nil {
 		return "", err
 	}
 
 	return result, nil
-
 }
 
 //GetLatestBlock func
-func (blcFetcher *BlockchainFetcher) GetLatestBlock() (string, error) {
+func (blcFetcher *BlockchainFetcher) GetLatestBlock(ctx context.Context) (string, error) {
 	var blockNum *hexutil.Big
-	ctx, cancel := context.WithTimeout(context.Background(), blcFetcher.timeout)
-	defer cancel()
-	err := blcFetcher.client.CallContext(ctx, &blockNum, "eth_blockNumber", "latest")
+	err := blcFetcher.call(ctx, &blockNum, "eth_blockNumber", "latest")
 	if err != nil {
 		return "", err
 	}
@@ -91,6 +100,46 @@ type TopicParam struct {
 	Topics    []string `json:"topics"`
 }
 
+//GetEvents func
+func (blcFetcher *BlockchainFetcher) GetEvents(ctx context.Context, fromBlock, toBlock, network, topic string) (*[]tomochain.EventRaw, error) {
+	from, err := blockTag(fromBlock)
+	if err != nil {
+		return nil, err
+	}
+	to, err := blockTag(toBlock)
+	if err != nil {
+		return nil, err
+	}
+	param := TopicParam{
+		FromBlock: from,
+		ToBlock:   to,
+		Address:   network,
+		Topics:    []string{topic},
+	}
+	var result []tomochain.EventRaw
+	err = blcFetcher.call(ctx, &result, "eth_getLogs", param)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// LatestBlock is the block tag of the calls not pinned to a block
+const LatestBlock = "latest"
+
+// blockTag turn a decimal block number into the hex quantity of json rpc,
+// LatestBlock is kept as is
+func blockTag(block string) (string, error) {
+	if block == LatestBlock {
+		return block, nil
+	}
+	number, ok := new(big.Int).SetString(block, 10)
+	if !ok {
+		return "", fmt.Errorf("invalid block number %q", block)
+	}
+	return hexutil.EncodeBig(number), nil
+}
+
 //GetTypeName get type name
 func (blcFetcher *BlockchainFetcher) GetTypeName() string {
 	return blcFetcher.TypeName
@@ -0,0 +1,403 @@
+package bfetcher
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marknguyen85/server-api/tomochain"
+	"github.com/tomochain/tomochain/accounts/abi"
+	"github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/common/hexutil"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+const (
+	// SimulatedBlockTime is the time between two blocks of a simulated network
+	SimulatedBlockTime = 2 * time.Second
+	// simulatedHistory is how many blocks, and trades, a simulated network keep
+	simulatedHistory = 4096
+)
+
+// simulatedNetworkAbi is the part of the ChainTeX network contract a
+// simulated network implement, with the token registry and the erc20
+// metadata of the tokens it lists
+const simulatedNetworkAbi = `[{"constant":true,"inputs":[],"name":"getListedTokens","outputs":[{"name":"","type":"address[]"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"name","outputs":[{"name":"","type":"string"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"symbol","outputs":[{"name":"","type":"string"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"enabled","outputs":[{"name":"","type":"bool"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"maxGasPrice","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[{"name":"src","type":"address"},{"name":"dest","type":"address"},{"name":"srcQty","type":"uint256"}],"name":"getExpectedRate","outputs":[{"name":"expectedRate","type":"uint256"},{"name":"slippageRate","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"anonymous":false,"inputs":[{"indexed":true,"name":"trader","type":"address"},{"indexed":false,"name":"src","type":"address"},{"indexed":false,"name":"dest","type":"address"},{"indexed":false,"name":"actualSrcAmount","type":"uint256"},{"indexed":false,"name":"actualDestAmount","type":"uint256"}],"name":"ExecuteTrade","type":"event"}]`
+
+var (
+	// ErrExecutionReverted is the error of a call the simulated contract reject
+	ErrExecutionReverted = errors.New("execution reverted")
+	// ErrUnknownBlock is the error of a call at a block not mined yet
+	ErrUnknownBlock = errors.New("header not found")
+	// ErrPrunedBlock is the error of a call at a block too old to be kept
+	ErrPrunedBlock = errors.New("missing trie node")
+
+	simulatedAbi = mustParseAbi(simulatedNetworkAbi)
+
+	simulationsMu sync.Mutex
+	simulations   = make(map[string]*SimulatedNetwork)
+)
+
+func mustParseAbi(definition string) abi.ABI {
+	parsed, err := abi.JSON(strings.NewReader(definition))
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}
+
+// SimulatedRate is the answer of getExpectedRate from Src to Dest
+type SimulatedRate struct {
+	Src      string
+	Dest     string
+	Expected *big.Int
+	Slippage *big.Int
+}
+
+// SimulatedToken is a token of the registry, its erc20 contract answer
+// name, symbol and decimals
+type SimulatedToken struct {
+	Address  string
+	Name     string
+	Symbol   string
+	Decimals uint8
+}
+
+// simulatedRate is the answer of getExpectedRate for a pair
+type simulatedRate struct {
+	expected *big.Int
+	slippage *big.Int
+}
+
+// simulatedState is the storage of the contract after a block
+type simulatedState struct {
+	time        uint64
+	enabled     bool
+	maxGasPrice *big.Int
+	rates       map[string]simulatedRate
+	// listed by the registry, in order
+	tokens []SimulatedToken
+}
+
+func (state simulatedState) clone() simulatedState {
+	rates := make(map[string]simulatedRate, len(state.rates))
+	for pair, rate := range state.rates {
+		rates[pair] = rate
+	}
+	state.rates = rates
+	return state
+}
+
+// simulatedLog is an ExecuteTrade event
+type simulatedLog struct {
+	block uint64
+	raw   tomochain.EventRaw
+}
+
+// SimulatedNetwork is an in-process ChainTeX network contract on its own
+// chain. Every change is mined in a new block and the state of the last
+// blocks is kept, so calls pinned to a block answer as the chain did then. The
+// contract answer enabled, maxGasPrice and getExpectedRate, and emit
+// ExecuteTrade for the trades it is told of. It is the same at every address,
+// which also answer getListedTokens; the tokens listed answer their erc20
+// metadata.
+type SimulatedNetwork struct {
+	mu sync.RWMutex
+	// blocks from number base
+	base   uint64
+	blocks []simulatedState
+	logs   []simulatedLog
+}
+
+// NewSimulatedNetwork start a chain whose genesis block is at genesis, with
+// the contract enabled and no rate; block 1 is mined so the chain has a head
+// past genesis like a live one
+func NewSimulatedNetwork(genesis time.Time) *SimulatedNetwork {
+	network := &SimulatedNetwork{
+		blocks: []simulatedState{{
+			time:        uint64(genesis.Unix()),
+			enabled:     true,
+			maxGasPrice: big.NewInt(50000000000),
+			rates:       make(map[string]simulatedRate),
+		}},
+	}
+	network.Mine()
+	return network
+}
+
+// Simulation return the simulated network called name, started now on its
+// first use. The connections of type simulated share it by the host of
+// their endpoint, tests set its state from here.
+func Simulation(name string) *SimulatedNetwork {
+	simulationsMu.Lock()
+	defer simulationsMu.Unlock()
+	network, ok := simulations[name]
+	if !ok {
+		network = NewSimulatedNetwork(time.Now())
+		simulations[name] = network
+	}
+	return network
+}
+
+// mine append a block whose state is the head one changed by change, and
+// return its number
+func (network *SimulatedNetwork) mine(change func(state *simulatedState)) uint64 {
+	network.mu.Lock()
+	defer network.mu.Unlock()
+	state := network.blocks[len(network.blocks)-1].clone()
+	state.time += uint64(SimulatedBlockTime / time.Second)
+	if change != nil {
+		change(&state)
+	}
+	network.blocks = append(network.blocks, state)
+	if len(network.blocks) > simulatedHistory {
+		network.blocks = network.blocks[1:]
+		network.base++
+	}
+	if len(network.logs) > simulatedHistory {
+		network.logs = network.logs[1:]
+	}
+	return network.head()
+}
+
+// head return the number of the last block, with mu held
+func (network *SimulatedNetwork) head() uint64 {
+	return network.base + uint64(len(network.blocks)) - 1
+}
+
+// at return the state after block, with mu held
+func (network *SimulatedNetwork) at(block uint64) (simulatedState, error) {
+	if block > network.head() {
+		return simulatedState{}, ErrUnknownBlock
+	}
+	if block < network.base {
+		return simulatedState{}, ErrPrunedBlock
+	}
+	return network.blocks[block-network.base], nil
+}
+
+// Mine an empty block, return its number
+func (network *SimulatedNetwork) Mine() uint64 {
+	return network.mine(nil)
+}
+
+// BlockNumber return the head of the chain
+func (network *SimulatedNetwork) BlockNumber() uint64 {
+	network.mu.RLock()
+	defer network.mu.RUnlock()
+	return network.head()
+}
+
+// BlockTime return the unix time of block
+func (network *SimulatedNetwork) BlockTime(block uint64) (uint64, error) {
+	network.mu.RLock()
+	defer network.mu.RUnlock()
+	state, err := network.at(block)
+	if err != nil {
+		return 0, err
+	}
+	return state.time, nil
+}
+
+// SetEnabled change what enabled answer, in a new block
+func (network *SimulatedNetwork) SetEnabled(enabled bool) uint64 {
+	return network.mine(func(state *simulatedState) {
+		state.enabled = enabled
+	})
+}
+
+// SetMaxGasPrice change what maxGasPrice answer, in a new block
+func (network *SimulatedNetwork) SetMaxGasPrice(gasPrice *big.Int) uint64 {
+	return network.mine(func(state *simulatedState) {
+		state.maxGasPrice = new(big.Int).Set(gasPrice)
+	})
+}
+
+// SetRate change what getExpectedRate answer from src to dest, whatever the
+// quantity, in a new block. A nil expected rate remove the pair, whose calls
+// then revert.
+func (network *SimulatedNetwork) SetRate(src, dest string, expected, slippage *big.Int) uint64 {
+	return network.SetRates([]SimulatedRate{{Src: src, Dest: dest, Expected: expected, Slippage: slippage}})
+}
+
+// SetRates change the rates of several pairs, as SetRate, in one block
+func (network *SimulatedNetwork) SetRates(rates []SimulatedRate) uint64 {
+	return network.mine(func(state *simulatedState) {
+		for _, rate := range rates {
+			pair := ratePair(common.HexToAddress(rate.Src), common.HexToAddress(rate.Dest))
+			if rate.Expected == nil {
+				delete(state.rates, pair)
+				continue
+			}
+			state.rates[pair] = simulatedRate{
+				expected: new(big.Int).Set(rate.Expected),
+				slippage: new(big.Int).Set(rate.Slippage),
+			}
+		}
+	})
+}
+
+// SetTokens replace the tokens listed by the registry, in a new block
+func (network *SimulatedNetwork) SetTokens(tokens []SimulatedToken) uint64 {
+	return network.mine(func(state *simulatedState) {
+		state.tokens = append([]SimulatedToken(nil), tokens...)
+	})
+}
+
+// Trade emit the ExecuteTrade event of a trade in a new block, return the
+// hash of its transaction
+func (network *SimulatedNetwork) Trade(trader, src, dest string, srcAmount, destAmount *big.Int) (string, error) {
+	data, err := simulatedAbi.Events["ExecuteTrade"].Inputs.NonIndexed().Pack(
+		common.HexToAddress(src), common.HexToAddress(dest), srcAmount, destAmount)
+	if err != nil {
+		return "", err
+	}
+
+	var txHash string
+	network.mine(func(state *simulatedState) {
+		// changed under the lock, the block is the one being appended
+		block := network.head() + 1
+		txHash = crypto.Keccak256Hash([]byte(fmt.Sprintf("%d/%s/%d", block, trader, len(network.logs)))).Hex()
+		network.logs = append(network.logs, simulatedLog{
+			block: block,
+			raw: tomochain.EventRaw{
+				Timestamp:   hexutil.EncodeUint64(state.time),
+				BlockNumber: hexutil.EncodeUint64(block),
+				Txhash:      txHash,
+				Data:        hexutil.Encode(data),
+			},
+		})
+	})
+	return txHash, nil
+}
+
+// Call run the call of data, hex encoded, on contract to at block, a decimal
+// number or LatestBlock, and return its hex encoded answer
+func (network *SimulatedNetwork) Call(to, data, block string) (string, error) {
+	input, err := hexutil.Decode("0x" + strings.TrimPrefix(data, "0x"))
+	if err != nil {
+		return "", err
+	}
+	if len(input) < 4 {
+		return "", ErrExecutionReverted
+	}
+	method, err := simulatedAbi.MethodById(input[:4])
+	if err != nil {
+		return "", ErrExecutionReverted
+	}
+	state, err := network.stateAt(block)
+	if err != nil {
+		return "", err
+	}
+
+	var output []byte
+	switch method.Name {
+	case "enabled":
+		output, err = method.Outputs.Pack(state.enabled)
+	case "maxGasPrice":
+		output, err = method.Outputs.Pack(state.maxGasPrice)
+	case "getExpectedRate":
+		// src and dest are the first two words of the arguments
+		args := input[4:]
+		if len(args) < 3*32 {
+			return "", ErrExecutionReverted
+		}
+		rate, ok := state.rates[ratePair(common.BytesToAddress(args[12:32]), common.BytesToAddress(args[44:64]))]
+		if !ok {
+			return "", ErrExecutionReverted
+		}
+		output, err = method.Outputs.Pack(rate.expected, rate.slippage)
+	case "getListedTokens":
+		addresses := make([]common.Address, 0, len(state.tokens))
+		for _, token := range state.tokens {
+			addresses = append(addresses, common.HexToAddress(token.Address))
+		}
+		output, err = method.Outputs.Pack(addresses)
+	case "name", "symbol", "decimals":
+		token, ok := state.token(to)
+		if !ok {
+			return "", ErrExecutionReverted
+		}
+		switch method.Name {
+		case "name":
+			output, err = method.Outputs.Pack(token.Name)
+		case "symbol":
+			output, err = method.Outputs.Pack(token.Symbol)
+		default:
+			output, err = method.Outputs.Pack(token.Decimals)
+		}
+	default:
+		return "", ErrExecutionReverted
+	}
+	if err != nil {
+		return "", err
+	}
+	return hexutil.Encode(output), nil
+}
+
+// Logs return the ExecuteTrade events between two blocks, decimal numbers or
+// LatestBlock, when topic is the one of ExecuteTrade
+func (network *SimulatedNetwork) Logs(fromBlock, toBlock, topic string) ([]tomochain.EventRaw, error) {
+	head := network.BlockNumber()
+	from, err := blockNumber(fromBlock, head)
+	if err != nil {
+		return nil, err
+	}
+	to, err := blockNumber(toBlock, head)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]tomochain.EventRaw, 0)
+	if !strings.EqualFold(topic, simulatedAbi.Events["ExecuteTrade"].Id().Hex()) {
+		return result, nil
+	}
+	network.mu.RLock()
+	defer network.mu.RUnlock()
+	for _, l := range network.logs {
+		if l.block >= from && l.block <= to {
+			result = append(result, l.raw)
+		}
+	}
+	return result, nil
+}
+
+// token return the listed token at address
+func (state simulatedState) token(address string) (SimulatedToken, bool) {
+	for _, token := range state.tokens {
+		if common.HexToAddress(token.Address) == common.HexToAddress(address) {
+			return token, true
+		}
+	}
+	return SimulatedToken{}, false
+}
+
+func (network *SimulatedNetwork) stateAt(block string) (simulatedState, error) {
+	network.mu.RLock()
+	defer network.mu.RUnlock()
+	number, err := blockNumber(block, network.head())
+	if err != nil {
+		return simulatedState{}, err
+	}
+	return network.at(number)
+}
+
+// blockNumber parse block, a decimal number or LatestBlock for head
+func blockNumber(block string, head uint64) (uint64, error) {
+	if block == LatestBlock {
+		return head, nil
+	}
+	number, err := strconv.ParseUint(block, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid block number %q", block)
+	}
+	return number, nil
+}
+
+func ratePair(src, dest common.Address) string {
+	return strings.ToLower(src.Hex() + "/" + dest.Hex())
+}
@@ -0,0 +1,71 @@
+package bfetcher
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/marknguyen85/server-api/tomochain"
+)
+
+// Simulated is a connection to a SimulatedNetwork, for tests and local runs
+// without a node
+type Simulated struct {
+	TypeName string
+	network  *SimulatedNetwork
+}
+
+// NewSimulated connect to the simulated network of endpoint, sim://<name>
+func NewSimulated(typeName string, endpoint string) (*Simulated, error) {
+	return &Simulated{
+		TypeName: typeName,
+		network:  SimulationOf(endpoint),
+	}, nil
+}
+
+// SimulationOf return the simulated network named by the host of endpoint
+func SimulationOf(endpoint string) *SimulatedNetwork {
+	name := endpoint
+	if parsed, err := url.Parse(endpoint); err == nil && parsed.Host != "" {
+		name = parsed.Host
+	}
+	return Simulation(name)
+}
+
+//TomoCall func
+func (simulated *Simulated) TomoCall(ctx context.Context, to string, data string, block string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return simulated.network.Call(to, data, block)
+}
+
+//GetRate func
+func (simulated *Simulated) GetRate(ctx context.Context, to string, data string, block string) (string, error) {
+	return simulated.TomoCall(ctx, to, data, block)
+}
+
+//GetLatestBlock func
+func (simulated *Simulated) GetLatestBlock(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	return strconv.FormatUint(simulated.network.BlockNumber(), 10), nil
+}
+
+//GetEvents func
+func (simulated *Simulated) GetEvents(ctx context.Context, fromBlock, toBlock, network, topic string) (*[]tomochain.EventRaw, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	result, err := simulated.network.Logs(fromBlock, toBlock, topic)
+	if err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+//GetTypeName func
+func (simulated *Simulated) GetTypeName() string {
+	return simulated.TypeName
+}
@@ -1,9 +1,14 @@
 package bfetcher
 
 import (
+	"context"
 	"encoding/json"
-	"errors"
-	"log"
+	"fmt"
+	"math/big"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
 
 	fCommon "github.com/marknguyen85/server-api/fetcher/fetcher-common"
 	"github.com/marknguyen85/server-api/tomochain"
@@ -13,6 +18,10 @@ import (
 // api_key for tracker.kyber
 const (
 	TIME_TO_DELETE = 18000
+
+	// DefaultTomoscanRateLimit is the requests per second of a tomoscan
+	// connection without rate_limit, the limit of a free api key
+	DefaultTomoscanRateLimit = 5
 )
 
 //Tomoscan func
@@ -20,6 +29,8 @@ type Tomoscan struct {
 	url      string
 	apiKey   string
 	TypeName string
+
+	pacer *pacer
 }
 
 //ResultEvent func
@@ -27,52 +38,156 @@ type ResultEvent struct {
 	Result []tomochain.EventRaw `json:"result"`
 }
 
-func NewTomoScan(typeName string, url string, apiKey string) (*Tomoscan, error) {
+// tomoscanResponse is the envelope of the api: status and message for the
+// modules, error for proxy calls failed by the node
+type tomoscanResponse struct {
+	Status  string          `json:"status"`
+	Message string          `json:"message"`
+	Result  json.RawMessage `json:"result"`
+	Error   *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// blockReward is the part of the block module answer the cache use
+type blockReward struct {
+	TimeStamp string `json:"timeStamp"`
+}
+
+// NewTomoScan create a tomoscan connection sending up to rateLimit requests
+// per second, DefaultTomoscanRateLimit when zero
+func NewTomoScan(typeName string, url string, apiKey string, rateLimit float64) (*Tomoscan, error) {
+	if rateLimit <= 0 {
+		rateLimit = DefaultTomoscanRateLimit
+	}
 	tomoscan := Tomoscan{
-		url, apiKey, typeName,
+		url:      strings.TrimSuffix(url, "/"),
+		apiKey:   apiKey,
+		TypeName: typeName,
+		pacer:    &pacer{interval: time.Duration(float64(time.Second) / rateLimit)},
 	}
 	return &tomoscan, nil
 }
 
+// get call the action of module with params and decode its result. Lists
+// without records decode to nothing.
+func (tomoscan *Tomoscan) get(ctx context.Context, module, action string, params url.Values, result interface{}) error {
+	if err := tomoscan.pacer.wait(ctx); err != nil {
+		return err
+	}
+	if params == nil {
+		params = url.Values{}
+	}
+	params.Set("module", module)
+	params.Set("action", action)
+	if tomoscan.apiKey != "" {
+		params.Set("apikey", tomoscan.apiKey)
+	}
+	b, err := fCommon.HTTPCall(ctx, tomoscan.TypeName, tomoscan.url+"/api?"+params.Encode())
+	if err != nil {
+		return err
+	}
+	response := tomoscanResponse{}
+	if err := json.Unmarshal(b, &response); err != nil {
+		return err
+	}
+	if response.Error != nil {
+		return fmt.Errorf("tomoscan %s: %s", action, response.Error.Message)
+	}
+	if response.Status == "0" {
+		if strings.HasPrefix(response.Message, "No records found") {
+			return nil
+		}
+		var detail string
+		json.Unmarshal(response.Result, &detail)
+		return fmt.Errorf("tomoscan %s: %s %s", action, response.Message, detail)
+	}
+	return json.Unmarshal(response.Result, result)
+}
+
 //TomoCall func
-func (tomoscan *Tomoscan) TomoCall(to string, data string) (string, error) {
-	url := tomoscan.url + "/api?module=proxy&action=eth_call&to=" +
-		to + "&data=" + data + "&tag=latest&apikey=" + tomoscan.apiKey
-	b, err := fCommon.HTTPCall(url)
+func (tomoscan *Tomoscan) TomoCall(ctx context.Context, to string, data string, block string) (string, error) {
+	tag, err := blockTag(block)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
-	result := tomochain.ResultRpc{}
-	err = json.Unmarshal(b, &result)
+	var result string
+	err = tomoscan.get(ctx, "proxy", "eth_call", url.Values{
+		"to":   {to},
+		"data": {"0x" + data},
+		"tag":  {tag},
+	}, &result)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
-
-	return result.Result, nil
-
+	return result, nil
 }
 
 //GetRate func
-func (tomoscan *Tomoscan) GetRate(to string, data string) (string, error) {
-	return "", errors.New("not support this func")
+func (tomoscan *Tomoscan) GetRate(ctx context.Context, to string, data string, block string) (string, error) {
+	return tomoscan.TomoCall(ctx, to, data, block)
 }
 
-//GetLatestBlock func
-func (tomoscan *Tomoscan) GetLatestBlock() (string, error) {
-	url := tomoscan.url + "/api?module=proxy&action=eth_blockNumber"
-	b, err := fCommon.HTTPCall(url)
+//GetEvents func
+func (tomoscan *Tomoscan) GetEvents(ctx context.Context, fromBlock, toBlock, network, topic string) (*[]tomochain.EventRaw, error) {
+	result := make([]tomochain.EventRaw, 0)
+	err := tomoscan.get(ctx, "logs", "getLogs", url.Values{
+		"fromBlock": {fromBlock},
+		"toBlock":   {toBlock},
+		"address":   {network},
+		"topic0":    {topic},
+	}, &result)
+	if err != nil {
+		return nil, err
+	}
+
+	// logs carry the time of their block, read it from the block module
+	// when missing
+	timestamps := make(map[string]string)
+	for i, event := range result {
+		if event.Timestamp != "" {
+			continue
+		}
+		timestamp, ok := timestamps[event.BlockNumber]
+		if !ok {
+			timestamp, err = tomoscan.blockTimestamp(ctx, event.BlockNumber)
+			if err != nil {
+				return nil, err
+			}
+			timestamps[event.BlockNumber] = timestamp
+		}
+		result[i].Timestamp = timestamp
+	}
+	return &result, nil
+}
+
+// blockTimestamp return the hex unix time of block, a hex number
+func (tomoscan *Tomoscan) blockTimestamp(ctx context.Context, block string) (string, error) {
+	number, err := hexutil.DecodeBig(block)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
-	blockNum := tomochain.ResultRpc{}
-	err = json.Unmarshal(b, &blockNum)
+	reward := blockReward{}
+	err = tomoscan.get(ctx, "block", "getblockreward", url.Values{"blockno": {number.String()}}, &reward)
+	if err != nil {
+		return "", err
+	}
+	timestamp, ok := new(big.Int).SetString(reward.TimeStamp, 10)
+	if !ok {
+		return "", fmt.Errorf("tomoscan getblockreward: invalid timestamp %q", reward.TimeStamp)
+	}
+	return hexutil.EncodeBig(timestamp), nil
+}
+
+//GetLatestBlock func
+func (tomoscan *Tomoscan) GetLatestBlock(ctx context.Context) (string, error) {
+	var blockNum string
+	err := tomoscan.get(ctx, "proxy", "eth_blockNumber", nil, &blockNum)
 	if err != nil {
 		return "", err
 	}
-	num, err := hexutil.DecodeBig(blockNum.Result)
+	num, err := hexutil.DecodeBig(blockNum)
 	if err != nil {
 		return "", err
 	}
@@ -83,3 +198,35 @@ func (tomoscan *Tomoscan) GetLatestBlock() (string, error) {
 func (tomoscan *Tomoscan) GetTypeName() string {
 	return tomoscan.TypeName
 }
+
+// pacer space the calls of a connection to respect its rate limit
+type pacer struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+}
+
+// wait until the next call is allowed, or ctx is done
+func (p *pacer) wait(ctx context.Context) error {
+	p.mu.Lock()
+	now := time.Now()
+	slot := p.next
+	if slot.Before(now) {
+		slot = now
+	}
+	p.next = slot.Add(p.interval)
+	p.mu.Unlock()
+
+	delay := slot.Sub(now)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
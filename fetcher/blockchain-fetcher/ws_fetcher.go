@@ -0,0 +1,59 @@
+package bfetcher
+
+import (
+	"context"
+	"time"
+
+	"github.com/marknguyen85/server-api/tomochain"
+	"github.com/tomochain/tomochain/common/hexutil"
+	"github.com/tomochain/tomochain/rpc"
+)
+
+const dialTimeout = 10 * time.Second
+
+// Subscription is a server push stream, Err deliver the error ending it
+type Subscription interface {
+	Err() <-chan error
+	Unsubscribe()
+}
+
+// Head is the part of a new block header the cache use
+type Head struct {
+	Number *hexutil.Big `json:"number"`
+}
+
+// WSFetcher is a node reached over websocket: it answer the same calls as
+// BlockchainFetcher and can push new blocks and logs. The client redial by
+// itself on the next call after the connection drop.
+type WSFetcher struct {
+	*BlockchainFetcher
+}
+
+// NewWSFetcher dial endpoint, a ws:// or wss:// url
+func NewWSFetcher(typeName string, endpoint string, apiKey string) (*WSFetcher, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+	client, err := rpc.DialWebsocket(ctx, endpoint, "")
+	if err != nil {
+		return nil, err
+	}
+	return &WSFetcher{newBlockchainFetcher(client, typeName, endpoint)}, nil
+}
+
+// SubscribeNewHeads send the header of every new block to heads
+func (wsFetcher *WSFetcher) SubscribeNewHeads(ctx context.Context, heads chan<- Head) (Subscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, wsFetcher.timeout)
+	defer cancel()
+	return wsFetcher.client.EthSubscribe(ctx, heads, "newHeads")
+}
+
+// SubscribeLogs send the logs emitted by address with topic to logs
+func (wsFetcher *WSFetcher) SubscribeLogs(ctx context.Context, address, topic string, logs chan<- tomochain.EventRaw) (Subscription, error) {
+	ctx, cancel := context.WithTimeout(ctx, wsFetcher.timeout)
+	defer cancel()
+	filter := map[string]interface{}{
+		"address": address,
+		"topics":  []string{topic},
+	}
+	return wsFetcher.client.EthSubscribe(ctx, logs, "logs", filter)
+}
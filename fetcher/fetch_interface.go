@@ -1,7 +1,10 @@
 package fetcher
 
 import (
+	"context"
+
 	bFetcher "github.com/marknguyen85/server-api/fetcher/blockchain-fetcher"
+	"github.com/marknguyen85/server-api/tomochain"
 )
 
 type RateUSD struct {
@@ -10,25 +13,37 @@ type RateUSD struct {
 }
 
 type FetcherInterface interface {
-	TomoCall(string, string) (string, error)
-	GetLatestBlock() (string, error)
+	// TomoCall and GetRate run a call on contract to at block, a decimal
+	// number or bFetcher.LatestBlock
+	TomoCall(ctx context.Context, to, data, block string) (string, error)
+	GetLatestBlock(context.Context) (string, error)
 	GetTypeName() string
 
-	GetRate(string, string) (string, error)
+	GetRate(ctx context.Context, to, data, block string) (string, error)
+	// GetEvents return the logs of network with topic between two blocks
+	GetEvents(ctx context.Context, fromBlock, toBlock, network, topic string) (*[]tomochain.EventRaw, error)
 }
 
 //var transactionPersistent = models.NewTransactionPersister()
 
-func NewFetcherIns(typeName string, endpoint string, apiKey string) (FetcherInterface, error) {
+// NewFetcherIns create the fetcher of a connection, rateLimit is the
+// requests per second of tomoscan
+func NewFetcherIns(typeName string, endpoint string, apiKey string, rateLimit float64) (FetcherInterface, error) {
 	var fetcher FetcherInterface
 	var err error
 	switch typeName {
 	case "tomoscan":
-		fetcher, err = bFetcher.NewTomoScan(typeName, endpoint, apiKey)
+		fetcher, err = bFetcher.NewTomoScan(typeName, endpoint, apiKey, rateLimit)
 		break
 	case "node":
 		fetcher, err = bFetcher.NewBlockchainFetcher(typeName, endpoint, apiKey)
 		break
+	case "ws":
+		fetcher, err = bFetcher.NewWSFetcher(typeName, endpoint, apiKey)
+		break
+	case "simulated":
+		fetcher, err = bFetcher.NewSimulated(typeName, endpoint)
+		break
 	}
 	return fetcher, err
 }
@@ -1,32 +1,19 @@
 package fCommon
 
 import (
-	"errors"
-	"io/ioutil"
-	"log"
-	"net/http"
+	"context"
 	"time"
-)
-
-func HTTPCall(url string) ([]byte, error) {
-	client := http.Client{
-		Timeout: 5 * time.Second,
-	}
-	response, err := client.Get(url)
-	if err != nil {
-		log.Print(err)
-		return nil, err
-	}
 
-	if response.StatusCode != 200 {
-		return []byte{}, errors.New("Status code is not 200")
-	}
+	"github.com/marknguyen85/server-api/httpclient"
+	"github.com/marknguyen85/server-api/metrics"
+)
 
-	defer (response.Body).Close()
-	b, err := ioutil.ReadAll(response.Body)
-	if err != nil {
-		log.Print(err)
-		return nil, err
-	}
-	return b, nil
+// HTTPCall get url with the shared client, typeName label the caller in
+// upstream metrics
+func HTTPCall(ctx context.Context, typeName string, url string) (result []byte, err error) {
+	start := time.Now()
+	defer func() {
+		metrics.ObserveUpstream(typeName, start, err)
+	}()
+	return httpclient.Default().Get(ctx, url)
 }
@@ -1,10 +1,9 @@
 package fetcher
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
-	"io/ioutil"
-	"log"
+	"fmt"
 	"math/big"
 	"sync"
 
@@ -12,7 +11,10 @@ import (
 	"time"
 
 	"github.com/marknguyen85/server-api/common"
+	"github.com/marknguyen85/server-api/config"
+	bFetcher "github.com/marknguyen85/server-api/fetcher/blockchain-fetcher"
 	"github.com/marknguyen85/server-api/tomochain"
+	log "github.com/sirupsen/logrus"
 	// nFetcher "github.com/marknguyen85/server-api/fetcher/normal-fetcher"
 )
 
@@ -24,12 +26,6 @@ const (
 	timeW8Req = 500
 )
 
-type Connection struct {
-	Endpoint string `json:"endPoint"`
-	Type     string `json:"type"`
-	Apikey   string `json:"api_key"`
-}
-
 type InfoData struct {
 	mu         *sync.RWMutex
 	ApiUsd     string               `json:"api_usd"`
@@ -39,7 +35,9 @@ type InfoData struct {
 	Tokens        map[string]tomochain.Token
 	BackupTokens  map[string]tomochain.Token
 	TokenPriority map[string]tomochain.Token
-	Connections   []Connection `json:"connections"`
+	// unix time of the last successful refresh from config endpoint
+	tokensUpdatedAt int64
+	Connections     []config.Connection `json:"connections"`
 
 	Network    string `json:"network"`
 	NetworkAbi string
@@ -54,6 +52,8 @@ type InfoData struct {
 	APIEndpoint        string `json:"api_endpoint"`
 	ConfigEndpoint     string `json:"config_endpoint"`
 	UserStatsEndpoint  string `json:"user_stats_endpoint"`
+
+	TokenSources config.TokenSources `json:"token_sources"`
 }
 
 //GetListToken return list tokens supported
@@ -88,6 +88,21 @@ func (infoData *InfoData) UpdateListToken(tokens, tokenPriority map[string]tomoc
 	infoData.Tokens = tokens
 	infoData.BackupTokens = tokens
 	infoData.TokenPriority = tokenPriority
+	infoData.tokensUpdatedAt = time.Now().UTC().Unix()
+}
+
+//GetTimeUpdateListToken func
+func (infoData *InfoData) GetTimeUpdateListToken() int64 {
+	infoData.mu.RLock()
+	defer infoData.mu.RUnlock()
+	return infoData.tokensUpdatedAt
+}
+
+//GetTokenSources func
+func (infoData *InfoData) GetTokenSources() config.TokenSources {
+	infoData.mu.RLock()
+	defer infoData.mu.RUnlock()
+	return infoData.TokenSources
 }
 
 //GetTokenAPI func
@@ -106,11 +121,18 @@ func (infoData *InfoData) GetListTokenPriority() map[string]tomochain.Token {
 
 //Fetcher struct
 type Fetcher struct {
+	// mu guard nodes, replaced on config reload
+	mu sync.RWMutex
+	// tradesSubscribed is 1 while trades are pushed by a ws connection
+	tradesSubscribed int32
+
 	info         *InfoData
 	tomochain    *TomoChain
-	fetIns       []FetcherInterface
+	nodes        []*node
 	marketFetIns MarketFetcherInterface
 	httpFetcher  *HTTPFetcher
+	// onTokenList is told every token list refreshed, guarded by mu
+	onTokenList func(map[string]tomochain.Token)
 }
 
 //GetNumTokens func
@@ -120,33 +142,7 @@ func (fetcher *Fetcher) GetNumTokens() int {
 }
 
 //NewFetcher func
-func NewFetcher(chainTexENV string) (*Fetcher, error) {
-	var file []byte
-	var err error
-	switch chainTexENV {
-	case "staging":
-		file, err = ioutil.ReadFile("env/staging.json")
-		if err != nil {
-			log.Print(err)
-			return nil, err
-		}
-		break
-	case "production":
-		file, err = ioutil.ReadFile("env/production.json")
-		if err != nil {
-			log.Print(err)
-			return nil, err
-		}
-		break
-	default:
-		file, err = ioutil.ReadFile("env/testnet.json")
-		if err != nil {
-			log.Print(err)
-			return nil, err
-		}
-		break
-	}
-
+func NewFetcher(chain config.Chain) (*Fetcher, error) {
 	mu := &sync.RWMutex{}
 
 	infoData := InfoData{
@@ -155,29 +151,27 @@ func NewFetcher(chainTexENV string) (*Fetcher, error) {
 		TomoSymbol:  "TOMO",
 		NetworkAbi:  `[{"constant":false,"inputs":[{"name":"alerter","type":"address"}],"name":"removeAlerter","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":true,"inputs":[{"name":"token","type":"address"},{"name":"srcQty","type":"uint256"}],"name":"getExpectedFeeRate","outputs":[{"name":"expectedRate","type":"uint256"},{"name":"slippageRate","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"token","type":"address"},{"name":"srcAmount","type":"uint256"},{"name":"minConversionRate","type":"uint256"}],"name":"swapTokenToTomo","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":true,"inputs":[],"name":"enabled","outputs":[{"name":"","type":"bool"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"pendingAdmin","outputs":[{"name":"","type":"address"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"getOperators","outputs":[{"name":"","type":"address[]"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"src","type":"address"},{"name":"srcAmount","type":"uint256"},{"name":"destAddress","type":"address"}],"name":"payTxFeeFast","outputs":[{"name":"","type":"uint256"}],"payable":true,"stateMutability":"payable","type":"function"},{"constant":false,"inputs":[{"name":"token","type":"address"},{"name":"amount","type":"uint256"},{"name":"sendTo","type":"address"}],"name":"withdrawToken","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":true,"inputs":[],"name":"maxGasPrice","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"newAlerter","type":"address"}],"name":"addAlerter","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":false,"inputs":[{"name":"_networkContract","type":"address"}],"name":"setNetworkContract","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":true,"inputs":[{"name":"","type":"address"}],"name":"payFeeCallers","outputs":[{"name":"","type":"bool"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[{"name":"user","type":"address"}],"name":"getUserCapInWei","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"src","type":"address"},{"name":"srcAmount","type":"uint256"},{"name":"dest","type":"address"},{"name":"minConversionRate","type":"uint256"}],"name":"swapTokenToToken","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":false,"inputs":[{"name":"newAdmin","type":"address"}],"name":"transferAdmin","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":false,"inputs":[],"name":"claimAdmin","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":false,"inputs":[{"name":"newAdmin","type":"address"}],"name":"transferAdminQuickly","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":true,"inputs":[],"name":"getAlerters","outputs":[{"name":"","type":"address[]"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[{"name":"src","type":"address"},{"name":"dest","type":"address"},{"name":"srcQty","type":"uint256"}],"name":"getExpectedRate","outputs":[{"name":"expectedRate","type":"uint256"},{"name":"slippageRate","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[{"name":"user","type":"address"},{"name":"token","type":"address"}],"name":"getUserCapInTokenWei","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"newOperator","type":"address"}],"name":"addOperator","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":false,"inputs":[{"name":"src","type":"address"},{"name":"srcAmount","type":"uint256"},{"name":"dest","type":"address"},{"name":"destAddress","type":"address"},{"name":"maxDestAmount","type":"uint256"},{"name":"minConversionRate","type":"uint256"},{"name":"walletId","type":"address"}],"name":"swap","outputs":[{"name":"","type":"uint256"}],"payable":true,"stateMutability":"payable","type":"function"},{"constant":false,"inputs":[{"name":"operator","type":"address"}],"name":"removeOperator","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":true,"inputs":[{"name":"field","type":"bytes32"}],"name":"info","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"caller","type":"address"},{"name":"add","type":"bool"}],"name":"addPayFeeCaller","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":false,"inputs":[{"name":"token","type":"address"},{"name":"minConversionRate","type":"uint256"}],"name":"swapTomoToToken","outputs":[{"name":"","type":"uint256"}],"payable":true,"stateMutability":"payable","type":"function"},{"constant":false,"inputs":[{"name":"src","type":"address"},{"name":"srcAmount","type":"uint256"},{"name":"dest","type":"address"},{"name":"destAddress","type":"address"},{"name":"maxDestAmount","type":"uint256"},{"name":"minConversionRate","type":"uint256"},{"name":"walletId","type":"address"}],"name":"trade","outputs":[{"name":"","type":"uint256"}],"payable":true,"stateMutability":"payable","type":"function"},{"constant":false,"inputs":[{"name":"amount","type":"uint256"},{"name":"sendTo","type":"address"}],"name":"withdrawEther","outputs":[],"payable":false,"stateMutability":"nonpayable","type":"function"},{"constant":true,"inputs":[{"name":"token","type":"address"},{"name":"user","type":"address"}],"name":"getBalance","outputs":[{"name":"","type":"uint256"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"networkContract","outputs":[{"name":"","type":"address"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"admin","outputs":[{"name":"","type":"address"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":false,"inputs":[{"name":"src","type":"address"},{"name":"srcAmount","type":"uint256"},{"name":"destAddress","type":"address"},{"name":"maxDestAmount","type":"uint256"},{"name":"minConversionRate","type":"uint256"}],"name":"payTxFee","outputs":[{"name":"","type":"uint256"}],"payable":true,"stateMutability":"payable","type":"function"},{"inputs":[{"name":"_admin","type":"address"}],"payable":false,"stateMutability":"nonpayable","type":"constructor"},{"anonymous":false,"inputs":[{"indexed":false,"name":"caller","type":"address"},{"indexed":false,"name":"add","type":"bool"}],"name":"AddPayFeeCaller","type":"event"},{"anonymous":false,"inputs":[{"indexed":true,"name":"trader","type":"address"},{"indexed":false,"name":"src","type":"address"},{"indexed":false,"name":"dest","type":"address"},{"indexed":false,"name":"actualSrcAmount","type":"uint256"},{"indexed":false,"name":"actualDestAmount","type":"uint256"}],"name":"ExecuteTrade","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"name":"newNetworkContract","type":"address"},{"indexed":false,"name":"oldNetworkContract","type":"address"}],"name":"NetworkSet","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"name":"token","type":"address"},{"indexed":false,"name":"amount","type":"uint256"},{"indexed":false,"name":"sendTo","type":"address"}],"name":"TokenWithdraw","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"name":"amount","type":"uint256"},{"indexed":false,"name":"sendTo","type":"address"}],"name":"EtherWithdraw","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"name":"pendingAdmin","type":"address"}],"name":"TransferAdminPending","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"name":"newAdmin","type":"address"},{"indexed":false,"name":"previousAdmin","type":"address"}],"name":"AdminClaimed","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"name":"newAlerter","type":"address"},{"indexed":false,"name":"isAdd","type":"bool"}],"name":"AlerterAdded","type":"event"},{"anonymous":false,"inputs":[{"indexed":false,"name":"newOperator","type":"address"},{"indexed":false,"name":"isAdd","type":"bool"}],"name":"OperatorAdded","type":"event"}]`,
 	}
-	err = json.Unmarshal(file, &infoData)
-	if err != nil {
-		log.Print(err)
-		return nil, err
+	infoData.ApiUsd = chain.ApiUsd
+	infoData.CoinMarket = chain.CoinMarket
+	infoData.TokenAPI = chain.Tokens
+	infoData.Connections = chain.Connections
+	infoData.Network = chain.Network
+	infoData.TradeTopic = chain.TradeTopic
+	infoData.AverageBlockTime = chain.AverageBlockTime
+	infoData.GasStationEndpoint = chain.GasStationEndpoint
+	infoData.APIEndpoint = chain.APIEndpoint
+	infoData.ConfigEndpoint = chain.ConfigEndpoint
+	infoData.UserStatsEndpoint = chain.UserStatsEndpoint
+	infoData.TokenSources = chain.TokenSources
+	if chain.NetworkAbi != "" {
+		infoData.NetworkAbi = chain.NetworkAbi
 	}
 
-	listToken := make(map[string]tomochain.Token)
-	listBackup := make(map[string]tomochain.Token)
-	infoData.Tokens = listToken
-	for _, t := range infoData.TokenAPI {
-		listBackup[t.Symbol] = tomochain.TokenAPIToToken(t)
-	}
-	infoData.BackupTokens = listBackup
+	infoData.Tokens = make(map[string]tomochain.Token)
+	infoData.BackupTokens = backupTokens(infoData.TokenAPI)
 
-	fetIns := make([]FetcherInterface, 0)
-	for _, connection := range infoData.Connections {
-		newFetcher, err := NewFetcherIns(connection.Type, connection.Endpoint, connection.Apikey)
-		if err != nil {
-			log.Print(err)
-		} else {
-			fetIns = append(fetIns, newFetcher)
-		}
-	}
+	// connections that cannot be created are logged and skipped
+	nodes, _ := newNodes(infoData.Connections, nil)
 
 	marketFetcherIns := NewMarketFetcherInterface()
 
@@ -186,14 +180,13 @@ func NewFetcher(chainTexENV string) (*Fetcher, error) {
 	tomochain, err := NewTomoChain(infoData.Network, infoData.NetworkAbi, infoData.TradeTopic,
 		infoData.AverageBlockTime)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 
 	fetcher := &Fetcher{
 		info:         &infoData,
 		tomochain:    tomochain,
-		fetIns:       fetIns,
+		nodes:        nodes,
 		marketFetIns: marketFetcherIns,
 		httpFetcher:  httpFetcher,
 	}
@@ -202,30 +195,34 @@ func NewFetcher(chainTexENV string) (*Fetcher, error) {
 }
 
 //TryUpdateListToken func
-func (fetcher *Fetcher) TryUpdateListToken() error {
+func (fetcher *Fetcher) TryUpdateListToken(ctx context.Context) error {
 	var err error
 	for i := 0; i < 3; i++ {
-		err = fetcher.UpdateListToken()
+		err = fetcher.UpdateListToken(ctx)
 		if err != nil {
-			log.Println(err)
-			time.Sleep(5 * time.Second)
+			log.WithField("attempt", i+1).WithError(err).Warn("cannot update list token")
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
 			continue
 		}
 		return nil
 	}
+	log.WithError(err).Error("use backup list token")
 	fetcher.info.UpdateByBackupToken()
 	return nil
 }
 
 //UpdateListToken func
-func (fetcher *Fetcher) UpdateListToken() error {
+func (fetcher *Fetcher) UpdateListToken(ctx context.Context) error {
 	var (
 		err    error
 		result []tomochain.Token
 	)
-	result, err = fetcher.httpFetcher.GetListToken()
+	result, err = fetcher.GetSourcedTokens(ctx)
 	if err != nil {
-		log.Println(err)
 		return err
 	}
 	listToken := make(map[string]tomochain.Token)
@@ -245,9 +242,25 @@ func (fetcher *Fetcher) UpdateListToken() error {
 		}
 	}
 	fetcher.info.UpdateListToken(listToken, listPriority)
+
+	fetcher.mu.RLock()
+	onTokenList := fetcher.onTokenList
+	fetcher.mu.RUnlock()
+	if onTokenList != nil {
+		onTokenList(listToken)
+	}
 	return nil
 }
 
+// OnTokenList make every successful refresh of the token list call fn with
+// the new list. The backup tokens served when the refresh fail are not
+// reported.
+func (fetcher *Fetcher) OnTokenList(fn func(map[string]tomochain.Token)) {
+	fetcher.mu.Lock()
+	defer fetcher.mu.Unlock()
+	fetcher.onTokenList = fn
+}
+
 // GetListTokenAPI api to get config token
 func (fetcher *Fetcher) GetListTokenAPI() []tomochain.TokenAPI {
 	return fetcher.info.GetTokenAPI()
@@ -258,95 +271,127 @@ func (fetcher *Fetcher) GetListToken() map[string]tomochain.Token {
 	return fetcher.info.GetListToken()
 }
 
+// GetTimeUpdateListToken return unix time of the last successful token list refresh
+func (fetcher *Fetcher) GetTimeUpdateListToken() int64 {
+	return fetcher.info.GetTimeUpdateListToken()
+}
+
 // GetListTokenPriority return map token with key is token ID
 func (fetcher *Fetcher) GetListTokenPriority() map[string]tomochain.Token {
 	return fetcher.info.GetListTokenPriority()
 }
 
 //GetGeneralInfoTokens func
-func (fetcher *Fetcher) GetGeneralInfoTokens() map[string]*tomochain.TokenGeneralInfo {
+func (fetcher *Fetcher) GetGeneralInfoTokens(ctx context.Context) (map[string]*tomochain.TokenGeneralInfo, error) {
 	generalInfo := map[string]*tomochain.TokenGeneralInfo{}
 	listTokens := fetcher.GetListToken()
 	for _, token := range listTokens {
 		if token.CGId != "" {
-			result, err := fetcher.marketFetIns.GetGeneralInfo(token.CGId)
-			time.Sleep(5 * time.Second)
+			result, err := fetcher.marketFetIns.GetGeneralInfo(ctx, token.CGId)
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-time.After(5 * time.Second):
+			}
 			if err != nil {
-				log.Print(err)
+				log.WithFields(log.Fields{
+					"token": token.TokenID,
+					"cg_id": token.CGId,
+				}).WithError(err).Warn("cannot get general info")
 				continue
 			}
 			generalInfo[token.TokenID] = result
 		}
 	}
 
-	return generalInfo
+	return generalInfo, nil
 }
 
 //GetRateUsdTomo func
-func (fetcher *Fetcher) GetRateUsdTomo() (string, error) {
-	rateUsd, err := fetcher.marketFetIns.GetRateUsdTomo()
-	//rateUsd, err := fetcher.httpFetcher.GetRateUsdTomo()
+func (fetcher *Fetcher) GetRateUsdTomo(ctx context.Context) (string, error) {
+	rateUsd, err := fetcher.marketFetIns.GetRateUsdTomo(ctx)
+	//rateUsd, err := fetcher.httpFetcher.GetRateUsdTomo(ctx)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 	return rateUsd, nil
 }
 
 //GetGasPrice func
-func (fetcher *Fetcher) GetGasPrice() (*tomochain.GasPrice, error) {
-	result, err := fetcher.httpFetcher.GetGasPrice()
+func (fetcher *Fetcher) GetGasPrice(ctx context.Context) (*tomochain.GasPrice, error) {
+	result, err := fetcher.httpFetcher.GetGasPrice(ctx)
 	if err != nil {
-		log.Print(err)
-		return nil, errors.New("Cannot get gas price")
+		return nil, fmt.Errorf("Cannot get gas price: %v", err)
 	}
 	return result, nil
 }
 
 //GetGeneralInfoTokens func
-func (fetcher *Fetcher) GetMaxGasPrice() (string, error) {
+func (fetcher *Fetcher) GetMaxGasPrice(ctx context.Context) (string, error) {
 	dataAbi, err := fetcher.tomochain.EncodeMaxGasPrice()
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
-	for _, fetIns := range fetcher.fetIns {
-		result, err := fetIns.TomoCall(fetcher.info.Network, dataAbi)
+	gasPrice, err := fetcher.call(ctx, nil, func(ctx context.Context, fetIns FetcherInterface) (interface{}, error) {
+		result, err := fetIns.TomoCall(ctx, fetcher.info.Network, dataAbi, bFetcher.LatestBlock)
 		if err != nil {
-			log.Print(err)
-			continue
-		}
-		gasPrice, err := fetcher.tomochain.ExtractMaxGasPrice(result)
-		if err != nil {
-			log.Print(err)
-			continue
+			return nil, err
 		}
-		return gasPrice, nil
+		return fetcher.tomochain.ExtractMaxGasPrice(result)
+	})
+	if err != nil {
+		return "", fmt.Errorf("Cannot get gas price: %v", err)
 	}
-	return "", errors.New("Cannot get gas price")
+	return gasPrice.(string), nil
 }
 
 //GetGeneralInfoTokens func
-func (fetcher *Fetcher) CheckChainTeXEnable() (bool, error) {
+func (fetcher *Fetcher) CheckChainTeXEnable(ctx context.Context) (bool, error) {
 	dataAbi, err := fetcher.tomochain.EncodeChainTeXEnable()
 	if err != nil {
-		log.Print(err)
 		return false, err
 	}
-	for _, fetIns := range fetcher.fetIns {
-		result, err := fetIns.TomoCall(fetcher.info.Network, dataAbi)
-		if err != nil {
-			log.Print(err)
-			continue
-		}
-		enabled, err := fetcher.tomochain.ExtractEnabled(result)
+	enabled, err := fetcher.call(ctx, nil, func(ctx context.Context, fetIns FetcherInterface) (interface{}, error) {
+		result, err := fetIns.TomoCall(ctx, fetcher.info.Network, dataAbi, bFetcher.LatestBlock)
 		if err != nil {
-			log.Print(err)
-			continue
+			return nil, err
 		}
-		return enabled, nil
+		return fetcher.tomochain.ExtractEnabled(result)
+	})
+	if err != nil {
+		return false, fmt.Errorf("Cannot check kyber enable: %v", err)
+	}
+	return enabled.(bool), nil
+}
+
+//GetLatestBlock func
+func (fetcher *Fetcher) GetLatestBlock(ctx context.Context) (string, error) {
+	blockNumber, err := fetcher.call(ctx, nil, func(ctx context.Context, fetIns FetcherInterface) (interface{}, error) {
+		block, err := fetIns.GetLatestBlock(ctx)
+		return latestBlock(block), err
+	})
+	if err != nil {
+		return "", fmt.Errorf("Cannot get latest block: %v", err)
 	}
-	return false, errors.New("Cannot check kyber enable")
+	return string(blockNumber.(latestBlock)), nil
+}
+
+//GetTradeEvents return the latest trades of the network between two blocks
+func (fetcher *Fetcher) GetTradeEvents(ctx context.Context, fromBlock, toBlock string) ([]tomochain.EventHistory, error) {
+	if fetcher.info.TradeTopic == "" {
+		return nil, nil
+	}
+	eventRaw, err := fetcher.call(ctx, nil, func(ctx context.Context, fetIns FetcherInterface) (interface{}, error) {
+		return fetIns.GetEvents(ctx, fromBlock, toBlock, fetcher.info.Network, fetcher.info.TradeTopic)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Cannot get trade events: %v", err)
+	}
+	events, err := fetcher.tomochain.ReadEventsWithBlockNumber(eventRaw.(*[]tomochain.EventRaw), toBlock)
+	if err != nil {
+		return nil, err
+	}
+	return *events, nil
 }
 
 func getAmountInWei(amount float64) *big.Int {
@@ -381,93 +426,92 @@ func getMapRates(rates []tomochain.Rate) map[string]tomochain.Rate {
 }
 
 //FetchRate7dData func
-func (fetcher *Fetcher) FetchRate7dData() (map[string]*tomochain.Rates, error) {
-	result, err := fetcher.httpFetcher.GetRate7dData()
+func (fetcher *Fetcher) FetchRate7dData(ctx context.Context) (map[string]*tomochain.Rates, error) {
+	result, err := fetcher.httpFetcher.GetRate7dData(ctx)
 	if err != nil {
-		log.Print(err)
-		// continue
-		return nil, errors.New("Cannot get data from tracker")
+		return nil, fmt.Errorf("Cannot get data from tracker: %v", err)
 	}
 	return result, nil
 }
 
-// GetRate get full rate of list token
-func (fetcher *Fetcher) GetRate(currentRate []tomochain.Rate, isNewRate bool, mapToken map[string]tomochain.Token, fallback bool) ([]tomochain.Rate, error) {
+// GetRate get full rate of list token at block, a decimal number or
+// bFetcher.LatestBlock
+func (fetcher *Fetcher) GetRate(ctx context.Context, block string, currentRate []tomochain.Rate, isNewRate bool, mapToken map[string]tomochain.Token, fallback bool) ([]tomochain.Rate, error) {
 	var (
 		rates []tomochain.Rate
 		err   error
 	)
 	if !isNewRate {
-		initRate := fetcher.getInitRate(mapToken)
+		initRate := fetcher.getInitRate(ctx, block, mapToken)
 		currentRate = initRate
 	}
 	sourceArr, sourceSymbolArr, destArr, destSymbolArr, amountArr := fetcher.makeDataGetRate(mapToken, currentRate)
-	rates, err = fetcher.runFetchRate(sourceArr, destArr, sourceSymbolArr, destSymbolArr, amountArr)
+	rates, err = fetcher.runFetchRate(ctx, block, sourceArr, destArr, sourceSymbolArr, destSymbolArr, amountArr)
 
 	if err != nil && fallback {
-		log.Println("cannot get rate from network proxy, change to get from network")
+		log.Warn("cannot get rate from network proxy, change to get from network")
 	}
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
 	return rates, nil
 }
 
 //runFetchRate func
-func (fetcher *Fetcher) runFetchRate(sourceArr, destArr, sourceSymbolArr, destSymbolArr []string, amountArr []*big.Int) ([]tomochain.Rate, error) {
+func (fetcher *Fetcher) runFetchRate(ctx context.Context, block string, sourceArr, destArr, sourceSymbolArr, destSymbolArr []string, amountArr []*big.Int) ([]tomochain.Rate, error) {
 	var (
 		tokenNum = len(sourceArr)
 		rates    []tomochain.Rate
 	)
 
 	for i := 0; i < tokenNum; i++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		var (
 			dataAbi string
 			err     error
 			rate    tomochain.Rate
 		)
 		dataAbi, err = fetcher.tomochain.EncodeRateData(sourceArr[i], destArr[i], amountArr[i])
+		rateLog := log.WithFields(log.Fields{
+			"source": sourceSymbolArr[i],
+			"dest":   destSymbolArr[i],
+		})
 
 		if err != nil {
-			log.Print(err)
+			rateLog.WithError(err).Warn("cannot encode rate data")
 		} else {
-			rate, err = fetcher.GetRateFromAbi(dataAbi, sourceSymbolArr[i], destSymbolArr[i])
+			rate, err = fetcher.GetRateFromAbi(ctx, block, dataAbi, sourceSymbolArr[i], destSymbolArr[i])
 			if err != nil {
-				log.Print(err)
+				rateLog.WithError(err).Warn("cannot get rate")
+				continue
 			}
 
 			rates = append(rates, rate)
 		}
 	}
 
+	// saving nothing would wipe the rates kept from the last run
+	if len(rates) == 0 && tokenNum > 0 {
+		return nil, errors.New("cannot get any rate")
+	}
 	return rates, nil
 }
 
-//GetRateFromAbi func get rate from abi string
-func (fetcher *Fetcher) GetRateFromAbi(dataAbi string, fromSymbol string, toSymbol string) (tomochain.Rate, error) {
-	var rate tomochain.Rate
-
-	for _, fetIns := range fetcher.fetIns {
-		if fetIns.GetTypeName() == "tomoscan" {
-			continue
-		}
-
-		result, err := fetIns.GetRate(fetcher.info.Network, dataAbi)
+//GetRateFromAbi func get rate from abi string at block
+func (fetcher *Fetcher) GetRateFromAbi(ctx context.Context, block string, dataAbi string, fromSymbol string, toSymbol string) (tomochain.Rate, error) {
+	rate, err := fetcher.call(ctx, nil, func(ctx context.Context, fetIns FetcherInterface) (interface{}, error) {
+		result, err := fetIns.GetRate(ctx, fetcher.info.Network, dataAbi, block)
 		if err != nil {
-			log.Print(err)
-			continue
-		}
-
-		rate, err = fetcher.tomochain.ExtractRateData(result, fromSymbol, toSymbol)
-		if err != nil {
-			log.Print(err)
-			continue
+			return nil, err
 		}
-		return rate, nil
+		return fetcher.tomochain.ExtractRateData(result, fromSymbol, toSymbol)
+	})
+	if err != nil {
+		return tomochain.Rate{}, err
 	}
-
-	return rate, nil
+	return rate.(tomochain.Rate), nil
 }
 
 //makeDataGetRate func
@@ -515,7 +559,7 @@ func (fetcher *Fetcher) makeDataGetRate(listTokens map[string]tomochain.Token, r
 }
 
 //getInitRate func
-func (fetcher *Fetcher) getInitRate(listTokens map[string]tomochain.Token) []tomochain.Rate {
+func (fetcher *Fetcher) getInitRate(ctx context.Context, block string, listTokens map[string]tomochain.Token) []tomochain.Rate {
 	tomoSymbol := common.TOMOSymbol
 	tomoAddr := common.TOMOAddr
 	minAmountTOMO := getAmountInWei(MIN_TOMO)
@@ -536,34 +580,15 @@ func (fetcher *Fetcher) getInitRate(listTokens map[string]tomochain.Token) []tom
 		amountArr = append(amountArr, minAmountTOMO)
 	}
 
-	initRate, _ := fetcher.runFetchRate(srcArr, destArr, srcSymbolArr, destSymbolArr, amountArr)
+	initRate, _ := fetcher.runFetchRate(ctx, block, srcArr, destArr, srcSymbolArr, destSymbolArr, amountArr)
 	return initRate
 }
 
 //queryRateBlockchain func
-func (fetcher *Fetcher) queryRateBlockchain(fromAddr, toAddr, fromSymbol, toSymbol string, amount *big.Int) (tomochain.Rate, error) {
-	var rate tomochain.Rate
+func (fetcher *Fetcher) queryRateBlockchain(ctx context.Context, block, fromAddr, toAddr, fromSymbol, toSymbol string, amount *big.Int) (tomochain.Rate, error) {
 	dataAbi, err := fetcher.tomochain.EncodeRateData(fromAddr, toAddr, amount)
 	if err != nil {
-		log.Print(err)
-		return rate, err
-	}
-
-	for _, fetIns := range fetcher.fetIns {
-		if fetIns.GetTypeName() == "tomoscan" {
-			continue
-		}
-		result, err := fetIns.GetRate(fetcher.info.Network, dataAbi)
-		if err != nil {
-			log.Print(err)
-			continue
-		}
-		rate, err := fetcher.tomochain.ExtractRateData(result, fromSymbol, toSymbol)
-		if err != nil {
-			log.Print(err)
-			continue
-		}
-		return rate, nil
+		return tomochain.Rate{}, err
 	}
-	return rate, errors.New("cannot get rate")
+	return fetcher.GetRateFromAbi(ctx, block, dataAbi, fromSymbol, toSymbol)
 }
@@ -1,10 +1,10 @@
 package fetcher
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"log"
 	"math/big"
 
 	"github.com/marknguyen85/server-api/common"
@@ -21,6 +21,7 @@ type HTTPFetcher struct {
 	tradingAPIEndpoint string
 	gasStationEndPoint string
 	apiEndpoint        string
+	typeName           string
 }
 
 func NewHTTPFetcher(tradingAPIEndpoint, gasStationEndpoint, apiEndpoint string) *HTTPFetcher {
@@ -28,19 +29,18 @@ func NewHTTPFetcher(tradingAPIEndpoint, gasStationEndpoint, apiEndpoint string)
 		tradingAPIEndpoint: tradingAPIEndpoint,
 		gasStationEndPoint: gasStationEndpoint,
 		apiEndpoint:        apiEndpoint,
+		typeName:           "http",
 	}
 }
 
-func (httpFetcher *HTTPFetcher) GetListToken() ([]tomochain.Token, error) {
-	b, err := fCommon.HTTPCall(httpFetcher.tradingAPIEndpoint)
+func (httpFetcher *HTTPFetcher) GetListToken(ctx context.Context) ([]tomochain.Token, error) {
+	b, err := fCommon.HTTPCall(ctx, httpFetcher.typeName, httpFetcher.tradingAPIEndpoint)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 	var result tomochain.TokenConfig
 	err = json.Unmarshal(b, &result)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 	if result.Success == false {
@@ -62,16 +62,14 @@ type GasStation struct {
 	Low      float64 `json:"safeLow"`
 }
 
-func (httpFetcher *HTTPFetcher) GetGasPrice() (*tomochain.GasPrice, error) {
-	b, err := fCommon.HTTPCall(httpFetcher.gasStationEndPoint)
+func (httpFetcher *HTTPFetcher) GetGasPrice(ctx context.Context) (*tomochain.GasPrice, error) {
+	b, err := fCommon.HTTPCall(ctx, httpFetcher.typeName, httpFetcher.gasStationEndPoint)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 	var gasPrice GasStation
 	err = json.Unmarshal(b, &gasPrice)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 
@@ -87,32 +85,28 @@ func (httpFetcher *HTTPFetcher) GetGasPrice() (*tomochain.GasPrice, error) {
 
 // get data from tracker.kyber
 
-func (httpFetcher *HTTPFetcher) GetRate7dData() (map[string]*tomochain.Rates, error) {
+func (httpFetcher *HTTPFetcher) GetRate7dData(ctx context.Context) (map[string]*tomochain.Rates, error) {
 	trackerAPI := httpFetcher.apiEndpoint + "/rates7d"
-	b, err := fCommon.HTTPCall(trackerAPI)
+	b, err := fCommon.HTTPCall(ctx, httpFetcher.typeName, trackerAPI)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 	trackerData := map[string]*tomochain.Rates{}
 	err = json.Unmarshal(b, &trackerData)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 	return trackerData, nil
 }
 
-func (httpFetcher *HTTPFetcher) GetUserInfo(url string) (*common.UserInfo, error) {
+func (httpFetcher *HTTPFetcher) GetUserInfo(ctx context.Context, url string) (*common.UserInfo, error) {
 	userInfo := &common.UserInfo{}
-	b, err := fCommon.HTTPCall(url)
+	b, err := fCommon.HTTPCall(ctx, httpFetcher.typeName, url)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 	err = json.Unmarshal(b, userInfo)
 	if err != nil {
-		log.Println(err)
 		return nil, err
 	}
 	return userInfo, nil
@@ -129,18 +123,16 @@ type TokenPrice struct {
 }
 
 // GetRateUsdTomo get usd from api
-func (httpFetcher *HTTPFetcher) GetRateUsdTomo() (string, error) {
+func (httpFetcher *HTTPFetcher) GetRateUsdTomo(ctx context.Context) (string, error) {
 	var ethPrice string
 	url := fmt.Sprintf("%s/token_price?currency=USD", httpFetcher.apiEndpoint)
-	b, err := fCommon.HTTPCall(url)
+	b, err := fCommon.HTTPCall(ctx, httpFetcher.typeName, url)
 	if err != nil {
-		log.Print(err)
 		return ethPrice, err
 	}
 	var tokenPrice TokenPrice
 	err = json.Unmarshal(b, &tokenPrice)
 	if err != nil {
-		log.Println(err)
 		return ethPrice, err
 	}
 	if tokenPrice.Error {
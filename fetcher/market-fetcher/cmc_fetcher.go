@@ -1,9 +1,9 @@
 package mFetcher
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
-	"log"
 
 	fCommon "github.com/marknguyen85/server-api/fetcher/fetcher-common"
 	"github.com/marknguyen85/server-api/tomochain"
@@ -23,34 +23,30 @@ func NewCMCFetcher() *CMCFetcher {
 	}
 }
 
-func (cMCFetcher *CMCFetcher) GetRateUsdTomo() (string, error) {
+func (cMCFetcher *CMCFetcher) GetRateUsdTomo(ctx context.Context) (string, error) {
 	// typeMarket := cMCFetcher.typeMarket
 	url := cMCFetcher.APIV1 + "/ticker/tomochain"
-	b, err := fCommon.HTTPCall(url)
+	b, err := fCommon.HTTPCall(ctx, cMCFetcher.typeMarket, url)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 	rateItem := make([]tomochain.RateUSD, 0)
 	err = json.Unmarshal(b, &rateItem)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 	return rateItem[0].PriceUsd, nil
 }
 
-func (cMCFetcher *CMCFetcher) GetGeneralInfo(usdId string) (*tomochain.TokenGeneralInfo, error) {
+func (cMCFetcher *CMCFetcher) GetGeneralInfo(ctx context.Context, usdId string) (*tomochain.TokenGeneralInfo, error) {
 	url := cMCFetcher.APIV2 + "/ticker/" + usdId + "/?convert=TOMO"
-	b, err := fCommon.HTTPCall(url)
+	b, err := fCommon.HTTPCall(ctx, cMCFetcher.typeMarket, url)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 	tokenItem := map[string]tomochain.TokenGeneralInfo{}
 	err = json.Unmarshal(b, &tokenItem)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 
@@ -58,7 +54,5 @@ func (cMCFetcher *CMCFetcher) GetGeneralInfo(usdId string) (*tomochain.TokenGene
 		data.MarketCap = data.Quotes["TOMO"].MarketCap
 		return &data, nil
 	}
-	err = errors.New("Cannot find data key in return quotes of ticker")
-	log.Print(err)
-	return nil, err
+	return nil, errors.New("Cannot find data key in return quotes of ticker")
 }
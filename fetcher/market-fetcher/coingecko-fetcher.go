@@ -1,12 +1,13 @@
 package mFetcher
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 
 	fCommon "github.com/marknguyen85/server-api/fetcher/fetcher-common"
 	"github.com/marknguyen85/server-api/tomochain"
+	log "github.com/sirupsen/logrus"
 )
 
 type CGFetcher struct {
@@ -21,41 +22,40 @@ func NewCGFetcher() *CGFetcher {
 	}
 }
 
-func (cGFetcher *CGFetcher) GetRateUsdTomo() (string, error) {
+func (cGFetcher *CGFetcher) GetRateUsdTomo(ctx context.Context) (string, error) {
 	// typeMarket := cGFetcher.typeMarket
 	url := cGFetcher.API + "/coins/tomochain"
-	b, err := fCommon.HTTPCall(url)
+	b, err := fCommon.HTTPCall(ctx, cGFetcher.typeMarket, url)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 	rateItem := tomochain.RateUSDCG{}
 	err = json.Unmarshal(b, &rateItem)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 	rateString := fmt.Sprintf("%.6f", rateItem.MarketData.CurrentPrice.USD)
 	return rateString, nil
 }
 
-func (cGFetcher *CGFetcher) GetGeneralInfo(coinID string) (*tomochain.TokenGeneralInfo, error) {
+func (cGFetcher *CGFetcher) GetGeneralInfo(ctx context.Context, coinID string) (*tomochain.TokenGeneralInfo, error) {
 	url := fmt.Sprintf("%s/coins/%s?tickers=false&community_data=false&developer_data=false&sparkline=false", cGFetcher.API, coinID)
 
-	b, err := fCommon.HTTPCall(url)
+	b, err := fCommon.HTTPCall(ctx, cGFetcher.typeMarket, url)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 	tokenItem := tomochain.TokenInfoCoinGecko{}
 
 	err = json.Unmarshal(b, &tokenItem)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 
-	log.Print("======================GetGeneralInfo", coinID, tokenItem)
+	log.WithFields(log.Fields{
+		"fetcher": cGFetcher.typeMarket,
+		"token":   coinID,
+	}).Debugf("general info %+v", tokenItem)
 
 	tokenGenalInfo := tokenItem.ToTokenInfoCMC()
 	return &tokenGenalInfo, nil
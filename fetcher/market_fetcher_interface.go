@@ -1,13 +1,15 @@
 package fetcher
 
 import (
+	"context"
+
 	mFetcher "github.com/marknguyen85/server-api/fetcher/market-fetcher"
 	"github.com/marknguyen85/server-api/tomochain"
 )
 
 type MarketFetcherInterface interface {
-	GetRateUsdTomo() (string, error)
-	GetGeneralInfo(string) (*tomochain.TokenGeneralInfo, error)
+	GetRateUsdTomo(context.Context) (string, error)
+	GetGeneralInfo(context.Context, string) (*tomochain.TokenGeneralInfo, error)
 }
 
 func NewMarketFetcherInterface() MarketFetcherInterface {
@@ -0,0 +1,485 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marknguyen85/server-api/config"
+	"github.com/marknguyen85/server-api/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// ewmaWeight of the latest call in the latency and error rate averages
+	ewmaWeight = 0.2
+	// latencySamples kept per connection to compute its p95
+	latencySamples = 100
+	// below minHedgeSamples the p95 is not trusted and defaultHedgeDelay is used
+	minHedgeSamples   = 10
+	defaultHedgeDelay = time.Second
+	minHedgeDelay     = 50 * time.Millisecond
+
+	// a failing connection score like one answering errorPenalty slower, a
+	// block behind the others like one answering lagPenalty slower
+	errorPenalty = 5 * time.Second
+	lagPenalty   = 500 * time.Millisecond
+	maxLagBlocks = 20
+)
+
+// errNoConnection is returned when the network has no usable connection
+var errNoConnection = errors.New("no connection available")
+
+// node is a connection of the pool with its health, kept across reloads
+// while its settings stay the same
+type node struct {
+	FetcherInterface
+	endpoint string
+	key      string
+
+	mu        sync.Mutex
+	latency   float64 // ewma in seconds
+	errorRate float64 // ewma of failures, between 0 and 1
+	samples   []float64
+	next      int
+	block     uint64
+	calls     uint64
+	failures  uint64
+	lastError string
+
+	// set by the verification, see verify.go
+	mismatches       uint64
+	quarantinedUntil time.Time
+}
+
+// ConnectionHealth is the state of a connection as shown on /admin/connections
+type ConnectionHealth struct {
+	Type      string  `json:"type"`
+	Endpoint  string  `json:"endpoint"`
+	Rank      int     `json:"rank"`
+	Score     float64 `json:"score_ms"`
+	Latency   float64 `json:"latency_ms"`
+	P95       float64 `json:"p95_ms"`
+	ErrorRate float64 `json:"error_rate"`
+	Block     uint64  `json:"block"`
+	Lag       uint64  `json:"lag"`
+	Calls     uint64  `json:"calls"`
+	Failures  uint64  `json:"failures"`
+	LastError string  `json:"last_error,omitempty"`
+
+	Mismatches       uint64     `json:"mismatches"`
+	QuarantinedUntil *time.Time `json:"quarantined_until,omitempty"`
+}
+
+// newNodes create a node per connection, reusing the ones of previous with
+// the same settings. Failed connections are logged, skipped and
+// reported by the returned error.
+func newNodes(connections []config.Connection, previous []*node) ([]*node, error) {
+	existing := make(map[string]*node, len(previous))
+	for _, n := range previous {
+		existing[n.key] = n
+	}
+	var failed error
+	nodes := make([]*node, 0, len(connections))
+	for _, connection := range connections {
+		if n, ok := existing[connectionKey(connection)]; ok {
+			nodes = append(nodes, n)
+			continue
+		}
+		newFetcher, err := NewFetcherIns(connection.Type, connection.Endpoint, connection.Apikey, connection.RateLimit)
+		if err == nil && newFetcher == nil {
+			err = fmt.Errorf("unknown type %s", connection.Type)
+		}
+		if err != nil {
+			log.WithFields(log.Fields{
+				"fetcher":  connection.Type,
+				"endpoint": connection.Endpoint,
+			}).WithError(err).Error("cannot create fetcher")
+			failed = fmt.Errorf("connection %s %s: %v", connection.Type, connection.Endpoint, err)
+			continue
+		}
+		nodes = append(nodes, &node{
+			FetcherInterface: newFetcher,
+			endpoint:         connection.Endpoint,
+			key:              connectionKey(connection),
+		})
+	}
+	return nodes, failed
+}
+
+// record update the health of the connection with a call which took elapsed
+func (n *node) record(elapsed time.Duration, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	seconds := elapsed.Seconds()
+	failure := 0.0
+	if err != nil {
+		failure = 1
+		n.failures++
+		n.lastError = err.Error()
+	}
+	if n.calls == 0 {
+		n.latency, n.errorRate = seconds, failure
+	} else {
+		n.latency += ewmaWeight * (seconds - n.latency)
+		n.errorRate += ewmaWeight * (failure - n.errorRate)
+	}
+	n.calls++
+	if err != nil {
+		// a failure can be fast, it says nothing of the latency to expect
+		return
+	}
+	if len(n.samples) < latencySamples {
+		n.samples = append(n.samples, seconds)
+	} else {
+		n.samples[n.next] = seconds
+		n.next = (n.next + 1) % latencySamples
+	}
+}
+
+// outrun record a call cancelled after elapsed because another connection
+// answered first: it would have taken longer, which is counted as its latency
+func (n *node) outrun(elapsed time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.calls == 0 {
+		n.latency = elapsed.Seconds()
+	} else {
+		n.latency += ewmaWeight * (elapsed.Seconds() - n.latency)
+	}
+	n.calls++
+}
+
+// observeBlock record the latest block number returned by the connection
+func (n *node) observeBlock(number string) {
+	block, err := strconv.ParseUint(number, 10, 64)
+	if err != nil {
+		return
+	}
+	n.mu.Lock()
+	if block > n.block {
+		n.block = block
+	}
+	n.mu.Unlock()
+}
+
+// p95 of the latency of the successful calls, zero without enough of them
+func (n *node) p95() time.Duration {
+	n.mu.Lock()
+	samples := append([]float64(nil), n.samples...)
+	n.mu.Unlock()
+	if len(samples) < minHedgeSamples {
+		return 0
+	}
+	sort.Float64s(samples)
+	index := int(math.Ceil(0.95*float64(len(samples)))) - 1
+	return time.Duration(samples[index] * float64(time.Second))
+}
+
+// hedgeDelay is how long to wait for the connection before asking another one
+func (n *node) hedgeDelay() time.Duration {
+	delay := n.p95()
+	if delay == 0 {
+		return defaultHedgeDelay
+	}
+	if delay < minHedgeDelay {
+		return minHedgeDelay
+	}
+	return delay
+}
+
+// health of the connection, lag is counted from head, the highest block seen
+func (n *node) health(head uint64) ConnectionHealth {
+	p95 := n.p95()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	var lag uint64
+	if n.block > 0 && head > n.block {
+		lag = head - n.block
+	}
+	var quarantinedUntil *time.Time
+	if time.Now().Before(n.quarantinedUntil) {
+		until := n.quarantinedUntil
+		quarantinedUntil = &until
+	}
+	return ConnectionHealth{
+		Type:      n.GetTypeName(),
+		Endpoint:  n.endpoint,
+		Latency:   n.latency * 1000,
+		P95:       float64(p95) / float64(time.Millisecond),
+		ErrorRate: n.errorRate,
+		Block:     n.block,
+		Lag:       lag,
+		Calls:     n.calls,
+		Failures:  n.failures,
+		LastError: n.lastError,
+
+		Mismatches:       n.mismatches,
+		QuarantinedUntil: quarantinedUntil,
+	}
+}
+
+// score of a health, lower is better: the expected latency plus penalties for
+// failures and lag. Connections never called score 0 so they get tried.
+func score(health ConnectionHealth) float64 {
+	lag := health.Lag
+	if lag > maxLagBlocks {
+		lag = maxLagBlocks
+	}
+	return health.Latency +
+		health.ErrorRate*float64(errorPenalty/time.Millisecond) +
+		float64(lag)*float64(lagPenalty/time.Millisecond)
+}
+
+func (fetcher *Fetcher) getNodes() []*node {
+	fetcher.mu.RLock()
+	defer fetcher.mu.RUnlock()
+	return fetcher.nodes
+}
+
+// rank return the nodes from the healthiest, quarantined ones last, with
+// their health. Ties keep the config order.
+func rank(nodes []*node) ([]*node, []ConnectionHealth) {
+	var head uint64
+	for _, n := range nodes {
+		n.mu.Lock()
+		if n.block > head {
+			head = n.block
+		}
+		n.mu.Unlock()
+	}
+	ranked := make([]*node, len(nodes))
+	copy(ranked, nodes)
+	healths := make(map[*node]ConnectionHealth, len(nodes))
+	for _, n := range nodes {
+		health := n.health(head)
+		health.Score = score(health)
+		healths[n] = health
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		left, right := healths[ranked[i]], healths[ranked[j]]
+		if (left.QuarantinedUntil == nil) != (right.QuarantinedUntil == nil) {
+			return left.QuarantinedUntil == nil
+		}
+		return left.Score < right.Score
+	})
+	result := make([]ConnectionHealth, len(ranked))
+	for i, n := range ranked {
+		result[i] = healths[n]
+		result[i].Rank = i + 1
+	}
+	return ranked, result
+}
+
+// Connections return the health of the connections, from the one called first
+func (fetcher *Fetcher) Connections() []ConnectionHealth {
+	_, healths := rank(fetcher.getNodes())
+	return healths
+}
+
+// getFetIns return the connections from the healthiest
+func (fetcher *Fetcher) getFetIns() []FetcherInterface {
+	ranked, _ := rank(fetcher.getNodes())
+	fetIns := make([]FetcherInterface, len(ranked))
+	for i, n := range ranked {
+		fetIns[i] = n.FetcherInterface
+	}
+	return fetIns
+}
+
+// callFunc is a call to one connection, its result is returned by call
+type callFunc func(ctx context.Context, fetIns FetcherInterface) (interface{}, error)
+
+// latestBlock is the result of a callFunc asking the latest block, call
+// record it in the health of every connection answering
+type latestBlock string
+
+// attempt is the outcome of a callFunc on a node
+type attempt struct {
+	node   *node
+	result interface{}
+	err    error
+}
+
+// call run fn on the healthiest connection accepted by use, nil for any.
+// Quarantined connections are only called when no other one is left. A
+// failure move to the next one; a connection slower than its p95 is hedged by
+// sending the same call to the next one, the first success is returned and
+// the others cancelled. The error of every connection is returned when all fail.
+func (fetcher *Fetcher) call(ctx context.Context, use func(FetcherInterface) bool, fn callFunc) (interface{}, error) {
+	ranked, healths := rank(fetcher.getNodes())
+	var nodes, quarantined []*node
+	for i, n := range ranked {
+		switch {
+		case use != nil && !use(n.FetcherInterface):
+		case healths[i].QuarantinedUntil != nil:
+			quarantined = append(quarantined, n)
+		default:
+			nodes = append(nodes, n)
+		}
+	}
+	if len(nodes) == 0 {
+		nodes = quarantined
+	}
+	if len(nodes) == 0 {
+		return nil, errNoConnection
+	}
+
+	callCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan attempt, len(nodes))
+	launch := func(n *node) {
+		go func() {
+			start := time.Now()
+			result, err := fn(callCtx, n.FetcherInterface)
+			switch {
+			case err == nil || callCtx.Err() == nil:
+				n.record(time.Since(start), err)
+			case ctx.Err() == nil:
+				// cancelled as another connection answered first
+				n.outrun(time.Since(start))
+			}
+			if block, ok := result.(latestBlock); ok && err == nil {
+				n.observeBlock(string(block))
+			}
+			results <- attempt{node: n, result: result, err: err}
+		}()
+	}
+
+	var (
+		timer   *time.Timer
+		hedge   <-chan time.Time
+		next    = 0
+		running = 0
+		errs    []string
+	)
+	startNext := func() {
+		n := nodes[next]
+		launch(n)
+		next++
+		running++
+		if timer != nil {
+			timer.Stop()
+		}
+		hedge = nil
+		if next < len(nodes) {
+			timer = time.NewTimer(n.hedgeDelay())
+			hedge = timer.C
+		}
+	}
+	defer func() {
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+
+	startNext()
+	for running > 0 {
+		select {
+		case outcome := <-results:
+			running--
+			if outcome.err == nil {
+				return outcome.result, nil
+			}
+			log.WithFields(log.Fields{
+				"network":  fetcher.info.Network,
+				"fetcher":  outcome.node.GetTypeName(),
+				"endpoint": outcome.node.endpoint,
+			}).WithError(outcome.err).Debug("fetcher call failed")
+			errs = append(errs, fmt.Sprintf("%s: %v", outcome.node.GetTypeName(), outcome.err))
+			if next < len(nodes) {
+				startNext()
+			}
+		case <-hedge:
+			metrics.ObserveHedge(nodes[next-1].GetTypeName())
+			startNext()
+		case <-callCtx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, fmt.Errorf("all %d connections failed: %s", len(nodes), strings.Join(errs, "; "))
+}
+
+// ProbeConnections ask every connection for the latest block, to keep the
+// health of the connections not called lately and measure their lag
+func (fetcher *Fetcher) ProbeConnections(ctx context.Context) {
+	probe(ctx, fetcher.getNodes())
+}
+
+// probe ask every node for the latest block and record the answers in their
+// health. The block of a node is 0 when it failed.
+func probe(ctx context.Context, nodes []*node) ([]uint64, []error) {
+	blocks := make([]uint64, len(nodes))
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n *node) {
+			defer wg.Done()
+			start := time.Now()
+			block, err := n.GetLatestBlock(ctx)
+			if ctx.Err() != nil {
+				errs[i] = ctx.Err()
+				return
+			}
+			n.record(time.Since(start), err)
+			if err == nil {
+				n.observeBlock(block)
+				blocks[i], err = strconv.ParseUint(block, 10, 64)
+			}
+			errs[i] = err
+		}(i, n)
+	}
+	wg.Wait()
+	return blocks, errs
+}
+
+// PinBlock return a block every connection can read: the lowest latest block
+// of the connections not quarantined, leaving out the ones lagging more than
+// maxLagBlocks. Pinning the head of the fastest connection would make the
+// calls hedged to a lagging one fail with an unknown block.
+func (fetcher *Fetcher) PinBlock(ctx context.Context) (string, error) {
+	ranked, healths := rank(fetcher.getNodes())
+	var nodes []*node
+	for i, n := range ranked {
+		if healths[i].QuarantinedUntil == nil {
+			nodes = append(nodes, n)
+		}
+	}
+	if len(nodes) == 0 {
+		nodes = ranked
+	}
+	if len(nodes) == 0 {
+		return "", errNoConnection
+	}
+	blocks, errs := probe(ctx, nodes)
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	var head uint64
+	var failures []string
+	for i, block := range blocks {
+		if block > head {
+			head = block
+		}
+		if errs[i] != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", nodes[i].GetTypeName(), errs[i]))
+		}
+	}
+	if head == 0 {
+		return "", fmt.Errorf("Cannot pin block: all %d connections failed: %s", len(nodes), strings.Join(failures, "; "))
+	}
+	pinned := head
+	for _, block := range blocks {
+		if block > 0 && head-block <= maxLagBlocks && block < pinned {
+			pinned = block
+		}
+	}
+	return strconv.FormatUint(pinned, 10), nil
+}
@@ -0,0 +1,85 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// headFetcher is a connection answering head as its latest block, after delay
+type headFetcher struct {
+	FetcherInterface
+	head  string
+	delay time.Duration
+}
+
+func (head *headFetcher) GetLatestBlock(ctx context.Context) (string, error) {
+	select {
+	case <-time.After(head.delay):
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if head.head == "" {
+		return "", errors.New("unavailable")
+	}
+	return head.head, nil
+}
+
+func (head *headFetcher) GetTypeName() string {
+	return "node"
+}
+
+// headNodes return a fetcher with a connection per head
+func headNodes(heads ...*headFetcher) *Fetcher {
+	fetcher := &Fetcher{info: &InfoData{}}
+	for _, head := range heads {
+		fetcher.nodes = append(fetcher.nodes, &node{FetcherInterface: head})
+	}
+	return fetcher
+}
+
+func TestGetLatestBlockObserved(t *testing.T) {
+	fetcher := headNodes(&headFetcher{head: "100"}, &headFetcher{head: "90", delay: time.Second})
+	block, err := fetcher.GetLatestBlock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block != "100" {
+		t.Errorf("latest block %s, want 100", block)
+	}
+	if observed := fetcher.nodes[0].health(0).Block; observed != 100 {
+		t.Errorf("block of the answering connection %d, want 100", observed)
+	}
+}
+
+func TestPinBlock(t *testing.T) {
+	fetcher := headNodes(
+		&headFetcher{head: "100"},
+		// lagging, the hedged calls must still find the block
+		&headFetcher{head: "95", delay: 10 * time.Millisecond},
+		// too far behind to hold the others
+		&headFetcher{head: "50"},
+		// quarantined
+		&headFetcher{head: "90"},
+		// failing
+		&headFetcher{},
+	)
+	fetcher.nodes[3].quarantinedUntil = time.Now().Add(time.Minute)
+	block, err := fetcher.PinBlock(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if block != "95" {
+		t.Errorf("pinned block %s, want 95", block)
+	}
+	for i, want := range []uint64{100, 95, 50, 0, 0} {
+		if observed := fetcher.nodes[i].health(0).Block; observed != want {
+			t.Errorf("block of connection %d %d, want %d", i, observed, want)
+		}
+	}
+
+	if _, err := headNodes(&headFetcher{}).PinBlock(context.Background()); err == nil {
+		t.Error("block pinned without answer")
+	}
+}
@@ -0,0 +1,118 @@
+package fetcher
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/marknguyen85/server-api/config"
+	"github.com/marknguyen85/server-api/tomochain"
+	log "github.com/sirupsen/logrus"
+)
+
+// backupTokens index the configured tokens by symbol
+func backupTokens(tokens []tomochain.TokenAPI) map[string]tomochain.Token {
+	listBackup := make(map[string]tomochain.Token)
+	for _, t := range tokens {
+		listBackup[t.Symbol] = tomochain.TokenAPIToToken(t)
+	}
+	return listBackup
+}
+
+// Reload swap the connections, backup tokens and token sources for the ones
+// of chain. Nothing change unless every connection can be created. Settings
+// which need a restart (network, trade topic, endpoints) are only reported.
+func (fetcher *Fetcher) Reload(chain config.Chain) error {
+	nodes, err := newNodes(chain.Connections, fetcher.getNodes())
+	if err != nil {
+		return err
+	}
+	backup := backupTokens(chain.Tokens)
+
+	info := fetcher.info
+	info.mu.RLock()
+	oldConnections := info.Connections
+	oldTokens := info.TokenAPI
+	usingBackup := info.tokensUpdatedAt == 0
+	sourcesChanged := !reflect.DeepEqual(info.TokenSources, chain.TokenSources)
+	restart := info.Network != chain.Network || info.TradeTopic != chain.TradeTopic ||
+		info.ConfigEndpoint != chain.ConfigEndpoint || info.APIEndpoint != chain.APIEndpoint ||
+		info.GasStationEndpoint != chain.GasStationEndpoint || info.ApiUsd != chain.ApiUsd
+	info.mu.RUnlock()
+
+	fetcher.mu.Lock()
+	info.mu.Lock()
+	fetcher.nodes = nodes
+	info.Connections = chain.Connections
+	info.TokenAPI = chain.Tokens
+	info.BackupTokens = backup
+	info.TokenSources = chain.TokenSources
+	info.mu.Unlock()
+	fetcher.mu.Unlock()
+
+	// the list from config endpoint was never fetched: serve the new backup now
+	if usingBackup {
+		info.UpdateByBackupToken()
+	}
+
+	logConnectionsDiff(oldConnections, chain.Connections)
+	logTokensDiff(oldTokens, chain.Tokens)
+	if sourcesChanged {
+		log.WithField("precedence", strings.Join(chain.TokenSources.Order(), ",")).Info("token sources changed, applied at the next token list refresh")
+	}
+	if restart {
+		log.Warn("network, trade topic or endpoints changed, restart to apply them")
+	}
+	return nil
+}
+
+// connectionKey identify a connection, a change of any setting replace it
+func connectionKey(connection config.Connection) string {
+	return fmt.Sprintf("%s %s %s %g", connection.Type, connection.Endpoint, connection.Apikey, connection.RateLimit)
+}
+
+func logConnectionsDiff(oldConnections, newConnections []config.Connection) {
+	before := make(map[string]bool)
+	for _, c := range oldConnections {
+		before[connectionKey(c)] = true
+	}
+	after := make(map[string]bool)
+	for _, c := range newConnections {
+		after[connectionKey(c)] = true
+		if !before[connectionKey(c)] {
+			log.WithFields(log.Fields{"fetcher": c.Type, "endpoint": c.Endpoint}).Info("connection added")
+		}
+	}
+	for _, c := range oldConnections {
+		if !after[connectionKey(c)] {
+			log.WithFields(log.Fields{"fetcher": c.Type, "endpoint": c.Endpoint}).Info("connection removed")
+		}
+	}
+}
+
+func logTokensDiff(oldTokens, newTokens []tomochain.TokenAPI) {
+	before := make(map[string]tomochain.TokenAPI)
+	for _, t := range oldTokens {
+		before[t.Symbol] = t
+	}
+	after := make(map[string]bool)
+	for _, t := range newTokens {
+		after[t.Symbol] = true
+		old, ok := before[t.Symbol]
+		switch {
+		case !ok:
+			log.WithFields(log.Fields{"token": t.Symbol, "address": t.Address}).Info("backup token added")
+		case old != t:
+			log.WithFields(log.Fields{
+				"token": t.Symbol,
+				"old":   fmt.Sprintf("%+v", old),
+				"new":   fmt.Sprintf("%+v", t),
+			}).Info("backup token changed")
+		}
+	}
+	for _, t := range oldTokens {
+		if !after[t.Symbol] {
+			log.WithFields(log.Fields{"token": t.Symbol, "address": t.Address}).Info("backup token removed")
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"sync/atomic"
+	"time"
+
+	bFetcher "github.com/marknguyen85/server-api/fetcher/blockchain-fetcher"
+	"github.com/marknguyen85/server-api/tomochain"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	minResubscribeDelay = time.Second
+	maxResubscribeDelay = 30 * time.Second
+)
+
+// blockSubscriber is a connection pushing new blocks and logs, the ws type
+type blockSubscriber interface {
+	GetTypeName() string
+	SubscribeNewHeads(ctx context.Context, heads chan<- bFetcher.Head) (bFetcher.Subscription, error)
+	SubscribeLogs(ctx context.Context, address, topic string, logs chan<- tomochain.EventRaw) (bFetcher.Subscription, error)
+}
+
+func (fetcher *Fetcher) getBlockSubscriber() blockSubscriber {
+	for _, fetIns := range fetcher.getFetIns() {
+		if subscriber, ok := fetIns.(blockSubscriber); ok {
+			return subscriber
+		}
+	}
+	return nil
+}
+
+// TradesSubscribed tell whether trades are pushed by a logs subscription, so
+// polling does not need to read them
+func (fetcher *Fetcher) TradesSubscribed() bool {
+	return atomic.LoadInt32(&fetcher.tradesSubscribed) == 1
+}
+
+// WatchBlocks call onHead with the number of every new block and onTrades
+// with the trades of the network, as pushed by the first ws connection. The
+// subscriptions are renewed with a growing delay when they fail and the
+// client reconnect. It return when ctx is done; until then the polling jobs
+// keep the data fresh when no ws connection is configured or reachable.
+func (fetcher *Fetcher) WatchBlocks(ctx context.Context, onHead func(block string), onTrades func([]tomochain.EventHistory)) {
+	delay := minResubscribeDelay
+	for {
+		subscriber := fetcher.getBlockSubscriber()
+		if subscriber == nil {
+			// a ws connection may be added by a config reload
+			delay = maxResubscribeDelay
+		} else {
+			received, err := fetcher.watchBlocks(ctx, subscriber, onHead, onTrades)
+			if ctx.Err() != nil {
+				return
+			}
+			if received {
+				delay = minResubscribeDelay
+			}
+			log.WithField("fetcher", subscriber.GetTypeName()).WithError(err).
+				WithField("retry_in", delay.String()).Warn("block subscription ended, polling until resubscribed")
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		if subscriber != nil {
+			delay *= 2
+			if delay > maxResubscribeDelay {
+				delay = maxResubscribeDelay
+			}
+		}
+	}
+}
+
+// watchBlocks run one subscription until it fail, telling whether a block was
+// received
+func (fetcher *Fetcher) watchBlocks(ctx context.Context, subscriber blockSubscriber, onHead func(string), onTrades func([]tomochain.EventHistory)) (bool, error) {
+	heads := make(chan bFetcher.Head, 16)
+	headSub, err := subscriber.SubscribeNewHeads(ctx, heads)
+	if err != nil {
+		return false, err
+	}
+	defer headSub.Unsubscribe()
+
+	// without logs subscription trades are still read by polling
+	var logsErr <-chan error
+	logs := make(chan tomochain.EventRaw, 16)
+	if fetcher.info.TradeTopic != "" {
+		logSub, err := subscriber.SubscribeLogs(ctx, fetcher.info.Network, fetcher.info.TradeTopic, logs)
+		if err != nil {
+			log.WithField("fetcher", subscriber.GetTypeName()).WithError(err).Warn("cannot subscribe to trade logs")
+		} else {
+			defer logSub.Unsubscribe()
+			logsErr = logSub.Err()
+			atomic.StoreInt32(&fetcher.tradesSubscribed, 1)
+			defer atomic.StoreInt32(&fetcher.tradesSubscribed, 0)
+		}
+	}
+	log.WithField("fetcher", subscriber.GetTypeName()).WithField("trades", logsErr != nil).Info("subscribed to new blocks")
+
+	received := false
+	for {
+		select {
+		case <-ctx.Done():
+			return received, nil
+		case err := <-headSub.Err():
+			if err == nil {
+				err = errors.New("subscription closed")
+			}
+			return received, err
+		case err := <-logsErr:
+			log.WithField("fetcher", subscriber.GetTypeName()).WithError(err).Warn("trade logs subscription ended, polling trades")
+			atomic.StoreInt32(&fetcher.tradesSubscribed, 0)
+			logsErr = nil
+		case head := <-heads:
+			if head.Number == nil {
+				continue
+			}
+			received = true
+			onHead((*big.Int)(head.Number).String())
+		case eventRaw := <-logs:
+			block, err := decodeBlock(eventRaw.BlockNumber)
+			if err != nil {
+				log.WithField("txhash", eventRaw.Txhash).WithError(err).Warn("skip trade event")
+				continue
+			}
+			events, err := fetcher.tomochain.ReadEventsWithBlockNumber(&[]tomochain.EventRaw{eventRaw}, block)
+			if err != nil {
+				log.WithField("txhash", eventRaw.Txhash).WithError(err).Warn("skip trade event")
+				continue
+			}
+			if len(*events) > 0 {
+				onTrades(*events)
+			}
+		}
+	}
+}
+
+// decodeBlock convert the hex block number of a log to decimal
+func decodeBlock(hexBlock string) (string, error) {
+	block, ok := new(big.Int).SetString(hexBlock, 0)
+	if !ok {
+		return "", errors.New("invalid block number " + hexBlock)
+	}
+	return block.String(), nil
+}
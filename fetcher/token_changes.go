@@ -0,0 +1,56 @@
+package fetcher
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/marknguyen85/server-api/tomochain"
+)
+
+// DiffTokens return the changes from the token list before to the one after,
+// by token id, seen at now. A token is delisted when the list set its delist
+// time, it stay listed TIME_TO_DELETE longer, or when it leave the list
+// without one; a delist time removed list it again.
+func DiffTokens(before, after map[string]tomochain.Token, now int64) []tomochain.TokenChange {
+	var changes []tomochain.TokenChange
+	for id, token := range after {
+		old, ok := before[id]
+		if !ok {
+			changes = append(changes, tokenChange(tomochain.TokenListed, id, token, now))
+			continue
+		}
+		if !strings.EqualFold(old.Address, token.Address) {
+			change := tokenChange(tomochain.TokenAddressChanged, id, token, now)
+			change.OldAddress = old.Address
+			changes = append(changes, change)
+		}
+		switch {
+		case old.DelistTime == 0 && token.DelistTime != 0:
+			change := tokenChange(tomochain.TokenDelisted, id, token, now)
+			change.DelistTime = token.DelistTime
+			changes = append(changes, change)
+		case old.DelistTime != 0 && token.DelistTime == 0:
+			changes = append(changes, tokenChange(tomochain.TokenListed, id, token, now))
+		}
+	}
+	for id, token := range before {
+		// a token with a delist time was reported delisted when it was set
+		if _, ok := after[id]; !ok && token.DelistTime == 0 {
+			changes = append(changes, tokenChange(tomochain.TokenDelisted, id, token, now))
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		return changes[i].TokenID < changes[j].TokenID
+	})
+	return changes
+}
+
+func tokenChange(kind, id string, token tomochain.Token, now int64) tomochain.TokenChange {
+	return tomochain.TokenChange{
+		Type:      kind,
+		TokenID:   id,
+		Symbol:    token.Symbol,
+		Address:   token.Address,
+		Timestamp: now,
+	}
+}
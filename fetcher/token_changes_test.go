@@ -0,0 +1,58 @@
+package fetcher
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/marknguyen85/server-api/tomochain"
+)
+
+func TestDiffTokens(t *testing.T) {
+	before := map[string]tomochain.Token{
+		"TKN": {Symbol: "TKN", Address: tokenAddr},
+		"OLD": {Symbol: "OLD", Address: otherAddr},
+		"MOV": {Symbol: "MOV", Address: otherAddr},
+		"DEL": {Symbol: "DEL", Address: otherAddr},
+		"BAK": {Symbol: "BAK", Address: otherAddr, DelistTime: 5},
+	}
+	after := map[string]tomochain.Token{
+		// the same address in another case is no change
+		"TKN": {Symbol: "TKN", Address: "0x" + strings.ToUpper(tokenAddr[2:])},
+		"MOV": {Symbol: "MOV", Address: listAddr},
+		"NEW": {Symbol: "NEW", Address: registryAddr},
+		// delisting announced, the token stay listed for TIME_TO_DELETE
+		"DEL": {Symbol: "DEL", Address: otherAddr, DelistTime: 10},
+		// delisting cancelled
+		"BAK": {Symbol: "BAK", Address: otherAddr},
+	}
+	changes := DiffTokens(before, after, 100)
+	want := []tomochain.TokenChange{
+		{Type: tomochain.TokenListed, TokenID: "BAK", Symbol: "BAK", Address: otherAddr, Timestamp: 100},
+		{Type: tomochain.TokenDelisted, TokenID: "DEL", Symbol: "DEL", Address: otherAddr, DelistTime: 10, Timestamp: 100},
+		{Type: tomochain.TokenAddressChanged, TokenID: "MOV", Symbol: "MOV", Address: listAddr, OldAddress: otherAddr, Timestamp: 100},
+		{Type: tomochain.TokenListed, TokenID: "NEW", Symbol: "NEW", Address: registryAddr, Timestamp: 100},
+		{Type: tomochain.TokenDelisted, TokenID: "OLD", Symbol: "OLD", Address: otherAddr, Timestamp: 100},
+	}
+	if len(changes) != len(want) {
+		t.Fatalf("changes %+v, want %+v", changes, want)
+	}
+	for i := range want {
+		if changes[i] != want[i] {
+			t.Errorf("change %d: %+v, want %+v", i, changes[i], want[i])
+		}
+	}
+	if changes := DiffTokens(after, after, 100); len(changes) != 0 {
+		t.Errorf("changes of the same list: %+v", changes)
+	}
+
+	// DEL leave the list once TIME_TO_DELETE is over, already reported
+	gone := make(map[string]tomochain.Token)
+	for id, token := range after {
+		if id != "DEL" {
+			gone[id] = token
+		}
+	}
+	if changes := DiffTokens(after, gone, 200); len(changes) != 0 {
+		t.Errorf("changes when a delisted token leave the list: %+v", changes)
+	}
+}
@@ -0,0 +1,207 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	fCommon "github.com/marknguyen85/server-api/fetcher/fetcher-common"
+	"github.com/marknguyen85/server-api/tomochain"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	// maxListTokens is the most tokens a token list may hold
+	maxListTokens = 10000
+	// maxListProblems is the most problems reported for an invalid list
+	maxListProblems = 10
+)
+
+var (
+	listAddressRegexp = regexp.MustCompile(`^0x[0-9a-fA-F]{40}$`)
+	listSymbolRegexp  = regexp.MustCompile(`^[a-zA-Z0-9+\-%/$.]+$`)
+
+	// ErrBadSignature is the error of a token list not signed by the key
+	ErrBadSignature = errors.New("token list signature does not match the public key")
+)
+
+// TokenList is a token list in the Uniswap format, see
+// https://github.com/Uniswap/token-lists
+type TokenList struct {
+	Name      string           `json:"name"`
+	Timestamp string           `json:"timestamp"`
+	Version   TokenListVersion `json:"version"`
+	Tokens    []TokenListToken `json:"tokens"`
+	Keywords  []string         `json:"keywords,omitempty"`
+	Tags      json.RawMessage  `json:"tags,omitempty"`
+	LogoURI   string           `json:"logoURI,omitempty"`
+}
+
+// TokenListVersion is the semantic version of a list
+type TokenListVersion struct {
+	Major *int `json:"major"`
+	Minor *int `json:"minor"`
+	Patch *int `json:"patch"`
+}
+
+// TokenListToken is a token of a list. The coingecko id is read from the
+// cg_id extension.
+type TokenListToken struct {
+	ChainID    int                    `json:"chainId"`
+	Address    string                 `json:"address"`
+	Name       string                 `json:"name"`
+	Symbol     string                 `json:"symbol"`
+	Decimals   *int                   `json:"decimals"`
+	LogoURI    string                 `json:"logoURI,omitempty"`
+	Tags       []string               `json:"tags,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// ParseTokenList decode data and check it against the token list schema:
+// required fields, lengths and patterns, no unknown field and no token listed
+// twice on a chain
+func ParseTokenList(data []byte) (*TokenList, error) {
+	var list TokenList
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid token list: %v", err)
+	}
+
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if n := utf8.RuneCountInString(list.Name); n < 1 || n > 30 {
+		add("name: must be 1 to 30 characters")
+	}
+	if _, err := time.Parse(time.RFC3339, list.Timestamp); err != nil {
+		add("timestamp: must be an RFC 3339 date-time")
+	}
+	version := list.Version
+	if version.Major == nil || version.Minor == nil || version.Patch == nil ||
+		*version.Major < 0 || *version.Minor < 0 || *version.Patch < 0 {
+		add("version: major, minor and patch must be non negative integers")
+	}
+	if len(list.Tokens) < 1 || len(list.Tokens) > maxListTokens {
+		add("tokens: must hold 1 to %d tokens", maxListTokens)
+	}
+
+	addresses := make(map[string]bool)
+	symbols := make(map[string]bool)
+	for i, token := range list.Tokens {
+		if token.ChainID < 1 {
+			add("tokens[%d].chainId: must be positive", i)
+		}
+		if !listAddressRegexp.MatchString(token.Address) {
+			add("tokens[%d].address: invalid address %q", i, token.Address)
+		}
+		if n := utf8.RuneCountInString(token.Name); n < 1 || n > 40 {
+			add("tokens[%d].name: must be 1 to 40 characters", i)
+		}
+		if len(token.Symbol) < 1 || len(token.Symbol) > 20 || !listSymbolRegexp.MatchString(token.Symbol) {
+			add("tokens[%d].symbol: invalid symbol %q", i, token.Symbol)
+		}
+		if token.Decimals == nil || *token.Decimals < 0 || *token.Decimals > 255 {
+			add("tokens[%d].decimals: must be 0 to 255", i)
+		}
+		address := fmt.Sprintf("%d/%s", token.ChainID, strings.ToLower(token.Address))
+		if addresses[address] {
+			add("tokens[%d].address: %s listed twice", i, token.Address)
+		}
+		addresses[address] = true
+		symbol := fmt.Sprintf("%d/%s", token.ChainID, token.Symbol)
+		if symbols[symbol] {
+			add("tokens[%d].symbol: %s listed twice", i, token.Symbol)
+		}
+		symbols[symbol] = true
+	}
+
+	if len(problems) > 0 {
+		more := ""
+		if len(problems) > maxListProblems {
+			more = fmt.Sprintf(" (and %d more)", len(problems)-maxListProblems)
+			problems = problems[:maxListProblems]
+		}
+		return nil, fmt.Errorf("invalid token list: %s%s", strings.Join(problems, "; "), more)
+	}
+	return &list, nil
+}
+
+// ChainTokens return the tokens of chainID, or every token when chainID is
+// zero
+func (list *TokenList) ChainTokens(chainID int) []tomochain.Token {
+	tokens := make([]tomochain.Token, 0, len(list.Tokens))
+	for _, token := range list.Tokens {
+		if chainID != 0 && token.ChainID != chainID {
+			continue
+		}
+		cgID, _ := token.Extensions["cg_id"].(string)
+		tokens = append(tokens, tomochain.Token{
+			Name:    token.Name,
+			Symbol:  token.Symbol,
+			Address: token.Address,
+			Decimal: *token.Decimals,
+			CGId:    cgID,
+			TokenID: token.Symbol,
+		})
+	}
+	return tokens
+}
+
+// VerifyTokenList check signature, the detached ed25519 signature of data by
+// key. The signature is raw, hex or base64 encoded.
+func VerifyTokenList(data, signature, key []byte) error {
+	if len(key) != ed25519.PublicKeySize {
+		return fmt.Errorf("public key is %d bytes, want %d", len(key), ed25519.PublicKeySize)
+	}
+	raw := signature
+	if len(raw) != ed25519.SignatureSize {
+		text := strings.TrimSpace(string(signature))
+		var err error
+		if raw, err = hex.DecodeString(strings.TrimPrefix(text, "0x")); err != nil {
+			if raw, err = base64.StdEncoding.DecodeString(text); err != nil {
+				return errors.New("token list signature is neither raw, hex nor base64")
+			}
+		}
+	}
+	if len(raw) != ed25519.SignatureSize || !ed25519.Verify(ed25519.PublicKey(key), data, raw) {
+		return ErrBadSignature
+	}
+	return nil
+}
+
+// GetTokenList get the token list at listURL and its signature at
+// signatureURL, and return the tokens of chainID once the signature by key is
+// checked
+func (httpFetcher *HTTPFetcher) GetTokenList(ctx context.Context, listURL, signatureURL string, key []byte, chainID int) ([]tomochain.Token, error) {
+	data, err := fCommon.HTTPCall(ctx, httpFetcher.typeName, listURL)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := fCommon.HTTPCall(ctx, httpFetcher.typeName, signatureURL)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get token list signature: %v", err)
+	}
+	// checked before parsing, an unsigned body is not trusted with the parser
+	if err := VerifyTokenList(data, signature, key); err != nil {
+		return nil, err
+	}
+	list, err := ParseTokenList(data)
+	if err != nil {
+		return nil, err
+	}
+	tokens := list.ChainTokens(chainID)
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("token list has no token of chain %d", chainID)
+	}
+	return tokens, nil
+}
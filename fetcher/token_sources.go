@@ -0,0 +1,214 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/marknguyen85/server-api/common"
+	"github.com/marknguyen85/server-api/config"
+	"github.com/marknguyen85/server-api/tomochain"
+	log "github.com/sirupsen/logrus"
+)
+
+// sourcedTokens are the tokens read from a source
+type sourcedTokens struct {
+	source string
+	tokens []tomochain.Token
+}
+
+// tokenID is the key of a token in the token list
+func tokenID(token tomochain.Token) string {
+	if token.TokenID != "" {
+		return token.TokenID
+	}
+	return token.Symbol
+}
+
+// mergeTokens merge the tokens of the sources, from the highest precedence.
+// A token is known by its id and its address: the first source listing either
+// decide the token, a later one listing the same id at another address, or the
+// same address under another id, is ignored so a source cannot swap the
+// address of a token it does not own. Later sources listing the same token
+// fill its empty fields, and its priority.
+func mergeTokens(lists []sourcedTokens) []tomochain.Token {
+	var merged []tomochain.Token
+	byID := make(map[string]int)
+	byAddress := make(map[string]int)
+	for _, list := range lists {
+		for _, token := range list.tokens {
+			id, address := tokenID(token), strings.ToLower(token.Address)
+			i, idKnown := byID[id]
+			j, addressKnown := byAddress[address]
+			switch {
+			case !idKnown && !addressKnown:
+				token.TokenID = id
+				byID[id] = len(merged)
+				byAddress[address] = len(merged)
+				merged = append(merged, token)
+			case idKnown && addressKnown && i == j:
+				fillToken(&merged[i], token)
+			default:
+				conflict := merged[i]
+				if !idKnown {
+					conflict = merged[j]
+				}
+				log.WithFields(log.Fields{
+					"source":  list.source,
+					"token":   id,
+					"address": token.Address,
+					"kept":    conflict.TokenID + " " + conflict.Address,
+				}).Warn("token conflicts with a source of higher precedence, ignored")
+			}
+		}
+	}
+	return merged
+}
+
+// fillToken set the empty fields of token from other, the same token read
+// from a source of lower precedence
+func fillToken(token *tomochain.Token, other tomochain.Token) {
+	if token.Name == "" {
+		token.Name = other.Name
+	}
+	if token.CGId == "" {
+		token.CGId = other.CGId
+	}
+	if token.DelistTime == 0 {
+		token.DelistTime = other.DelistTime
+	}
+	token.Priority = token.Priority || other.Priority
+}
+
+// GetSourcedTokens read the tokens of every source of the chain and merge
+// them. A failing source fail the whole list: a partial one would drop the
+// tokens of the failing source.
+func (fetcher *Fetcher) GetSourcedTokens(ctx context.Context) ([]tomochain.Token, error) {
+	sources := fetcher.info.GetTokenSources()
+	lists := make([]sourcedTokens, 0, len(sources.Order()))
+	for _, source := range sources.Order() {
+		tokens, err := fetcher.readTokenSource(ctx, source, sources)
+		if err != nil {
+			return nil, fmt.Errorf("token source %s: %v", source, err)
+		}
+		lists = append(lists, sourcedTokens{source: source, tokens: tokens})
+	}
+	if len(lists) == 1 {
+		return lists[0].tokens, nil
+	}
+	return mergeTokens(lists), nil
+}
+
+func (fetcher *Fetcher) readTokenSource(ctx context.Context, source string, sources config.TokenSources) ([]tomochain.Token, error) {
+	switch source {
+	case config.TokenSourceConfig:
+		return fetcher.httpFetcher.GetListToken(ctx)
+	case config.TokenSourceRegistry:
+		registry := sources.Registry
+		if registry == "" {
+			registry = fetcher.info.Network
+		}
+		return fetcher.GetRegistryTokens(ctx, registry)
+	case config.TokenSourceList:
+		key, err := sources.Key()
+		if err != nil {
+			return nil, err
+		}
+		return fetcher.httpFetcher.GetTokenList(ctx, sources.List, sources.SignatureURL(), key, sources.ChainID)
+	}
+	return nil, fmt.Errorf("unknown token source %q", source)
+}
+
+// GetRegistryTokens read the tokens listed by the registry contract, with the
+// name, symbol and decimals of their erc20 contract, all at the same block.
+// Tokens whose metadata cannot be read are skipped.
+func (fetcher *Fetcher) GetRegistryTokens(ctx context.Context, registry string) ([]tomochain.Token, error) {
+	block, err := fetcher.PinBlock(ctx)
+	if err != nil {
+		return nil, err
+	}
+	result, err := fetcher.callRegistry(ctx, registry, "getListedTokens", block)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get listed tokens: %v", err)
+	}
+	addresses, err := fetcher.tomochain.ExtractListedTokens(result)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens := make([]tomochain.Token, 0, len(addresses))
+	for _, address := range addresses {
+		if strings.EqualFold(address, common.TOMOAddr) {
+			// the native coin has no contract
+			tokens = append(tokens, tomochain.Token{
+				Name:    "TomoChain",
+				Symbol:  common.TOMOSymbol,
+				Address: common.TOMOAddr,
+				Decimal: 18,
+				TokenID: common.TOMOSymbol,
+			})
+			continue
+		}
+		token, err := fetcher.readRegistryToken(ctx, address, block)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			log.WithField("address", address).WithError(err).Warn("cannot read registry token, skipped")
+			continue
+		}
+		tokens = append(tokens, token)
+	}
+	if len(tokens) == 0 {
+		return nil, errors.New("registry lists no token")
+	}
+	return tokens, nil
+}
+
+// readRegistryToken read the erc20 metadata of the token at address
+func (fetcher *Fetcher) readRegistryToken(ctx context.Context, address, block string) (tomochain.Token, error) {
+	token := tomochain.Token{Address: address}
+	for _, field := range []struct {
+		method string
+		value  *string
+	}{
+		{"symbol", &token.Symbol},
+		{"name", &token.Name},
+	} {
+		result, err := fetcher.callRegistry(ctx, address, field.method, block)
+		if err != nil {
+			return token, fmt.Errorf("cannot get %s: %v", field.method, err)
+		}
+		if *field.value, err = fetcher.tomochain.ExtractTokenString(field.method, result); err != nil {
+			return token, fmt.Errorf("cannot read %s: %v", field.method, err)
+		}
+	}
+	if token.Symbol == "" {
+		return token, errors.New("empty symbol")
+	}
+	result, err := fetcher.callRegistry(ctx, address, "decimals", block)
+	if err != nil {
+		return token, fmt.Errorf("cannot get decimals: %v", err)
+	}
+	if token.Decimal, err = fetcher.tomochain.ExtractTokenDecimals(result); err != nil {
+		return token, fmt.Errorf("cannot read decimals: %v", err)
+	}
+	token.TokenID = token.Symbol
+	return token, nil
+}
+
+// callRegistry call method, without argument, on contract to at block
+func (fetcher *Fetcher) callRegistry(ctx context.Context, to, method, block string) (string, error) {
+	dataAbi, err := fetcher.tomochain.EncodeRegistry(method)
+	if err != nil {
+		return "", err
+	}
+	result, err := fetcher.call(ctx, nil, func(ctx context.Context, fetIns FetcherInterface) (interface{}, error) {
+		return fetIns.TomoCall(ctx, to, dataAbi, block)
+	})
+	if err != nil {
+		return "", err
+	}
+	return result.(string), nil
+}
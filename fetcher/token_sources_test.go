@@ -0,0 +1,221 @@
+package fetcher
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/marknguyen85/server-api/common"
+	"github.com/marknguyen85/server-api/config"
+	bFetcher "github.com/marknguyen85/server-api/fetcher/blockchain-fetcher"
+	"github.com/marknguyen85/server-api/tomochain"
+	"golang.org/x/crypto/ed25519"
+)
+
+const (
+	registryAddr = "0x4444444444444444444444444444444444444444"
+	otherAddr    = "0x5555555555555555555555555555555555555555"
+	listAddr     = "0x6666666666666666666666666666666666666666"
+)
+
+const validList = `{
+  "name": "ChainTeX tokens",
+  "timestamp": "2019-05-01T00:00:00Z",
+  "version": {"major": 1, "minor": 2, "patch": 0},
+  "tokens": [
+    {"chainId": 88, "address": "` + tokenAddr + `", "name": "Token", "symbol": "TKN", "decimals": 18, "extensions": {"cg_id": "token"}},
+    {"chainId": 88, "address": "` + listAddr + `", "name": "Listed", "symbol": "LST", "decimals": 6},
+    {"chainId": 89, "address": "` + otherAddr + `", "name": "Testnet", "symbol": "TST", "decimals": 18}
+  ]
+}`
+
+func TestParseTokenList(t *testing.T) {
+	list, err := ParseTokenList([]byte(validList))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tokens := list.ChainTokens(88)
+	if len(tokens) != 2 {
+		t.Fatalf("%d tokens of chain 88, want 2", len(tokens))
+	}
+	if tokens[0].Symbol != "TKN" || tokens[0].TokenID != "TKN" || tokens[0].Decimal != 18 || tokens[0].CGId != "token" {
+		t.Errorf("token %+v", tokens[0])
+	}
+	if len(list.ChainTokens(0)) != 3 {
+		t.Error("chain 0 does not keep every token")
+	}
+
+	invalid := map[string]string{
+		"unknown field":    strings.Replace(validList, `"name": "ChainTeX tokens"`, `"name": "ChainTeX tokens", "owner": "x"`, 1),
+		"no timestamp":     strings.Replace(validList, `"2019-05-01T00:00:00Z"`, `""`, 1),
+		"no version patch": strings.Replace(validList, `, "patch": 0`, ``, 1),
+		"bad address":      strings.Replace(validList, listAddr, "0x66", 1),
+		"bad symbol":       strings.Replace(validList, `"LST"`, `"L S"`, 1),
+		"decimals range":   strings.Replace(validList, `"decimals": 6`, `"decimals": 256`, 1),
+		"no decimals":      strings.Replace(validList, `, "decimals": 6`, ``, 1),
+		"duplicate":        strings.Replace(validList, listAddr, tokenAddr, 1),
+		"no token":         `{"name": "empty", "timestamp": "2019-05-01T00:00:00Z", "version": {"major": 1, "minor": 0, "patch": 0}, "tokens": []}`,
+		"not json":         `tokens`,
+	}
+	for name, data := range invalid {
+		if _, err := ParseTokenList([]byte(data)); err == nil {
+			t.Errorf("%s: list accepted", name)
+		}
+	}
+}
+
+func TestVerifyTokenList(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+	public := key.Public().(ed25519.PublicKey)
+	data := []byte(validList)
+	signature := ed25519.Sign(key, data)
+
+	for name, encoded := range map[string][]byte{
+		"raw":    signature,
+		"hex":    []byte(hex.EncodeToString(signature) + "\n"),
+		"base64": []byte(base64.StdEncoding.EncodeToString(signature)),
+	} {
+		if err := VerifyTokenList(data, encoded, public); err != nil {
+			t.Errorf("%s signature: %v", name, err)
+		}
+	}
+
+	tampered := []byte(strings.Replace(validList, tokenAddr, otherAddr, 1))
+	if err := VerifyTokenList(tampered, signature, public); err != ErrBadSignature {
+		t.Errorf("tampered list: %v", err)
+	}
+	otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+	if err := VerifyTokenList(data, ed25519.Sign(otherKey, data), public); err != ErrBadSignature {
+		t.Errorf("list signed by another key: %v", err)
+	}
+	if err := VerifyTokenList(data, []byte("not a signature"), public); err == nil {
+		t.Error("garbage signature accepted")
+	}
+}
+
+func TestMergeTokens(t *testing.T) {
+	merged := mergeTokens([]sourcedTokens{
+		{source: "registry", tokens: []tomochain.Token{
+			{Symbol: "TKN", Address: tokenAddr, Decimal: 18},
+		}},
+		{source: "config", tokens: []tomochain.Token{
+			// same token, fill the fields the registry lack
+			{Symbol: "TKN", Address: "0x" + strings.ToUpper(tokenAddr[2:]), Name: "Token", CGId: "token", Priority: true},
+			// the id of a registry token at another address
+			{Symbol: "TKN", Address: otherAddr},
+			// the address of a registry token under another id
+			{Symbol: "FAKE", TokenID: "FAKE", Address: tokenAddr},
+			{Symbol: "CFG", Address: otherAddr, DelistTime: 10},
+		}},
+	})
+	if len(merged) != 2 {
+		t.Fatalf("%d tokens, want 2: %+v", len(merged), merged)
+	}
+	token := merged[0]
+	if token.Address != tokenAddr || token.TokenID != "TKN" || token.Name != "Token" || token.CGId != "token" ||
+		!token.Priority || token.Decimal != 18 {
+		t.Errorf("merged TKN %+v", token)
+	}
+	if merged[1].TokenID != "CFG" || merged[1].DelistTime != 10 {
+		t.Errorf("added CFG %+v", merged[1])
+	}
+}
+
+// newRegistryFetcher return a fetcher of chain on the simulated network name,
+// whose registry list TOMO and TKN
+func newRegistryFetcher(t *testing.T, name string, chain config.Chain) *Fetcher {
+	chain.Network = registryAddr
+	chain.AverageBlockTime = 2000
+	chain.Connections = []config.Connection{{Type: "simulated", Endpoint: "sim://" + name}}
+	fetcher, err := NewFetcher(chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	network := bFetcher.Simulation(name)
+	network.SetTokens([]bFetcher.SimulatedToken{
+		{Address: common.TOMOAddr},
+		{Address: tokenAddr, Name: "Token", Symbol: "TKN", Decimals: 9},
+	})
+	return fetcher
+}
+
+func TestRegistryTokens(t *testing.T) {
+	fetcher := newRegistryFetcher(t, "registry", config.Chain{})
+	tokens, err := fetcher.GetRegistryTokens(context.Background(), registryAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tokens) != 2 {
+		t.Fatalf("%d tokens, want 2: %+v", len(tokens), tokens)
+	}
+	if tokens[0].Symbol != common.TOMOSymbol || tokens[0].Decimal != 18 {
+		t.Errorf("native token %+v", tokens[0])
+	}
+	if tokens[1].Symbol != "TKN" || tokens[1].Name != "Token" || tokens[1].Decimal != 9 ||
+		!strings.EqualFold(tokens[1].Address, tokenAddr) {
+		t.Errorf("registry token %+v", tokens[1])
+	}
+
+	// a listed address without erc20 metadata is skipped
+	bFetcher.Simulation("registry").SetTokens([]bFetcher.SimulatedToken{{Address: otherAddr}})
+	if _, err := fetcher.GetRegistryTokens(context.Background(), registryAddr); err == nil {
+		t.Error("registry without readable token")
+	}
+}
+
+func TestSourcedTokens(t *testing.T) {
+	key := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{3}, ed25519.SeedSize))
+	// the list claim TKN at another address
+	list := strings.Replace(validList, tokenAddr, otherAddr, 1)
+	var signature atomic.Value
+	signature.Store(hex.EncodeToString(ed25519.Sign(key, []byte(list))))
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/tokens.json":
+			w.Write([]byte(list))
+		case "/tokens.json.sig":
+			w.Write([]byte(signature.Load().(string)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	sources := config.TokenSources{
+		Precedence: []string{config.TokenSourceRegistry, config.TokenSourceList},
+		List:       server.URL + "/tokens.json",
+		PublicKey:  hex.EncodeToString(key.Public().(ed25519.PublicKey)),
+		ChainID:    88,
+	}
+	fetcher := newRegistryFetcher(t, "sources", config.Chain{TokenSources: sources})
+	if err := fetcher.UpdateListToken(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	tokens := fetcher.GetListToken()
+	if len(tokens) != 3 {
+		t.Fatalf("%d tokens, want TOMO, TKN and LST: %+v", len(tokens), tokens)
+	}
+	// the list token conflicting with the registry is ignored, cg_id included
+	if token := tokens["TKN"]; !strings.EqualFold(token.Address, tokenAddr) || token.CGId != "" {
+		t.Errorf("TKN %+v, want the registry one", token)
+	}
+	if token := tokens["LST"]; token.Decimal != 6 {
+		t.Errorf("LST %+v", token)
+	}
+
+	// a list not signed by the key fail the refresh, the tokens are kept
+	otherKey := ed25519.NewKeyFromSeed(bytes.Repeat([]byte{4}, ed25519.SeedSize))
+	signature.Store(hex.EncodeToString(ed25519.Sign(otherKey, []byte(list))))
+	if err := fetcher.UpdateListToken(context.Background()); err == nil || !strings.Contains(err.Error(), "signature") {
+		t.Errorf("refresh with a bad signature: %v", err)
+	}
+	if len(fetcher.GetListToken()) != 3 {
+		t.Error("tokens changed by a failed refresh")
+	}
+}
@@ -1,13 +1,15 @@
 package fetcher
 
 import (
-	"log"
+	"fmt"
 	"math/big"
+	"reflect"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/marknguyen85/server-api/tomochain"
+	log "github.com/sirupsen/logrus"
 	"github.com/tomochain/tomochain/accounts/abi"
 	"github.com/tomochain/tomochain/common"
 	"github.com/tomochain/tomochain/common/hexutil"
@@ -23,9 +25,14 @@ type RateWrapper struct {
 	SlippageRate []*big.Int `json:"slippageRate"`
 }
 
+// tokenRegistryAbi is the registry listing the tokens of the network, and the
+// erc20 metadata of each token
+const tokenRegistryAbi = `[{"constant":true,"inputs":[],"name":"getListedTokens","outputs":[{"name":"","type":"address[]"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"name","outputs":[{"name":"","type":"string"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"symbol","outputs":[{"name":"","type":"string"}],"payable":false,"stateMutability":"view","type":"function"},{"constant":true,"inputs":[],"name":"decimals","outputs":[{"name":"","type":"uint8"}],"payable":false,"stateMutability":"view","type":"function"}]`
+
 type TomoChain struct {
 	network          string
 	networkAbi       abi.ABI
+	registryAbi      abi.ABI
 	tradeTopic       string
 	averageBlockTime int64
 }
@@ -35,12 +42,19 @@ func NewTomoChain(network string, networkAbiStr string, tradeTopic string, avera
 
 	networkAbi, err := abi.JSON(strings.NewReader(networkAbiStr))
 	if err != nil {
-		log.Print(err)
+		return nil, err
+	}
+	registryAbi, err := abi.JSON(strings.NewReader(tokenRegistryAbi))
+	if err != nil {
 		return nil, err
 	}
 
 	tomochain := &TomoChain{
-		network, networkAbi, tradeTopic, averageBlockTime,
+		network:          network,
+		networkAbi:       networkAbi,
+		registryAbi:      registryAbi,
+		tradeTopic:       tradeTopic,
+		averageBlockTime: averageBlockTime,
 	}
 
 	return tomochain, nil
@@ -53,7 +67,6 @@ func (tomoChain *TomoChain) EncodeRateData(source, dest string, quantity *big.In
 
 	encodedData, err := tomoChain.networkAbi.Pack("getExpectedRate", srcAddr, destAddr, quantity)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 
@@ -64,7 +77,6 @@ func (tomoChain *TomoChain) EncodeRateData(source, dest string, quantity *big.In
 func (tomoChain *TomoChain) EncodeChainTeXEnable() (string, error) {
 	encodedData, err := tomoChain.networkAbi.Pack("enabled")
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 	return common.Bytes2Hex(encodedData), nil
@@ -73,13 +85,11 @@ func (tomoChain *TomoChain) EncodeChainTeXEnable() (string, error) {
 func (tomoChain *TomoChain) ExtractEnabled(result string) (bool, error) {
 	enabledByte, err := hexutil.Decode(result)
 	if err != nil {
-		log.Print(err)
 		return false, err
 	}
 	var enabled bool
 	err = tomoChain.networkAbi.Unpack(&enabled, "enabled", enabledByte)
 	if err != nil {
-		log.Print(err)
 		return false, err
 	}
 	return enabled, nil
@@ -88,7 +98,6 @@ func (tomoChain *TomoChain) ExtractEnabled(result string) (bool, error) {
 func (tomoChain *TomoChain) EncodeMaxGasPrice() (string, error) {
 	encodedData, err := tomoChain.networkAbi.Pack("maxGasPrice")
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 	return common.Bytes2Hex(encodedData), nil
@@ -97,13 +106,11 @@ func (tomoChain *TomoChain) EncodeMaxGasPrice() (string, error) {
 func (tomoChain *TomoChain) ExtractMaxGasPrice(result string) (string, error) {
 	gasByte, err := hexutil.Decode(result)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 	var gasPrice *big.Int
 	err = tomoChain.networkAbi.Unpack(&gasPrice, "maxGasPrice", gasByte)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 	return gasPrice.String(), nil
@@ -115,13 +122,11 @@ func (tomoChain *TomoChain) ExtractRateData(result string, sourceSymbol, destSym
 	rateByte, err := hexutil.Decode(result)
 
 	if err != nil {
-		log.Print("**********************Decode error******************", err)
 		return rate, err
 	}
 	var rateNetwork RateNetwork
 	err = tomoChain.networkAbi.Unpack(&rateNetwork, "getExpectedRate", rateByte)
 	if err != nil {
-		log.Print("**********************Unpack getExpectedRate******************", err)
 		return rate, err
 	}
 
@@ -133,11 +138,74 @@ func (tomoChain *TomoChain) ExtractRateData(result string, sourceSymbol, destSym
 	}, nil
 }
 
+//EncodeRegistry encode a call to method, without argument, of the registry
+//or of a token
+func (tomoChain *TomoChain) EncodeRegistry(method string) (string, error) {
+	encodedData, err := tomoChain.registryAbi.Pack(method)
+	if err != nil {
+		return "", err
+	}
+	return common.Bytes2Hex(encodedData), nil
+}
+
+//ExtractListedTokens return the addresses listed by the registry
+func (tomoChain *TomoChain) ExtractListedTokens(result string) ([]string, error) {
+	data, err := hexutil.Decode(result)
+	if err != nil {
+		return nil, err
+	}
+	values, err := tomoChain.registryAbi.Methods["getListedTokens"].Outputs.UnpackValues(data)
+	if err != nil {
+		return nil, err
+	}
+	// the abi decode a slice of go-ethereum addresses, read as plain arrays
+	list := reflect.ValueOf(values[0])
+	if list.Kind() != reflect.Slice {
+		return nil, fmt.Errorf("getListedTokens: unexpected %T", values[0])
+	}
+	addressType := reflect.TypeOf([common.AddressLength]byte{})
+	addresses := make([]string, 0, list.Len())
+	for i := 0; i < list.Len(); i++ {
+		item := list.Index(i)
+		if !item.Type().ConvertibleTo(addressType) {
+			return nil, fmt.Errorf("getListedTokens: unexpected %s", item.Type())
+		}
+		address := item.Convert(addressType).Interface().([common.AddressLength]byte)
+		addresses = append(addresses, common.Address(address).Hex())
+	}
+	return addresses, nil
+}
+
+//ExtractTokenString decode the answer of name or symbol
+func (tomoChain *TomoChain) ExtractTokenString(method, result string) (string, error) {
+	data, err := hexutil.Decode(result)
+	if err != nil {
+		return "", err
+	}
+	var value string
+	if err := tomoChain.registryAbi.Unpack(&value, method, data); err != nil {
+		return "", err
+	}
+	return value, nil
+}
+
+//ExtractTokenDecimals decode the answer of decimals
+func (tomoChain *TomoChain) ExtractTokenDecimals(result string) (int, error) {
+	data, err := hexutil.Decode(result)
+	if err != nil {
+		return 0, err
+	}
+	var decimals uint8
+	if err := tomoChain.registryAbi.Unpack(&decimals, "decimals", data); err != nil {
+		return 0, err
+	}
+	return int(decimals), nil
+}
+
 func (tomoChain *TomoChain) ReadEventsWithBlockNumber(eventRaw *[]tomochain.EventRaw, latestBlock string) (*[]tomochain.EventHistory, error) {
 	//get latestBlock to calculate timestamp
 	events, err := tomoChain.ReadEvents(eventRaw, "node", latestBlock)
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 	return events, nil
@@ -147,17 +215,19 @@ func (tomoChain *TomoChain) ReadEventsWithTimeStamp(eventRaw *[]tomochain.EventR
 	//get latestBlock to calculate timestamp
 	events, err := tomoChain.ReadEvents(eventRaw, "tomoscan", "0")
 	if err != nil {
-		log.Print(err)
 		return nil, err
 	}
 	return events, nil
 }
 
+// LogData is the data of an ExecuteTrade event. The abi fill the fields named
+// as the arguments, and decode addresses to the go-ethereum address type,
+// assignable to a plain array only.
 type LogData struct {
-	Source           common.Address `json:"source"`
-	Dest             common.Address `json:"dest"`
-	ActualSrcAmount  *big.Int       `json:"actualSrcAmount"`
-	ActualDestAmount *big.Int       `json:"actualDestAmount"`
+	Src              [common.AddressLength]byte `json:"source"`
+	Dest             [common.AddressLength]byte `json:"dest"`
+	ActualSrcAmount  *big.Int                   `json:"actualSrcAmount"`
+	ActualDestAmount *big.Int                   `json:"actualDestAmount"`
 }
 
 func (tomoChain *TomoChain) ReadEvents(listEventAddr *[]tomochain.EventRaw, typeFetch string, latestBlock string) (*[]tomochain.EventHistory, error) {
@@ -170,10 +240,11 @@ func (tomoChain *TomoChain) ReadEvents(listEventAddr *[]tomochain.EventRaw, type
 		if index >= 5 {
 			break
 		}
+		eventLog := log.WithField("txhash", listEvent[i].Txhash)
 		//filter amount
 		isSmallAmount, err := tomoChain.IsSmallAmount(listEvent[i])
 		if err != nil {
-			log.Print(err)
+			eventLog.WithError(err).Warn("skip trade event")
 			continue
 		}
 		if isSmallAmount {
@@ -184,15 +255,16 @@ func (tomoChain *TomoChain) ReadEvents(listEventAddr *[]tomochain.EventRaw, type
 
 		blockNumber, err := hexutil.DecodeBig(listEvent[i].BlockNumber)
 		if err != nil {
-			log.Print(err)
+			eventLog.WithError(err).Warn("skip trade event")
 			continue
 		}
 
+		// tomoscan logs carry the time of their block, node logs do not
 		var timestamp string
-		if typeFetch == "tomoscan" {
+		if typeFetch == "tomoscan" || listEvent[i].Timestamp != "" {
 			timestampHex, err := hexutil.DecodeBig(listEvent[i].Timestamp)
 			if err != nil {
-				log.Print(err)
+				eventLog.WithError(err).Warn("skip trade event")
 				continue
 			}
 			timestamp = timestampHex.String()
@@ -200,7 +272,7 @@ func (tomoChain *TomoChain) ReadEvents(listEventAddr *[]tomochain.EventRaw, type
 		} else {
 			timestamp, err = tomoChain.Gettimestamp(blockNumber.String(), latestBlock, tomoChain.averageBlockTime)
 			if err != nil {
-				log.Print(err)
+				eventLog.WithError(err).Warn("skip trade event")
 				continue
 			}
 		}
@@ -208,20 +280,20 @@ func (tomoChain *TomoChain) ReadEvents(listEventAddr *[]tomochain.EventRaw, type
 		var logData LogData
 		data, err := hexutil.Decode(listEvent[i].Data)
 		if err != nil {
-			log.Print(err)
+			eventLog.WithError(err).Warn("skip trade event")
 			continue
 		}
 		//fmt.Print(listEvent[i].Data)
 		err = tomoChain.networkAbi.Unpack(&logData, "ExecuteTrade", data)
 		if err != nil {
-			log.Print(err)
+			eventLog.WithError(err).Warn("skip trade event")
 			continue
 		}
 
 		actualDestAmount := logData.ActualDestAmount.String()
 		actualSrcAmount := logData.ActualSrcAmount.String()
-		dest := logData.Dest.String()
-		source := logData.Source.String()
+		dest := common.Address(logData.Dest).String()
+		source := common.Address(logData.Src).String()
 
 		events = append(events, tomochain.EventHistory{
 			actualDestAmount, actualSrcAmount, dest, source, blockNumber.String(), txHash, timestamp,
@@ -234,17 +306,15 @@ func (tomoChain *TomoChain) ReadEvents(listEventAddr *[]tomochain.EventRaw, type
 func (tomoChain *TomoChain) IsSmallAmount(eventRaw tomochain.EventRaw) (bool, error) {
 	data, err := hexutil.Decode(eventRaw.Data)
 	if err != nil {
-		log.Print(err)
 		return true, err
 	}
 	var logData LogData
 	err = tomoChain.networkAbi.Unpack(&logData, "ExecuteTrade", data)
 	if err != nil {
-		log.Print(err)
 		return true, err
 	}
 
-	source := logData.Source
+	source := common.Address(logData.Src)
 	var amount *big.Int
 	if strings.ToLower(source.String()) == "0xeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee" {
 		amount = logData.ActualSrcAmount
@@ -274,12 +344,10 @@ func (tomoChain *TomoChain) IsSmallAmount(eventRaw tomochain.EventRaw) (bool, er
 func (tomoChain *TomoChain) Gettimestamp(block string, latestBlock string, averageBlockTime int64) (string, error) {
 	fromBlock, err := strconv.ParseInt(block, 10, 64)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 	toBlock, err := strconv.ParseInt(latestBlock, 10, 64)
 	if err != nil {
-		log.Print(err)
 		return "", err
 	}
 	timeNow := time.Now().Unix()
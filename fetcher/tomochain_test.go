@@ -0,0 +1,170 @@
+package fetcher
+
+import (
+	"context"
+	"math/big"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marknguyen85/server-api/common"
+	"github.com/marknguyen85/server-api/config"
+	bFetcher "github.com/marknguyen85/server-api/fetcher/blockchain-fetcher"
+	tomoCommon "github.com/tomochain/tomochain/common"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+const tokenAddr = "0x1111111111111111111111111111111111111111"
+
+var tradeTopic = crypto.Keccak256Hash([]byte("ExecuteTrade(address,address,address,uint256,uint256)")).Hex()
+
+func wei(value string) *big.Int {
+	number, ok := new(big.Int).SetString(value, 10)
+	if !ok {
+		panic("invalid number " + value)
+	}
+	return number
+}
+
+// newSimulatedFetcher return a fetcher reading the simulated network name
+// through the real contract abi
+func newSimulatedFetcher(t *testing.T, name string) (*Fetcher, *bFetcher.SimulatedNetwork) {
+	fetcher, err := NewFetcher(config.Chain{
+		Network:          "0x" + strings.Repeat("2", 40),
+		TradeTopic:       tradeTopic,
+		AverageBlockTime: 2000,
+		Connections:      []config.Connection{{Type: "simulated", Endpoint: "sim://" + name}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return fetcher, bFetcher.Simulation(name)
+}
+
+func TestSimulatedRate(t *testing.T) {
+	fetcher, network := newSimulatedFetcher(t, "rate")
+	ctx := context.Background()
+
+	before := network.SetRate(tokenAddr, common.TOMOAddr, wei("2000000000000000000"), wei("1900000000000000000"))
+	network.SetRate(tokenAddr, common.TOMOAddr, wei("3000000000000000000"), wei("2900000000000000000"))
+
+	rate, err := fetcher.queryRateBlockchain(ctx, bFetcher.LatestBlock, tokenAddr, common.TOMOAddr, "TKN", "TOMO", tokenWei(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.Source != "TKN" || rate.Dest != "TOMO" || rate.Rate != "3000000000000000000" || rate.Minrate != "2900000000000000000" {
+		t.Errorf("latest rate %+v", rate)
+	}
+
+	// a call pinned to a block read the state of that block
+	rate, err = fetcher.queryRateBlockchain(ctx, strconv.FormatUint(before, 10), tokenAddr, common.TOMOAddr, "TKN", "TOMO", tokenWei(9))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate.Rate != "2000000000000000000" {
+		t.Errorf("rate at block %d: %s", before, rate.Rate)
+	}
+
+	if _, err := fetcher.queryRateBlockchain(ctx, bFetcher.LatestBlock, common.TOMOAddr, tokenAddr, "TOMO", "TKN", tokenWei(18)); err == nil {
+		t.Error("rate of a pair without liquidity did not revert")
+	}
+	future := strconv.FormatUint(network.BlockNumber()+1, 10)
+	if _, err := fetcher.queryRateBlockchain(ctx, future, tokenAddr, common.TOMOAddr, "TKN", "TOMO", tokenWei(9)); err == nil {
+		t.Error("rate at a block not mined yet")
+	}
+}
+
+func TestSimulatedContractSettings(t *testing.T) {
+	fetcher, network := newSimulatedFetcher(t, "settings")
+	ctx := context.Background()
+
+	enabled, err := fetcher.CheckChainTeXEnable(ctx)
+	if err != nil || !enabled {
+		t.Fatalf("enabled %v, %v", enabled, err)
+	}
+	network.SetEnabled(false)
+	enabled, err = fetcher.CheckChainTeXEnable(ctx)
+	if err != nil || enabled {
+		t.Errorf("enabled %v after disabling, %v", enabled, err)
+	}
+
+	network.SetMaxGasPrice(wei("20000000000"))
+	gasPrice, err := fetcher.GetMaxGasPrice(ctx)
+	if err != nil || gasPrice != "20000000000" {
+		t.Errorf("max gas price %s, %v", gasPrice, err)
+	}
+}
+
+func TestSimulatedTradeEvents(t *testing.T) {
+	fetcher, network := newSimulatedFetcher(t, "trades")
+	ctx := context.Background()
+	trader := "0x3333333333333333333333333333333333333333"
+
+	from := network.BlockNumber() + 1
+	buy, err := network.Trade(trader, common.TOMOAddr, tokenAddr, wei("5000000000000000000"), wei("10000000000000000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// dust trades are not reported
+	if _, err := network.Trade(trader, common.TOMOAddr, tokenAddr, wei("1000"), wei("2000")); err != nil {
+		t.Fatal(err)
+	}
+	sell, err := network.Trade(trader, tokenAddr, common.TOMOAddr, wei("4000000000000000000"), wei("2000000000000000000"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := network.BlockNumber()
+
+	events, err := fetcher.GetTradeEvents(ctx, strconv.FormatUint(from, 10), strconv.FormatUint(to, 10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("%d events, want 2: %+v", len(events), events)
+	}
+	// latest first
+	if events[0].Txhash != sell || events[1].Txhash != buy {
+		t.Errorf("events %s %s, want %s %s", events[0].Txhash, events[1].Txhash, sell, buy)
+	}
+	event := events[1]
+	if !strings.EqualFold(event.Source, common.TOMOAddr) || !strings.EqualFold(event.Dest, tomoCommon.HexToAddress(tokenAddr).Hex()) {
+		t.Errorf("buy from %s to %s", event.Source, event.Dest)
+	}
+	if event.ActualSrcAmount != "5000000000000000000" || event.ActualDestAmount != "10000000000000000000" {
+		t.Errorf("buy amounts %s %s", event.ActualSrcAmount, event.ActualDestAmount)
+	}
+	if event.BlockNumber != strconv.FormatUint(from, 10) {
+		t.Errorf("buy at block %s, want %d", event.BlockNumber, from)
+	}
+	blockTime, err := network.BlockTime(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Timestamp != strconv.FormatUint(blockTime, 10) {
+		t.Errorf("buy at %s, want %d", event.Timestamp, blockTime)
+	}
+
+	events, err = fetcher.GetTradeEvents(ctx, strconv.FormatUint(to+1, 10), strconv.FormatUint(to+1, 10))
+	if err != nil || len(events) != 0 {
+		t.Errorf("events after the trades: %+v, %v", events, err)
+	}
+}
+
+func TestSimulatedNetworkDeterministic(t *testing.T) {
+	genesis := time.Unix(1546300800, 0)
+	first, second := bFetcher.NewSimulatedNetwork(genesis), bFetcher.NewSimulatedNetwork(genesis)
+	for _, network := range []*bFetcher.SimulatedNetwork{first, second} {
+		if _, err := network.Trade(tokenAddr, common.TOMOAddr, tokenAddr, wei("1"), wei("2")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	firstLogs, _ := first.Logs("0", bFetcher.LatestBlock, tradeTopic)
+	secondLogs, _ := second.Logs("0", bFetcher.LatestBlock, tradeTopic)
+	if len(firstLogs) != 1 || len(secondLogs) != 1 || firstLogs[0] != secondLogs[0] {
+		t.Errorf("logs differ: %+v %+v", firstLogs, secondLogs)
+	}
+	if blockTime, _ := first.BlockTime(2); blockTime != 1546300804 {
+		t.Errorf("block 2 at %d", blockTime)
+	}
+}
@@ -0,0 +1,202 @@
+package fetcher
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marknguyen85/server-api/common"
+	"github.com/marknguyen85/server-api/config"
+	"github.com/marknguyen85/server-api/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// errTooFewVerifiers is returned when less than two connections can be compared
+var errTooFewVerifiers = errors.New("verification need two connections")
+
+// answer of a connection to every call of a verification
+type answer struct {
+	node   *node
+	result string
+	err    error
+}
+
+// VerifyConnections run the max gas price call and the rate of up to
+// settings.Pairs random tokens on every connection, at the same block, the
+// lowest latest block among them. The connections whose answer differ from
+// the one of a strict majority are quarantined for settings.Quarantine; a
+// disagreement without majority is only reported.
+func (fetcher *Fetcher) VerifyConnections(ctx context.Context, settings config.Verification) error {
+	nodes := fetcher.getNodes()
+	if len(nodes) < 2 {
+		return errTooFewVerifiers
+	}
+
+	block, err := fetcher.commonBlock(ctx, nodes)
+	if err != nil {
+		return err
+	}
+	calls, err := fetcher.verificationCalls(settings.Pairs)
+	if err != nil {
+		return err
+	}
+
+	answers := make([]answer, len(nodes))
+	var wg sync.WaitGroup
+	for i, n := range nodes {
+		wg.Add(1)
+		go func(i int, n *node) {
+			defer wg.Done()
+			results := make([]string, len(calls))
+			for j, data := range calls {
+				result, err := n.TomoCall(ctx, fetcher.info.Network, data, block)
+				if err != nil {
+					answers[i] = answer{node: n, err: err}
+					return
+				}
+				results[j] = result
+			}
+			answers[i] = answer{node: n, result: strings.Join(results, ",")}
+		}(i, n)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	votes := make(map[string]int)
+	answered := 0
+	for _, a := range answers {
+		if a.err != nil {
+			log.WithFields(log.Fields{
+				"fetcher":  a.node.GetTypeName(),
+				"endpoint": a.node.endpoint,
+				"block":    block,
+			}).WithError(a.err).Warn("cannot verify connection")
+			continue
+		}
+		votes[a.result]++
+		answered++
+	}
+	if len(votes) <= 1 {
+		return nil
+	}
+
+	majority := ""
+	for result, count := range votes {
+		if count*2 > answered {
+			majority = result
+		}
+	}
+	for _, a := range answers {
+		if a.err != nil || a.result == majority {
+			continue
+		}
+		fetcher.disagree(a.node, block, majority != "", settings.Quarantine.Std())
+	}
+	return nil
+}
+
+// commonBlock return the lowest latest block of nodes, one all of them have
+func (fetcher *Fetcher) commonBlock(ctx context.Context, nodes []*node) (string, error) {
+	var (
+		mu     sync.Mutex
+		lowest uint64
+		found  int
+		wg     sync.WaitGroup
+	)
+	for _, n := range nodes {
+		wg.Add(1)
+		go func(n *node) {
+			defer wg.Done()
+			start := time.Now()
+			number, err := n.GetLatestBlock(ctx)
+			if ctx.Err() != nil {
+				return
+			}
+			n.record(time.Since(start), err)
+			if err != nil {
+				return
+			}
+			n.observeBlock(number)
+			block, err := strconv.ParseUint(number, 10, 64)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			if found == 0 || block < lowest {
+				lowest = block
+			}
+			found++
+			mu.Unlock()
+		}(n)
+	}
+	wg.Wait()
+	if ctx.Err() != nil {
+		return "", ctx.Err()
+	}
+	if found < 2 {
+		return "", errTooFewVerifiers
+	}
+	return strconv.FormatUint(lowest, 10), nil
+}
+
+// verificationCalls encode the max gas price call and the rate of up to
+// pairs random tokens against TOMO
+func (fetcher *Fetcher) verificationCalls(pairs int) ([]string, error) {
+	gasPrice, err := fetcher.tomochain.EncodeMaxGasPrice()
+	if err != nil {
+		return nil, err
+	}
+	calls := []string{gasPrice}
+	var tokens []string
+	listTokens := fetcher.GetListToken()
+	for symbol := range listTokens {
+		if symbol != common.TOMOSymbol {
+			tokens = append(tokens, symbol)
+		}
+	}
+	for i, index := range rand.Perm(len(tokens)) {
+		if i >= pairs {
+			break
+		}
+		token := listTokens[tokens[index]]
+		data, err := fetcher.tomochain.EncodeRateData(token.Address, common.TOMOAddr, tokenWei(token.Decimal/2))
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, data)
+	}
+	return calls, nil
+}
+
+// disagree report a connection answering unlike the others at block, and
+// quarantine it when the others agree
+func (fetcher *Fetcher) disagree(n *node, block string, quarantine bool, duration time.Duration) {
+	metrics.ObserveMismatch(n.GetTypeName())
+	n.mu.Lock()
+	n.mismatches++
+	if quarantine {
+		n.quarantinedUntil = time.Now().Add(duration)
+	}
+	until := n.quarantinedUntil
+	n.mu.Unlock()
+
+	entry := log.WithFields(log.Fields{
+		"alert":    "connection_mismatch",
+		"network":  fetcher.info.Network,
+		"fetcher":  n.GetTypeName(),
+		"endpoint": n.endpoint,
+		"block":    block,
+	})
+	if !quarantine {
+		entry.Error("connections disagree without majority, none quarantined")
+		return
+	}
+	entry.WithField("quarantined_until", until.UTC().Format(time.RFC3339)).
+		Error("connection disagree with the majority, quarantined")
+}
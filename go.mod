@@ -3,15 +3,21 @@ module github.com/marknguyen85/server-api
 go 1.12
 
 require (
+	github.com/andybalholm/brotli v1.0.2
 	github.com/boltdb/bolt v1.3.1
 	github.com/ethereum/go-ethereum v1.8.27 // indirect
 	github.com/getsentry/raven-go v0.2.0
 	github.com/gin-contrib/cors v0.0.0-20190301062745-f9e10995c85a
 	github.com/gin-contrib/sentry v0.0.0-20190301062850-d4eec0a60d7d
 	github.com/gin-gonic/gin v1.3.0
+	github.com/go-redis/redis v6.14.1+incompatible
 	github.com/go-stack/stack v1.8.0 // indirect
+	github.com/prometheus/client_golang v0.9.3
 	github.com/rs/cors v1.6.0 // indirect
+	github.com/sirupsen/logrus v1.4.1
 	github.com/tomochain/tomochain v1.3.2
-	golang.org/x/crypto v0.0.0-20190418165655-df01cb2cc480 // indirect
+	golang.org/x/crypto v0.0.0-20190418165655-df01cb2cc480
+	golang.org/x/net v0.0.0-20181220203305-927f97764cc3
 	gopkg.in/fatih/set.v0 v0.2.1 // indirect
+	gopkg.in/natefinch/lumberjack.v2 v2.0.0
 )
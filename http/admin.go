@@ -0,0 +1,261 @@
+package http
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/marknguyen85/server-api/fetcher"
+	"github.com/marknguyen85/server-api/logger"
+	"github.com/marknguyen85/server-api/tomochain"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	signatureHeader = "X-Signature"
+	timestampHeader = "X-Timestamp"
+	// signed requests older than this are rejected
+	signatureMaxSkew = 5 * time.Minute
+	// maxAdminBody is the most of a request body read to check its signature
+	maxAdminBody = 1 << 20
+
+	defaultLogLimit = 200
+	maxLogLimit     = 2000
+)
+
+// adminSignature sign timestamp, method, uri (path and query) and, but for
+// GET, the hex sha256 of body with the shared secret
+func adminSignature(secret, timestamp, method, uri string, body []byte) string {
+	message := timestamp + method + uri
+	if method != http.MethodGet {
+		sum := sha256.Sum256(body)
+		message += hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(message))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// usedSignatures remember the signatures accepted within the skew window, so
+// a captured request cannot be sent again
+type usedSignatures struct {
+	mu      sync.Mutex
+	expires map[string]time.Time
+}
+
+// use record signature, valid until expires, and tell whether it was unused
+func (used *usedSignatures) use(signature string, expires, now time.Time) bool {
+	used.mu.Lock()
+	defer used.mu.Unlock()
+	for sig, at := range used.expires {
+		if now.After(at) {
+			delete(used.expires, sig)
+		}
+	}
+	if _, ok := used.expires[signature]; ok {
+		return false
+	}
+	used.expires[signature] = expires
+	return true
+}
+
+// adminAuth accept either "Authorization: Bearer <admin token>" or
+// X-Timestamp with X-Signature, see adminSignature. A signature is accepted
+// once. An empty token or secret refuse that way.
+func adminAuth(token, secret string) gin.HandlerFunc {
+	used := &usedSignatures{expires: make(map[string]time.Time)}
+	return func(c *gin.Context) {
+		auth := c.GetHeader("Authorization")
+		if token != "" && strings.HasPrefix(auth, "Bearer ") {
+			given := strings.TrimPrefix(auth, "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1 {
+				c.Next()
+				return
+			}
+		}
+
+		timestamp := c.GetHeader(timestampHeader)
+		signature := c.GetHeader(signatureHeader)
+		if secret != "" && timestamp != "" && signature != "" {
+			unix, err := strconv.ParseInt(timestamp, 10, 64)
+			now := time.Now()
+			skew := now.Sub(time.Unix(unix, 0))
+			if err == nil && skew < signatureMaxSkew && skew > -signatureMaxSkew {
+				body, err := readBody(c)
+				if err == errBodyTooLarge {
+					c.AbortWithStatusJSON(
+						http.StatusRequestEntityTooLarge,
+						gin.H{"success": false, "data": err.Error()},
+					)
+					return
+				}
+				expected := adminSignature(secret, timestamp, c.Request.Method, c.Request.URL.RequestURI(), body)
+				if err == nil && hmac.Equal([]byte(signature), []byte(expected)) {
+					if used.use(signature, time.Unix(unix, 0).Add(signatureMaxSkew), now) {
+						c.Next()
+						return
+					}
+					requestLog(c).WithField("endpoint", c.Request.URL.Path).Warn("admin signature replayed")
+				}
+			}
+		}
+
+		requestLog(c).WithField("endpoint", c.Request.URL.Path).Warn("unauthorized admin request")
+		c.AbortWithStatusJSON(
+			http.StatusUnauthorized,
+			gin.H{"success": false, "data": nil},
+		)
+	}
+}
+
+// errBodyTooLarge is returned for an admin body over maxAdminBody
+var errBodyTooLarge = errors.New("request body too large")
+
+// readBody read the request body and put it back for the handler, a body
+// over maxAdminBody is refused rather than signed and passed on truncated
+func readBody(c *gin.Context) ([]byte, error) {
+	if c.Request.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(io.LimitReader(c.Request.Body, maxAdminBody+1))
+	c.Request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > maxAdminBody {
+		return nil, errBodyTooLarge
+	}
+	c.Request.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// getLogs return recent log entries, filtered by minimum level and substring
+func (httpServer *HTTPServer) getLogs(c *gin.Context) {
+	level := log.DebugLevel
+	if value := c.Query("level"); value != "" {
+		parsed, err := log.ParseLevel(value)
+		if err != nil {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{"success": false, "data": err.Error()},
+			)
+			return
+		}
+		level = parsed
+	}
+	limit := defaultLogLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(
+				http.StatusBadRequest,
+				gin.H{"success": false, "data": "invalid limit"},
+			)
+			return
+		}
+		limit = parsed
+	}
+	if limit > maxLogLimit {
+		limit = maxLogLimit
+	}
+	entries := logger.Recent.Tail(level, c.Query("q"), limit)
+	c.JSON(
+		http.StatusOK,
+		gin.H{"success": true, "data": entries},
+	)
+}
+
+// getScheduler return state of every fetch job
+func (httpServer *HTTPServer) getScheduler(c *gin.Context) {
+	c.JSON(
+		http.StatusOK,
+		gin.H{"success": true, "data": httpServer.scheduler.States()},
+	)
+}
+
+// getConnections return the health of the connections of every network, in
+// the order they are called
+func (httpServer *HTTPServer) getConnections(c *gin.Context) {
+	connections := make(map[string][]fetcher.ConnectionHealth, len(httpServer.networks))
+	for name, network := range httpServer.networks {
+		connections[name] = network.Fetcher.Connections()
+	}
+	c.JSON(
+		http.StatusOK,
+		gin.H{"success": true, "data": connections},
+	)
+}
+
+// debugRate read again the rates of a network at a past block, to check a
+// reported rate. The nodes must keep the state of that block.
+func (httpServer *HTTPServer) debugRate(c *gin.Context) {
+	block := c.Query("block")
+	if _, ok := new(big.Int).SetString(block, 10); !ok {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"success": false, "data": "block must be a decimal number"},
+		)
+		return
+	}
+	name := c.DefaultQuery("network", httpServer.defaultNetwork)
+	network, ok := httpServer.networks[name]
+	if !ok {
+		c.JSON(
+			http.StatusBadRequest,
+			gin.H{"success": false, "data": "unknown network " + name},
+		)
+		return
+	}
+
+	tokens := network.Fetcher.GetListToken()
+	if symbols := c.Query("tokens"); symbols != "" {
+		selected := make(map[string]tomochain.Token)
+		for _, symbol := range strings.Split(symbols, ",") {
+			if token, ok := tokens[strings.TrimSpace(symbol)]; ok {
+				selected[token.Symbol] = token
+			}
+		}
+		tokens = selected
+	}
+	rates, err := network.Fetcher.GetRate(c.Request.Context(), block, nil, false, tokens, false)
+	if err != nil {
+		requestLog(c).WithError(err).WithField("block", block).Warn("cannot read rates at block")
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"success": false, "data": err.Error()},
+		)
+		return
+	}
+	c.JSON(
+		http.StatusOK,
+		gin.H{"success": true, "block": block, "data": rates},
+	)
+}
+
+// reload re-read the config file and apply connections and backup tokens
+func (httpServer *HTTPServer) reload(c *gin.Context) {
+	if err := httpServer.reloader.Reload(); err != nil {
+		requestLog(c).WithError(err).Error("cannot reload config")
+		c.JSON(
+			http.StatusInternalServerError,
+			gin.H{"success": false, "data": err.Error()},
+		)
+		return
+	}
+	c.JSON(
+		http.StatusOK,
+		gin.H{"success": true},
+	)
+}
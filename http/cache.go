@@ -0,0 +1,297 @@
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"container/list"
+	"fmt"
+	"hash/fnv"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+)
+
+// maxRenderedBodies bound the cache, the least recently used body is evicted
+const maxRenderedBodies = 1024
+
+// rendered is a response body rendered once per version of its dataset, with
+// its compressed forms
+type rendered struct {
+	etag        string
+	contentType string
+	identity    []byte
+	gzip        []byte
+	brotli      []byte
+}
+
+// renderCache hold the last rendered body of each url, from the most
+// recently used
+type renderCache struct {
+	mu     sync.Mutex
+	order  *list.List
+	bodies map[string]*list.Element
+}
+
+// renderEntry is an element of renderCache.order
+type renderEntry struct {
+	key  string
+	body *rendered
+}
+
+func newRenderCache() *renderCache {
+	return &renderCache{order: list.New(), bodies: make(map[string]*list.Element)}
+}
+
+func (cache *renderCache) get(key, etag string) *rendered {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	element, ok := cache.bodies[key]
+	if !ok {
+		return nil
+	}
+	cache.order.MoveToFront(element)
+	body := element.Value.(*renderEntry).body
+	if body.etag != etag {
+		return nil
+	}
+	return body
+}
+
+func (cache *renderCache) set(key string, body *rendered) {
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+	if element, ok := cache.bodies[key]; ok {
+		element.Value.(*renderEntry).body = body
+		cache.order.MoveToFront(element)
+		return
+	}
+	if cache.order.Len() >= maxRenderedBodies {
+		oldest := cache.order.Back()
+		cache.order.Remove(oldest)
+		delete(cache.bodies, oldest.Value.(*renderEntry).key)
+	}
+	cache.bodies[key] = cache.order.PushFront(&renderEntry{key: key, body: body})
+}
+
+// bufferWriter keep the body written by a handler instead of sending it, gin
+// record the status on the underlying writer
+type bufferWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (writer *bufferWriter) WriteHeaderNow() {}
+
+func (writer *bufferWriter) Write(data []byte) (int, error) {
+	return writer.body.Write(data)
+}
+
+func (writer *bufferWriter) WriteString(s string) (int, error) {
+	return writer.body.WriteString(s)
+}
+
+func (writer *bufferWriter) Size() int {
+	return writer.body.Len()
+}
+
+func (writer *bufferWriter) Written() bool {
+	return writer.body.Len() > 0
+}
+
+// datasetVersion return the entity tag and modification time of the response
+// of url for dataset. The tag also change when the dataset turn stale, which
+// happens max data age after its update without a save, and on restart as
+// versions restart from zero.
+func (httpServer *HTTPServer) datasetVersion(network *Network, dataset, url string) (string, time.Time) {
+	if dataset == "" {
+		return fmt.Sprintf(`"%x"`, hashOf(network.Name, url, httpServer.startedAt)),
+			time.Unix(httpServer.startedAt, 0).UTC()
+	}
+	version := network.Persister.GetVersion(dataset)
+	modTime := version.ModTime
+	age := httpServer.datasetAges(network)[dataset]
+	if age.Stale {
+		since := age.UpdatedAt
+		if since == 0 {
+			since = httpServer.startedAt
+		}
+		if staleAt := time.Unix(since, 0).Add(httpServer.maxDataAge); staleAt.After(modTime) {
+			modTime = staleAt
+		}
+	}
+	if modTime.IsZero() {
+		modTime = time.Unix(httpServer.startedAt, 0)
+	}
+	return fmt.Sprintf(`"%x"`, hashOf(network.Name, url, version.Seq, age.Stale, httpServer.startedAt)), modTime.UTC()
+}
+
+func hashOf(values ...interface{}) uint64 {
+	h := fnv.New64a()
+	fmt.Fprint(h, values...)
+	return h.Sum64()
+}
+
+// cacheKey return the url of the request with only the query parameters of
+// rt, sorted, and listToken reduced to the symbols of the token list. The
+// response only depend on them; other query strings would grow the cache.
+func cacheKey(c *gin.Context, network *Network, rt route) string {
+	query := c.Request.URL.Query()
+	params := url.Values{}
+	for _, param := range rt.Query {
+		value := query.Get(param.Name)
+		if value == "" {
+			continue
+		}
+		if param.Name == "listToken" {
+			value = knownSymbols(network, value)
+		}
+		params.Set(param.Name, value)
+	}
+	if len(params) == 0 {
+		return c.Request.URL.Path
+	}
+	return c.Request.URL.Path + "?" + params.Encode()
+}
+
+// knownSymbols return the symbols of listToken in the token list of network,
+// sorted and without duplicates
+func knownSymbols(network *Network, listToken string) string {
+	if network.Fetcher == nil {
+		return listToken
+	}
+	tokens := network.Fetcher.GetListToken()
+	seen := make(map[string]bool)
+	var symbols []string
+	for _, symbol := range strings.Split(listToken, "-") {
+		if _, ok := tokens[symbol]; ok && !seen[symbol] {
+			seen[symbol] = true
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Strings(symbols)
+	return strings.Join(symbols, "-")
+}
+
+// encodedTag return the entity tag of the body sent with encoding, each
+// encoding of a version is a different entity
+func encodedTag(etag, encoding string) string {
+	switch encoding {
+	case "br":
+		return strings.TrimSuffix(etag, `"`) + `-br"`
+	case "gzip":
+		return strings.TrimSuffix(etag, `"`) + `-gz"`
+	}
+	return etag
+}
+
+// notModified tell whether the client copy of the response is current
+func notModified(c *gin.Context, etag string, modTime time.Time) bool {
+	if match := c.GetHeader("If-None-Match"); match != "" {
+		for _, tag := range strings.Split(match, ",") {
+			tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+			if tag == etag || tag == "*" {
+				return true
+			}
+		}
+		return false
+	}
+	since, err := http.ParseTime(c.GetHeader("If-Modified-Since"))
+	return err == nil && !modTime.Truncate(time.Second).After(since)
+}
+
+// cached serve handler from bodies of rt rendered once per version of its
+// dataset, compressed with gzip and brotli, answering conditional requests
+// with 304. Responses other than 200 are sent as is.
+func (httpServer *HTTPServer) cached(rt route, handler gin.HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		network := httpServer.network(c)
+		uri := cacheKey(c, network, rt)
+		etag, modTime := httpServer.datasetVersion(network, rt.Dataset, uri)
+		encoding := acceptedEncoding(c.GetHeader("Accept-Encoding"))
+
+		header := c.Writer.Header()
+		header.Set("Vary", "Accept-Encoding")
+		header.Set("Cache-Control", "no-cache")
+		if notModified(c, encodedTag(etag, encoding), modTime) {
+			header.Set("ETag", encodedTag(etag, encoding))
+			header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+			c.Status(http.StatusNotModified)
+			return
+		}
+
+		key := network.Name + " " + uri
+		body := httpServer.rendered.get(key, etag)
+		if body == nil {
+			writer := c.Writer
+			buffer := &bufferWriter{ResponseWriter: writer}
+			c.Writer = buffer
+			handler(c)
+			c.Writer = writer
+			if writer.Status() != http.StatusOK {
+				writer.Write(buffer.body.Bytes())
+				return
+			}
+			body = render(etag, header.Get("Content-Type"), buffer.body.Bytes())
+			httpServer.rendered.set(key, body)
+		}
+
+		header.Set("ETag", encodedTag(etag, encoding))
+		header.Set("Last-Modified", modTime.Format(http.TimeFormat))
+		header.Set("Content-Type", body.contentType)
+		data := body.identity
+		switch encoding {
+		case "br":
+			header.Set("Content-Encoding", "br")
+			data = body.brotli
+		case "gzip":
+			header.Set("Content-Encoding", "gzip")
+			data = body.gzip
+		}
+		c.Data(http.StatusOK, body.contentType, data)
+	}
+}
+
+// render compress a response body
+func render(etag, contentType string, identity []byte) *rendered {
+	var gzipped, brotlied bytes.Buffer
+	gzipWriter, _ := gzip.NewWriterLevel(&gzipped, gzip.DefaultCompression)
+	gzipWriter.Write(identity)
+	gzipWriter.Close()
+	brotliWriter := brotli.NewWriterLevel(&brotlied, brotli.DefaultCompression)
+	brotliWriter.Write(identity)
+	brotliWriter.Close()
+	return &rendered{
+		etag:        etag,
+		contentType: contentType,
+		identity:    identity,
+		gzip:        gzipped.Bytes(),
+		brotli:      brotlied.Bytes(),
+	}
+}
+
+// acceptedEncoding pick brotli, then gzip, among the encodings the client accept
+func acceptedEncoding(accept string) string {
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		refused := false
+		for _, param := range fields[1:] {
+			param = strings.Replace(param, " ", "", -1)
+			refused = refused || param == "q=0" || param == "q=0.0" || param == "q=0.00" || param == "q=0.000"
+		}
+		accepted[name] = !refused
+	}
+	for _, encoding := range []string{"br", "gzip"} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRenderCacheEvictLeastRecentlyUsed(t *testing.T) {
+	cache := newRenderCache()
+	for i := 0; i < maxRenderedBodies; i++ {
+		cache.set(fmt.Sprint(i), &rendered{etag: "v"})
+	}
+	// the first one is used again, the second becomes the oldest
+	if cache.get("0", "v") == nil {
+		t.Fatal("body 0 not cached")
+	}
+	cache.set("new", &rendered{etag: "v"})
+	if cache.get("1", "v") != nil {
+		t.Error("least recently used body kept")
+	}
+	for _, key := range []string{"0", "2", "new"} {
+		if cache.get(key, "v") == nil {
+			t.Errorf("body %s evicted", key)
+		}
+	}
+	if cache.order.Len() != maxRenderedBodies || len(cache.bodies) != maxRenderedBodies {
+		t.Errorf("%d bodies cached, want %d", len(cache.bodies), maxRenderedBodies)
+	}
+}
+
+func TestCacheKey(t *testing.T) {
+	rt := route{Query: []queryParam{{Name: "since"}, {Name: "limit"}}}
+	request := func(uri string) *gin.Context {
+		c, _ := gin.CreateTestContext(httptest.NewRecorder())
+		c.Request = httptest.NewRequest("GET", uri, nil)
+		return c
+	}
+	network := &Network{Name: "testnet"}
+	want := "/tokens/changes?limit=10&since=5"
+	for _, uri := range []string{
+		"/tokens/changes?since=5&limit=10",
+		"/tokens/changes?limit=10&since=5&apiKey=secret&x=1",
+	} {
+		if key := cacheKey(request(uri), network, rt); key != want {
+			t.Errorf("key of %s: %s, want %s", uri, key, want)
+		}
+	}
+	if key := cacheKey(request("/tokens/changes?x=1"), network, rt); key != "/tokens/changes" {
+		t.Errorf("key without parameters: %s", key)
+	}
+}
+
+func TestEncodedTagNotModified(t *testing.T) {
+	etag := `"abc"`
+	for _, encoding := range []string{"", "gzip", "br"} {
+		for _, other := range []string{"", "gzip", "br"} {
+			c, _ := gin.CreateTestContext(httptest.NewRecorder())
+			c.Request = httptest.NewRequest("GET", "/", nil)
+			c.Request.Header.Set("If-None-Match", encodedTag(etag, other))
+			if got := notModified(c, encodedTag(etag, encoding), time.Time{}); got != (encoding == other) {
+				t.Errorf("tag of %q sent for %q: not modified %v", other, encoding, got)
+			}
+		}
+	}
+}
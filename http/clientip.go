@@ -0,0 +1,63 @@
+package http
+
+import (
+	"net"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// clientIPKey hold the address of the client, see resolveClientIP
+const clientIPKey = "clientIP"
+
+// TrustProxies believe the X-Forwarded-For of requests from proxies, the
+// proxies in front of the server. Without any the client is the peer.
+func (httpServer *HTTPServer) TrustProxies(proxies []*net.IPNet) {
+	httpServer.proxies = proxies
+}
+
+func (httpServer *HTTPServer) trusted(ip net.IP) bool {
+	for _, proxy := range httpServer.proxies {
+		if proxy.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveClientIP find the address of the client: the peer or, when the peer
+// is a trusted proxy, the right-most X-Forwarded-For hop not trusted. The
+// hops left of it are written by the client and ignored.
+func (httpServer *HTTPServer) resolveClientIP() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		host, _, err := net.SplitHostPort(strings.TrimSpace(c.Request.RemoteAddr))
+		if err != nil {
+			host = c.Request.RemoteAddr
+		}
+		client := host
+		ip := net.ParseIP(host)
+		if ip != nil && httpServer.trusted(ip) {
+			hops := strings.Split(strings.Join(c.Request.Header["X-Forwarded-For"], ","), ",")
+			for i := len(hops) - 1; i >= 0; i-- {
+				hop := net.ParseIP(strings.TrimSpace(hops[i]))
+				if hop == nil {
+					break
+				}
+				client = hop.String()
+				if !httpServer.trusted(hop) {
+					break
+				}
+			}
+		}
+		c.Set(clientIPKey, client)
+		c.Next()
+	}
+}
+
+// clientIP return the address of the client of the request
+func clientIP(c *gin.Context) string {
+	if ip := c.GetString(clientIPKey); ip != "" {
+		return ip
+	}
+	return c.ClientIP()
+}
@@ -0,0 +1,75 @@
+package http
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DatasetAge freshness of one cached dataset
+type DatasetAge struct {
+	UpdatedAt int64 `json:"updatedAt"`
+	Age       int64 `json:"age"`
+	Stale     bool  `json:"stale"`
+}
+
+// datasetAges return freshness of every dataset cached for network,
+// a dataset never updated is aged from the server start
+func (httpServer *HTTPServer) datasetAges(network *Network) map[string]DatasetAge {
+	now := time.Now().UTC().Unix()
+	updatedAts := map[string]int64{
+		"rate":       network.Persister.GetTimeUpdateRate(),
+		"rateUSD":    network.Persister.GetTimeUpdateRateUSD(),
+		"marketInfo": network.Persister.GetTimeUpdateMarketInfo(),
+		"listToken":  network.Fetcher.GetTimeUpdateListToken(),
+	}
+	maxAge := int64(httpServer.maxDataAge / time.Second)
+	result := make(map[string]DatasetAge, len(updatedAts))
+	for name, updatedAt := range updatedAts {
+		since := updatedAt
+		if since == 0 {
+			since = httpServer.startedAt
+		}
+		age := now - since
+		result[name] = DatasetAge{
+			UpdatedAt: updatedAt,
+			Age:       age,
+			Stale:     age > maxAge,
+		}
+	}
+	return result
+}
+
+// getHealthz report the process is alive
+func (httpServer *HTTPServer) getHealthz(c *gin.Context) {
+	c.JSON(
+		http.StatusOK,
+		gin.H{"success": true},
+	)
+}
+
+// getReadyz report every network has fetched rates once and no dataset is stale
+func (httpServer *HTTPServer) getReadyz(c *gin.Context) {
+	ready := true
+	datasets := make(map[string]map[string]DatasetAge, len(httpServer.networks))
+	for name, network := range httpServer.networks {
+		datasets[name] = httpServer.datasetAges(network)
+		if network.Persister.GetTimeUpdateRate() == 0 {
+			ready = false
+		}
+		for _, d := range datasets[name] {
+			if d.Stale {
+				ready = false
+			}
+		}
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(
+		status,
+		gin.H{"success": ready, "data": datasets},
+	)
+}
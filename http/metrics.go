@@ -0,0 +1,75 @@
+package http
+
+import (
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/marknguyen85/server-api/metrics"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// instrument record count and latency of requests served by route
+func instrument(route string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		metrics.ObserveHTTP(route, c.Request.Method, c.Writer.Status(), start)
+	}
+}
+
+// get register a GET route with request metrics labelled by its path
+func (httpServer *HTTPServer) get(route string, handlers ...gin.HandlerFunc) {
+	httpServer.r.GET(route, append([]gin.HandlerFunc{instrument(route)}, handlers...)...)
+}
+
+var (
+	tokensDesc = prometheus.NewDesc(
+		"chaintex_cache_tokens",
+		"Number of tokens in the current token list.",
+		[]string{"network"}, nil,
+	)
+	zeroRatesDesc = prometheus.NewDesc(
+		"chaintex_cache_zero_rates",
+		"Number of cached rates with zero value.",
+		[]string{"network"}, nil,
+	)
+	datasetAgeDesc = prometheus.NewDesc(
+		"chaintex_cache_dataset_age_seconds",
+		"Seconds since the dataset was last updated.",
+		[]string{"network", "dataset"}, nil,
+	)
+)
+
+// cacheCollector expose cache state computed at scrape time
+type cacheCollector struct {
+	httpServer *HTTPServer
+}
+
+// Describe implement prometheus.Collector
+func (collector cacheCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- tokensDesc
+	ch <- zeroRatesDesc
+	ch <- datasetAgeDesc
+}
+
+// Collect implement prometheus.Collector
+func (collector cacheCollector) Collect(ch chan<- prometheus.Metric) {
+	httpServer := collector.httpServer
+	for name, network := range httpServer.networks {
+		ch <- prometheus.MustNewConstMetric(tokensDesc, prometheus.GaugeValue,
+			float64(network.Fetcher.GetNumTokens()), name)
+
+		zeroRates := 0
+		for _, rate := range network.Persister.GetRate() {
+			if rate.Rate == "" || rate.Rate == "0" {
+				zeroRates++
+			}
+		}
+		ch <- prometheus.MustNewConstMetric(zeroRatesDesc, prometheus.GaugeValue, float64(zeroRates), name)
+
+		for dataset, age := range httpServer.datasetAges(network) {
+			ch <- prometheus.MustNewConstMetric(datasetAgeDesc, prometheus.GaugeValue,
+				float64(age.Age), name, dataset)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/marknguyen85/server-api/logger"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	requestIDKey    = "requestID"
+)
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b)
+}
+
+// requestID reuse the X-Request-ID sent by the proxy or generate one,
+// and echo it in the response
+func requestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(requestIDHeader)
+		if id == "" || len(id) > 64 {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(requestIDHeader, id)
+		c.Next()
+	}
+}
+
+// requestLog return a logger tagged with the request id
+func requestLog(c *gin.Context) *log.Entry {
+	return log.WithField("request_id", c.GetString(requestIDKey))
+}
+
+// accessLog log every request once it is served
+func accessLog() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+		entry := logger.Access(requestLog(c)).WithFields(log.Fields{
+			"endpoint":  c.Request.URL.Path,
+			"method":    c.Request.Method,
+			"status":    c.Writer.Status(),
+			"latency":   time.Since(start).Seconds(),
+			"client_ip": clientIP(c),
+		})
+		if len(c.Errors) > 0 {
+			entry = entry.WithField("errors", c.Errors.String())
+		}
+		switch {
+		case c.Writer.Status() >= 500:
+			entry.Error("request served")
+		case c.Writer.Status() >= 400:
+			entry.Warn("request served")
+		default:
+			entry.Info("request served")
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package http
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/marknguyen85/server-api/fetcher"
+	persister "github.com/marknguyen85/server-api/persister"
+)
+
+const networkKey = "network"
+
+// Network is the cache of one configured chain
+type Network struct {
+	Name      string
+	Fetcher   *fetcher.Fetcher
+	Persister persister.Persister
+	// Bolt keep the token list changes, nil without a database
+	Bolt persister.BoltInterface
+}
+
+// withNetwork make the handlers of a /v2/<network> group serve network
+func withNetwork(network *Network) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Set(networkKey, network)
+		c.Next()
+	}
+}
+
+// network return the network of the request, the default one for legacy routes
+func (httpServer *HTTPServer) network(c *gin.Context) *Network {
+	if value, ok := c.Get(networkKey); ok {
+		return value.(*Network)
+	}
+	return httpServer.networks[httpServer.defaultNetwork]
+}
@@ -0,0 +1,52 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/marknguyen85/server-api/ratelimit"
+)
+
+// route groups sharing a rate limit
+const (
+	groupData    = "data"
+	groupHistory = "history"
+	groupStream  = "stream"
+)
+
+// apiKeyHeader carry the api key, the apiKey query parameter is also accepted
+const apiKeyHeader = "X-API-Key"
+
+// rateLimit throttle the requests of group: 401 for an unknown api key, 429
+// with Retry-After once the bucket of the client is empty
+func (httpServer *HTTPServer) rateLimit(group string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		apiKey := c.GetHeader(apiKeyHeader)
+		if apiKey == "" {
+			apiKey = c.Query("apiKey")
+		}
+		decision, err := httpServer.limits.Allow(group, apiKey, clientIP(c))
+		if err == ratelimit.ErrUnknownKey {
+			respondError(c, newAPIError(errUnauthorized, err.Error()))
+			c.Abort()
+			return
+		}
+		if err != nil {
+			requestLog(c).WithError(err).Warn("rate limiter unavailable, request allowed")
+		}
+		if decision.Limit.Burst > 0 {
+			c.Header("X-RateLimit-Limit", strconv.FormatFloat(decision.Limit.Rate, 'f', -1, 64))
+		}
+		if !decision.Allowed {
+			retryAfter := int(math.Ceil(decision.RetryAfter.Seconds()))
+			if retryAfter < 1 {
+				retryAfter = 1
+			}
+			c.Header("Retry-After", strconv.Itoa(retryAfter))
+			respondError(c, newAPIError(errRateLimited, fmt.Sprintf("too many requests, retry in %ds", retryAfter)))
+			c.Abort()
+		}
+	}
+}
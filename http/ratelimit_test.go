@@ -0,0 +1,81 @@
+package http
+
+import (
+	"fmt"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/marknguyen85/server-api/config"
+	"github.com/marknguyen85/server-api/ratelimit"
+)
+
+// limitedServer serve /limited in the data group, 2 requests per client
+func limitedServer(t *testing.T, proxies ...string) *HTTPServer {
+	cfg := config.RateLimit{
+		Store:          "memory",
+		Groups:         map[string]config.Limit{groupData: {Rate: 0.001, Burst: 2}},
+		TrustedProxies: proxies,
+	}
+	limits, err := ratelimit.New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nets, err := cfg.Proxies()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewHTTPServer("", nil, "", nil, 0, "", "", nil, nil, limits, config.Default().CORS)
+	server.TrustProxies(nets)
+	server.r.GET("/limited", server.rateLimit(groupData), func(c *gin.Context) {
+		c.String(nethttp.StatusOK, clientIP(c))
+	})
+	return server
+}
+
+func limitedGet(server *HTTPServer, remoteAddr, forwardedFor string) int {
+	request := httptest.NewRequest("GET", "/limited", nil)
+	request.RemoteAddr = remoteAddr
+	if forwardedFor != "" {
+		request.Header.Set("X-Forwarded-For", forwardedFor)
+		request.Header.Set("X-Real-Ip", forwardedFor)
+	}
+	recorder := httptest.NewRecorder()
+	server.r.ServeHTTP(recorder, request)
+	return recorder.Code
+}
+
+func TestRateLimitSpoofedForwardedFor(t *testing.T) {
+	// without trusted proxy the header is ignored
+	server := limitedServer(t)
+	for i := 0; i < 4; i++ {
+		want := nethttp.StatusOK
+		if i >= 2 {
+			want = nethttp.StatusTooManyRequests
+		}
+		if code := limitedGet(server, "192.0.2.1:1234", fmt.Sprintf("198.51.100.%d", i)); code != want {
+			t.Errorf("request %d with a spoofed X-Forwarded-For: status %d, want %d", i, code, want)
+		}
+	}
+
+	// behind a proxy the client is the hop the proxy appended, whatever the
+	// client wrote before it
+	server = limitedServer(t, "10.0.0.0/8")
+	for i := 0; i < 4; i++ {
+		code := limitedGet(server, "10.0.0.1:1234", fmt.Sprintf("198.51.100.%d, 203.0.113.7", i))
+		if i >= 2 && code != nethttp.StatusTooManyRequests {
+			t.Errorf("request %d through the proxy with a spoofed hop: status %d, want 429", i, code)
+		}
+	}
+	if code := limitedGet(server, "10.0.0.1:1234", "203.0.113.8"); code != nethttp.StatusOK {
+		t.Errorf("another client through the proxy: status %d", code)
+	}
+	// a client not behind the proxy cannot claim to be one
+	for i := 0; i < 3; i++ {
+		limitedGet(server, "192.0.2.2:1234", fmt.Sprintf("203.0.113.%d", 20+i))
+	}
+	if code := limitedGet(server, "192.0.2.2:1234", "203.0.113.30"); code != nethttp.StatusTooManyRequests {
+		t.Errorf("direct client with X-Forwarded-For: status %d, want 429", code)
+	}
+}
@@ -0,0 +1,321 @@
+package http
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	persister "github.com/marknguyen85/server-api/persister"
+	"github.com/marknguyen85/server-api/stream"
+	"github.com/marknguyen85/server-api/tomochain"
+)
+
+const (
+	v2Prefix    = "/v2"
+	openAPIPath = v2Prefix + "/openapi.json"
+	apiVersion  = "2.0.0"
+)
+
+// schema is a JSON schema object of the OpenAPI document
+type schema map[string]interface{}
+
+// queryParam is a query string parameter of a route
+type queryParam struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// route is a dataset served with the envelope at /v2/<network><Path> and,
+// in the legacy format for the default network, at each of its Aliases.
+// Responses are cached and tagged by the version of the persisted Dataset.
+type route struct {
+	Path    string
+	Aliases []string
+	Summary string
+	Query   []queryParam
+	Data    schema
+	Dataset string
+	// Group share its rate limit
+	Group string
+
+	legacy gin.HandlerFunc
+	v2     v2Handler
+}
+
+// routes is the table every data route is registered and documented from
+func (httpServer *HTTPServer) routes() []route {
+	return []route{
+		{
+			Path:    "/rate",
+			Group:   groupData,
+			Dataset: persister.DatasetRate,
+			Aliases: []string{"/getRate", "/rate"},
+			Summary: "Rates between TOMO and every token",
+			Data: schema{
+				"type": "array",
+				"items": schema{
+					"type": "object",
+					"properties": schema{
+						"source":  schema{"type": "string"},
+						"dest":    schema{"type": "string"},
+						"rate":    schema{"type": "string"},
+						"minRate": schema{"type": "string"},
+					},
+				},
+			},
+			legacy: httpServer.GetRate,
+			v2:     httpServer.v2Rate,
+		},
+		{
+			Path:    "/rateUSD",
+			Group:   groupData,
+			Dataset: persister.DatasetRateUSD,
+			Aliases: []string{"/getRateUSD", "/rateUSD"},
+			Summary: "USD price of TOMO",
+			Data: schema{
+				"type": "array",
+				"items": schema{
+					"type": "object",
+					"properties": schema{
+						"symbol":    schema{"type": "string"},
+						"price_usd": schema{"type": "string"},
+					},
+				},
+			},
+			legacy: httpServer.GetRateUSD,
+			v2:     httpServer.v2RateUSD,
+		},
+		{
+			Path:    "/rateTOMO",
+			Group:   groupData,
+			Dataset: persister.DatasetRateUSD,
+			Aliases: []string{"/getRateTOMO", "/rateTOMO"},
+			Summary: "USD price of TOMO as a string",
+			Data:    schema{"type": "string"},
+			legacy:  httpServer.GetRateTOMO,
+			v2:      httpServer.v2RateTOMO,
+		},
+		{
+			Path:    "/last7D",
+			Group:   groupHistory,
+			Dataset: persister.DatasetMarketInfo,
+			Aliases: []string{"/getLast7D", "/last7D"},
+			Summary: "Prices of the last 7 days of the given tokens",
+			Query: []queryParam{
+				{Name: "listToken", Description: "symbols separated by -, e.g. TOMO-BTC", Required: true},
+			},
+			Data: schema{
+				"type":                 "object",
+				"additionalProperties": schema{"type": "array", "items": schema{"type": "number"}},
+			},
+			legacy: httpServer.GetLast7D,
+			v2:     httpServer.v2Last7D,
+		},
+		{
+			Path:    "/tokens/changes",
+			Group:   groupHistory,
+			Dataset: persister.DatasetTokenChanges,
+			Aliases: []string{"/tokens/changes"},
+			Summary: "Listings, delistings and address changes of the token list, oldest first",
+			Query: []queryParam{
+				{Name: "since", Description: "only the changes after this seq, default 0"},
+				{Name: "limit", Description: "most changes returned, 1 to 1000, default 100"},
+			},
+			Data: schema{
+				"type": "array",
+				"items": schema{
+					"type": "object",
+					"properties": schema{
+						"seq":         schema{"type": "integer"},
+						"type":        schema{"type": "string", "enum": []string{tomochain.TokenListed, tomochain.TokenDelisted, tomochain.TokenAddressChanged}},
+						"token_id":    schema{"type": "string"},
+						"symbol":      schema{"type": "string"},
+						"address":     schema{"type": "string"},
+						"old_address": schema{"type": "string"},
+						"delist_time": schema{"type": "integer"},
+						"timestamp":   schema{"type": "integer"},
+					},
+				},
+			},
+			legacy: httpServer.getTokenChanges,
+			v2:     httpServer.v2TokenChanges,
+		},
+		{
+			Path:    "/cacheVersion",
+			Group:   groupData,
+			Aliases: []string{"/cacheVersion"},
+			Summary: "Start time of the cache, changes on every restart",
+			Data:    schema{"type": "string"},
+			legacy:  httpServer.getCacheVersion,
+			v2:      httpServer.v2CacheVersion,
+		},
+	}
+}
+
+// registerRoutes serve the route table, legacy aliases and /v2 for every network
+func (httpServer *HTTPServer) registerRoutes() {
+	routes := httpServer.routes()
+	for _, rt := range routes {
+		for _, alias := range rt.Aliases {
+			httpServer.get(alias, httpServer.rateLimit(rt.Group), httpServer.cached(rt, rt.legacy))
+		}
+		label := v2Prefix + "/{network}" + rt.Path
+		for _, name := range httpServer.networkNames() {
+			httpServer.r.GET(v2Prefix+"/"+name+rt.Path, instrument(label), withNetwork(httpServer.networks[name]),
+				httpServer.rateLimit(rt.Group), httpServer.cached(rt, httpServer.serveV2(rt.v2)))
+		}
+	}
+
+	// long lived, kept out of the request duration metrics
+	httpServer.r.GET(streamPath, httpServer.rateLimit(groupStream), httpServer.getStream)
+
+	doc := openAPI(routes, httpServer.networkNames(), httpServer.defaultNetwork)
+	httpServer.get(openAPIPath, httpServer.rateLimit(groupData), func(c *gin.Context) {
+		c.JSON(http.StatusOK, doc)
+	})
+	httpServer.r.NoRoute(noRoute)
+}
+
+// openAPI generate the OpenAPI 3 document of the route table
+func openAPI(routes []route, networks []string, defaultNetwork string) schema {
+	envelope := func(data schema) schema {
+		return schema{
+			"type": "object",
+			"properties": schema{
+				"data":      data,
+				"error":     schema{"$ref": "#/components/schemas/Error"},
+				"updatedAt": schema{"type": "integer", "description": "unix time the data was fetched"},
+				"stale":     schema{"type": "boolean", "description": "last refresh failed or is older than the max data age"},
+				"block":     schema{"type": "string", "description": "block the rates were read at"},
+			},
+		}
+	}
+	errorResponse := func(description string) schema {
+		return schema{
+			"description": description,
+			"content": schema{"application/json": schema{
+				"schema": envelope(schema{"nullable": true}),
+			}},
+		}
+	}
+
+	paths := schema{}
+	for _, rt := range routes {
+		parameters := []schema{{
+			"name":     "network",
+			"in":       "path",
+			"required": true,
+			"schema":   schema{"type": "string", "enum": networks},
+		}}
+		legacyParameters := []schema{}
+		for _, q := range rt.Query {
+			param := schema{
+				"name":        q.Name,
+				"in":          "query",
+				"required":    q.Required,
+				"description": q.Description,
+				"schema":      schema{"type": "string"},
+			}
+			parameters = append(parameters, param)
+			legacyParameters = append(legacyParameters, param)
+		}
+		responses := schema{
+			"200": schema{
+				"description": "OK",
+				"content": schema{"application/json": schema{
+					"schema": envelope(rt.Data),
+				}},
+			},
+			"401": errorResponse("unauthorized: unknown api key"),
+			"429": errorResponse("rate_limited: too many requests, see Retry-After"),
+			"503": errorResponse("not_ready: the data was never fetched"),
+		}
+		if len(rt.Query) > 0 {
+			responses["400"] = errorResponse("bad_request: invalid query")
+		}
+		paths[v2Prefix+"/{network}"+rt.Path] = schema{"get": schema{
+			"operationId": strings.Replace(strings.TrimPrefix(rt.Path, "/"), "/", "_", -1),
+			"summary":     rt.Summary,
+			"parameters":  parameters,
+			"responses":   responses,
+		}}
+
+		for _, alias := range rt.Aliases {
+			paths[alias] = schema{"get": schema{
+				"operationId": "legacy" + strings.Replace(alias, "/", "_", -1),
+				"summary":     rt.Summary + " of the " + defaultNetwork + " network",
+				"deprecated":  true,
+				"parameters":  legacyParameters,
+				"responses": schema{"200": schema{
+					"description": "Legacy response, success is false when the data is not fresh",
+					"content": schema{"application/json": schema{
+						"schema": schema{"$ref": "#/components/schemas/Legacy"},
+					}},
+				}},
+			}}
+		}
+	}
+
+	paths[streamPath] = schema{"get": schema{
+		"operationId": "stream",
+		"summary":     "Changes of the cache, as WebSocket JSON messages when upgraded or Server-Sent Events named after the channel",
+		"parameters": []schema{
+			{"name": "network", "in": "query", "schema": schema{"type": "string", "enum": networks, "default": defaultNetwork}},
+			{"name": "channels", "in": "query", "description": "comma separated, every channel when empty",
+				"schema": schema{"type": "string", "example": strings.Join(stream.Channels, ",")}},
+			{"name": "symbols", "in": "query", "description": "comma separated, only the changes of these tokens",
+				"schema": schema{"type": "string"}},
+		},
+		"responses": schema{
+			"200": schema{
+				"description": "Stream of messages",
+				"content": schema{"text/event-stream": schema{"schema": schema{
+					"type": "object",
+					"properties": schema{
+						"channel": schema{"type": "string", "enum": stream.Channels},
+						"network": schema{"type": "string"},
+						"time":    schema{"type": "integer"},
+						"data":    schema{"type": "array", "items": schema{}},
+					},
+				}}},
+			},
+			"400": errorResponse("bad_request: unknown channel"),
+			"401": errorResponse("unauthorized: unknown api key"),
+			"404": errorResponse("not_found: unknown network"),
+			"429": errorResponse("rate_limited: too many requests, see Retry-After"),
+		},
+	}}
+
+	codes := make([]string, 0, len(errorStatus))
+	for code := range errorStatus {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return schema{
+		"openapi": "3.0.0",
+		"info": schema{
+			"title":   "ChainTeX cached server",
+			"version": apiVersion,
+		},
+		"paths": paths,
+		"components": schema{"schemas": schema{
+			"Error": schema{
+				"type":     "object",
+				"nullable": true,
+				"properties": schema{
+					"code":    schema{"type": "string", "enum": codes},
+					"message": schema{"type": "string"},
+				},
+			},
+			"Legacy": schema{
+				"type": "object",
+				"properties": schema{
+					"success": schema{"type": "boolean"},
+					"data":    schema{},
+				},
+			},
+		}},
+	}
+}
@@ -0,0 +1,42 @@
+package http
+
+import (
+	"strings"
+
+	"github.com/gin-contrib/cors"
+	"github.com/gin-gonic/gin"
+	"github.com/marknguyen85/server-api/config"
+)
+
+// exposedHeaders can be read by the browser clients of other origins
+var exposedHeaders = []string{"ETag", "Last-Modified", "Retry-After", "X-RateLimit-Limit", requestIDHeader}
+
+// corsPolicy apply the cross origin policy of the config, refusing other
+// origins with 403
+func corsPolicy(policy config.CORS) gin.HandlerFunc {
+	return cors.New(cors.Config{
+		AllowOrigins:           policy.Origins(),
+		AllowMethods:           policy.AllowMethods,
+		AllowHeaders:           policy.AllowHeaders,
+		ExposeHeaders:          exposedHeaders,
+		MaxAge:                 policy.MaxAge.Std(),
+		AllowWildcard:          true,
+		AllowBrowserExtensions: true,
+	})
+}
+
+// securityHeaders set the headers hardening browsers reading the api, which
+// only serve data: nothing is framed, sniffed or run
+func securityHeaders() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		header := c.Writer.Header()
+		header.Set("X-Content-Type-Options", "nosniff")
+		header.Set("X-Frame-Options", "DENY")
+		header.Set("Referrer-Policy", "no-referrer")
+		header.Set("Content-Security-Policy", "default-src 'none'; frame-ancestors 'none'")
+		if c.Request.TLS != nil || strings.EqualFold(c.GetHeader("X-Forwarded-Proto"), "https") {
+			header.Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains")
+		}
+		c.Next()
+	}
+}
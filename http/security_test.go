@@ -0,0 +1,39 @@
+package http
+
+import (
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/marknguyen85/server-api/config"
+)
+
+func TestCORSPolicyOrigins(t *testing.T) {
+	policy := config.Default().CORS
+	policy.AllowOrigins = []string{"https://wallet.example.com"}
+	policy.ExtensionIDs = []string{"abcdefghijklmnopabcdefghijklmnop"}
+	policy.FirefoxExtensions = true
+	r := gin.New()
+	r.Use(corsPolicy(policy))
+	r.GET("/", func(c *gin.Context) {
+		c.String(nethttp.StatusOK, "")
+	})
+
+	for origin, allowed := range map[string]bool{
+		"https://wallet.example.com":                           true,
+		"chrome-extension://abcdefghijklmnopabcdefghijklmnop":  true,
+		"moz-extension://0b6f4a8e-6d2c-4c1e-9a57-3f1f0e8b2c11": true,
+		"https://evil.example.com":                             false,
+		"chrome-extension://ponmlkjihgfedcbaponmlkjihgfedcba":  false,
+	} {
+		request := httptest.NewRequest("GET", "/", nil)
+		request.Header.Set("Origin", origin)
+		recorder := httptest.NewRecorder()
+		r.ServeHTTP(recorder, request)
+		got := recorder.Header().Get("Access-Control-Allow-Origin") == origin
+		if got != allowed || (allowed && recorder.Code != nethttp.StatusOK) {
+			t.Errorf("origin %s: status %d, allowed %v, want %v", origin, recorder.Code, got, allowed)
+		}
+	}
+}
@@ -1,29 +1,60 @@
 package http
 
 import (
-	"io/ioutil"
-	"log"
+	"context"
+	"net"
 	"net/http"
+	"sort"
+	"sync"
+	"time"
 
 	raven "github.com/getsentry/raven-go"
-	"github.com/gin-contrib/cors"
 	"github.com/gin-contrib/sentry"
 	"github.com/gin-gonic/gin"
+	"github.com/marknguyen85/server-api/config"
 	"github.com/marknguyen85/server-api/fetcher"
-	persister "github.com/marknguyen85/server-api/persister"
+	"github.com/marknguyen85/server-api/metrics"
+	"github.com/marknguyen85/server-api/ratelimit"
+	"github.com/marknguyen85/server-api/scheduler"
+	"github.com/marknguyen85/server-api/stream"
+	log "github.com/sirupsen/logrus"
 )
 
+const shutdownTimeout = 15 * time.Second
+
 //HTTPServer struct
 type HTTPServer struct {
-	fetcher   *fetcher.Fetcher
-	persister persister.Persister
-	host      string
-	r         *gin.Engine
+	networks       map[string]*Network
+	defaultNetwork string
+	host           string
+	r              *gin.Engine
+	scheduler      *scheduler.Scheduler
+	maxDataAge     time.Duration
+	adminToken     string
+	adminSecret    string
+	reloader       Reloader
+	hub            *stream.Hub
+	startedAt      int64
+	rendered       *renderCache
+	limits         *ratelimit.Policy
+	// cert is nil when serving plain http
+	cert         *Certificate
+	redirectAddr string
+	// proxies whose X-Forwarded-For is believed
+	proxies []*net.IPNet
+
+	routesOnce sync.Once
+}
+
+// Reloader apply the config again, used by /admin/reload
+type Reloader interface {
+	Reload() error
 }
 
 //GetRate func
 func (httpServer *HTTPServer) GetRate(c *gin.Context) {
-	isNewRate := httpServer.persister.GetIsNewRate()
+	persister := httpServer.network(c).Persister
+	isNewRate := persister.GetIsNewRate()
 	if isNewRate != true {
 		c.JSON(
 			http.StatusOK,
@@ -32,17 +63,18 @@ func (httpServer *HTTPServer) GetRate(c *gin.Context) {
 		return
 	}
 
-	rates := httpServer.persister.GetRate()
-	updateAt := httpServer.persister.GetTimeUpdateRate()
+	rates := persister.GetRate()
+	updateAt := persister.GetTimeUpdateRate()
 	c.JSON(
 		http.StatusOK,
-		gin.H{"success": true, "updateAt": updateAt, "data": rates},
+		gin.H{"success": true, "updateAt": updateAt, "block": persister.GetRateBlock(), "data": rates},
 	)
 }
 
 //GetRateUSD func
 func (httpServer *HTTPServer) GetRateUSD(c *gin.Context) {
-	if !httpServer.persister.GetIsNewRateUSD() {
+	persister := httpServer.network(c).Persister
+	if !persister.GetIsNewRateUSD() {
 		c.JSON(
 			http.StatusOK,
 			gin.H{"success": false},
@@ -50,7 +82,7 @@ func (httpServer *HTTPServer) GetRateUSD(c *gin.Context) {
 		return
 	}
 
-	rates := httpServer.persister.GetRateUSD()
+	rates := persister.GetRateUSD()
 	c.JSON(
 		http.StatusOK,
 		gin.H{"success": true, "data": rates},
@@ -59,7 +91,8 @@ func (httpServer *HTTPServer) GetRateUSD(c *gin.Context) {
 
 //GetRateTOMO func
 func (httpServer *HTTPServer) GetRateTOMO(c *gin.Context) {
-	if !httpServer.persister.GetIsNewRateUSD() {
+	persister := httpServer.network(c).Persister
+	if !persister.GetIsNewRateUSD() {
 		c.JSON(
 			http.StatusOK,
 			gin.H{"success": false},
@@ -67,34 +100,19 @@ func (httpServer *HTTPServer) GetRateTOMO(c *gin.Context) {
 		return
 	}
 
-	tomoRate := httpServer.persister.GetRateTOMO()
+	tomoRate := persister.GetRateTOMO()
 	c.JSON(
 		http.StatusOK,
 		gin.H{"success": true, "data": tomoRate},
 	)
 }
 
-//GetErrorLog func
-func (httpServer *HTTPServer) GetErrorLog(c *gin.Context) {
-	dat, err := ioutil.ReadFile("error.log")
-	if err != nil {
-		log.Print(err)
-		c.JSON(
-			http.StatusOK,
-			gin.H{"success": false, "data": err},
-		)
-	}
-	c.JSON(
-		http.StatusOK,
-		gin.H{"success": true, "data": string(dat[:])},
-	)
-}
-
 //GetLast7D func
 func (httpServer *HTTPServer) GetLast7D(c *gin.Context) {
+	persister := httpServer.network(c).Persister
 	listTokens := c.Query("listToken")
-	data := httpServer.persister.GetLast7D(listTokens)
-	if httpServer.persister.GetIsNewTrackerData() {
+	data := persister.GetLast7D(listTokens)
+	if persister.GetIsNewTrackerData() {
 		c.JSON(
 			http.StatusOK,
 			gin.H{"success": true, "data": data, "status": "latest"},
@@ -109,43 +127,142 @@ func (httpServer *HTTPServer) GetLast7D(c *gin.Context) {
 
 //getCacheVersion func
 func (httpServer *HTTPServer) getCacheVersion(c *gin.Context) {
-	timeRun := httpServer.persister.GetTimeVersion()
+	persister := httpServer.network(c).Persister
+	timeRun := persister.GetTimeVersion()
 	c.JSON(
 		http.StatusOK,
 		gin.H{"success": true, "data": timeRun},
 	)
 }
 
-//Run func
-func (httpServer *HTTPServer) Run(chainTexENV string) {
-	httpServer.r.GET("/getRate", httpServer.GetRate)
-	httpServer.r.GET("/rate", httpServer.GetRate)
+// Handler return the routes of the server, to serve them without Run
+func (httpServer *HTTPServer) Handler() http.Handler {
+	httpServer.routesOnce.Do(func() {
+		httpServer.registerRoutes()
+
+		httpServer.get("/healthz", httpServer.getHealthz)
+		httpServer.get("/readyz", httpServer.getReadyz)
 
-	httpServer.r.GET("/getRateUSD", httpServer.GetRateUSD)
-	httpServer.r.GET("/rateUSD", httpServer.GetRateUSD)
+		// the built-in key is public, it never sign admin requests
+		secret := httpServer.adminSecret
+		if secret == fetcher.KEY {
+			log.Warn("admin_secret is the built-in key, signed admin requests are refused")
+			secret = ""
+		}
+		if httpServer.adminToken != "" || secret != "" {
+			admin := httpServer.r.Group("/admin", adminAuth(httpServer.adminToken, secret))
+			admin.GET("/logs", instrument("/admin/logs"), httpServer.getLogs)
+			admin.GET("/scheduler", instrument("/admin/scheduler"), httpServer.getScheduler)
+			admin.GET("/connections", instrument("/admin/connections"), httpServer.getConnections)
+			admin.GET("/debug/rate", instrument("/admin/debug/rate"), httpServer.debugRate)
+			admin.POST("/reload", instrument("/admin/reload"), httpServer.reload)
+		}
 
-	httpServer.r.GET("/getLast7D", httpServer.GetLast7D)
-	httpServer.r.GET("/last7D", httpServer.GetLast7D)
+		httpServer.r.GET("/metrics", gin.WrapH(metrics.Handler()))
+	})
+	return httpServer.r
+}
 
-	httpServer.r.GET("/getRateTOMO", httpServer.GetRateTOMO)
-	httpServer.r.GET("/rateTOMO", httpServer.GetRateTOMO)
+//Run func serve until ctx is cancelled then drain in-flight requests
+func (httpServer *HTTPServer) Run(ctx context.Context) error {
+	collector := cacheCollector{httpServer}
+	if err := metrics.Registry.Register(collector); err != nil {
+		return err
+	}
+	defer metrics.Registry.Unregister(collector)
 
-	httpServer.r.GET("/cacheVersion", httpServer.getCacheVersion)
+	srv := &http.Server{
+		Addr:    httpServer.host,
+		Handler: httpServer.Handler(),
+	}
+	// Shutdown does not wait for hijacked connections nor cancel the
+	// streaming requests, end the streams
+	if httpServer.hub != nil {
+		srv.RegisterOnShutdown(httpServer.hub.Close)
+	}
+	servers := []*http.Server{srv}
+	errCh := make(chan error, 2)
+	if httpServer.cert == nil {
+		go func() {
+			errCh <- srv.ListenAndServe()
+		}()
+	} else {
+		srv.TLSConfig = httpServer.tlsConfig()
+		go func() {
+			errCh <- srv.ListenAndServeTLS("", "")
+		}()
+		if httpServer.redirectAddr != "" {
+			redirect := &http.Server{
+				Addr:    httpServer.redirectAddr,
+				Handler: httpServer.redirectHandler(),
+			}
+			servers = append(servers, redirect)
+			go func() {
+				errCh <- redirect.ListenAndServe()
+			}()
+		}
+	}
 
-	if chainTexENV != "production" {
-		httpServer.r.GET("/9d74529bc6c25401a2f984ccc9b0b2b3", httpServer.GetErrorLog)
+	var err error
+	select {
+	case err = <-errCh:
+	case <-ctx.Done():
 	}
 
-	httpServer.r.Run(httpServer.host)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, s := range servers {
+		if shutdownErr := s.Shutdown(shutdownCtx); err == nil {
+			err = shutdownErr
+		}
+	}
+	return err
 }
 
-//NewHTTPServer contruct
-func NewHTTPServer(host string, persister persister.Persister, fetcher *fetcher.Fetcher) *HTTPServer {
-	r := gin.Default()
+//NewHTTPServer contruct, the routes without network serve defaultNetwork,
+//maxDataAge is the age after which a dataset makes the server not ready,
+//adminToken is the bearer token of /admin api and adminSecret the key of its
+//signed requests, either empty to refuse that way, both to not serve /admin,
+//limits throttle the public routes, nil to serve them without limit,
+//corsConfig is the cross origin policy, validated by the config
+func NewHTTPServer(host string, networks []*Network, defaultNetwork string, scheduler *scheduler.Scheduler,
+	maxDataAge time.Duration, adminToken, adminSecret string, reloader Reloader, hub *stream.Hub, limits *ratelimit.Policy,
+	corsConfig config.CORS) *HTTPServer {
+	r := gin.New()
+	// the client address is resolved from the trusted proxies only
+	r.ForwardedByClientIP = false
+
+	byName := make(map[string]*Network, len(networks))
+	for _, network := range networks {
+		byName[network.Name] = network
+	}
+	httpServer := &HTTPServer{
+		networks:       byName,
+		defaultNetwork: defaultNetwork,
+		host:           host,
+		r:              r,
+		scheduler:      scheduler,
+		maxDataAge:     maxDataAge,
+		adminToken:     adminToken,
+		adminSecret:    adminSecret,
+		reloader:       reloader,
+		hub:            hub,
+		startedAt:      time.Now().UTC().Unix(),
+		rendered:       newRenderCache(),
+		limits:         limits,
+	}
+	r.Use(requestID(), httpServer.resolveClientIP(), accessLog())
 	r.Use(sentry.Recovery(raven.DefaultClient, false))
-	r.Use(cors.Default())
+	r.Use(securityHeaders(), corsPolicy(corsConfig))
+	return httpServer
+}
 
-	return &HTTPServer{
-		fetcher, persister, host, r,
+// networkNames return the served networks, sorted
+func (httpServer *HTTPServer) networkNames() []string {
+	names := make([]string, 0, len(httpServer.networks))
+	for name := range httpServer.networks {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
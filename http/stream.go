@@ -0,0 +1,157 @@
+package http
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/marknguyen85/server-api/stream"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	streamPath = v2Prefix + "/stream"
+	// idle connections get a ping so proxies do not close them
+	streamPingInterval = 30 * time.Second
+	streamWriteTimeout = 10 * time.Second
+)
+
+// streamClosed are the messages sent when the hub drop a stream, by reason
+var streamClosed = map[string]string{
+	stream.SlowConsumer: "buffer full, disconnected",
+	stream.ShuttingDown: "server shutting down",
+}
+
+// closedError is the error sent to a stream the hub dropped
+func closedError(sub *stream.Subscription) APIError {
+	reason := sub.Reason()
+	return APIError{Code: reason, Message: streamClosed[reason]}
+}
+
+// splitList split a comma separated query value, ignoring empty entries
+func splitList(value string) []string {
+	var result []string
+	for _, entry := range strings.Split(value, ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			result = append(result, entry)
+		}
+	}
+	return result
+}
+
+// getStream push changes of the cache. Query: network (default network),
+// channels and symbols, comma separated. WebSocket when the client ask for an
+// upgrade, Server-Sent Events otherwise.
+func (httpServer *HTTPServer) getStream(c *gin.Context) {
+	network := c.DefaultQuery("network", httpServer.defaultNetwork)
+	if _, ok := httpServer.networks[network]; !ok {
+		respondError(c, newAPIError(errNotFound, "unknown network "+network))
+		return
+	}
+	channels := splitList(c.Query("channels"))
+	for _, channel := range channels {
+		known := false
+		for _, name := range stream.Channels {
+			known = known || name == channel
+		}
+		if !known {
+			respondError(c, newAPIError(errBadRequest, "unknown channel "+channel))
+			return
+		}
+	}
+	symbols := splitList(c.Query("symbols"))
+
+	sub := httpServer.hub.Subscribe(network, channels, symbols)
+	defer httpServer.hub.Unsubscribe(sub)
+	streamLog := requestLog(c).WithField("network", network)
+
+	if strings.EqualFold(c.GetHeader("Upgrade"), "websocket") {
+		server := websocket.Server{Handler: func(ws *websocket.Conn) {
+			streamWebSocket(ws, sub)
+		}}
+		server.ServeHTTP(c.Writer, c.Request)
+		streamLog.Debug("websocket stream closed")
+		return
+	}
+	streamSSE(c, sub)
+	streamLog.Debug("sse stream closed")
+}
+
+// streamWebSocket send each message as a JSON text frame until the client
+// leave or is dropped, as a slow consumer or on shutdown
+func streamWebSocket(ws *websocket.Conn, sub *stream.Subscription) {
+	defer ws.Close()
+	// the client only send close frames, reading detect it leaving
+	gone := make(chan struct{})
+	go func() {
+		var discard []byte
+		for websocket.Message.Receive(ws, &discard) == nil {
+		}
+		close(gone)
+	}()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	for {
+		var err error
+		select {
+		case <-gone:
+			return
+		case <-sub.Done():
+			ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			websocket.JSON.Send(ws, gin.H{"error": closedError(sub)})
+			return
+		case msg := <-sub.Messages():
+			ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			err = websocket.JSON.Send(ws, msg)
+		case <-ping.C:
+			ws.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			err = websocket.JSON.Send(ws, gin.H{"channel": "ping", "time": time.Now().UTC().Unix()})
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// streamSSE write each message as an event named after its channel
+func streamSSE(c *gin.Context, sub *stream.Subscription) {
+	header := c.Writer.Header()
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	c.Writer.Flush()
+
+	ping := time.NewTicker(streamPingInterval)
+	defer ping.Stop()
+	gone := c.Request.Context().Done()
+	for {
+		select {
+		case <-gone:
+			return
+		case <-sub.Done():
+			data, _ := json.Marshal(closedError(sub))
+			fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\n", data)
+			c.Writer.Flush()
+			return
+		case msg := <-sub.Messages():
+			data, err := json.Marshal(msg)
+			if err != nil {
+				continue
+			}
+			if _, err = fmt.Fprintf(c.Writer, "event: %s\ndata: %s\n\n", msg.Channel, data); err != nil {
+				return
+			}
+		case <-ping.C:
+			if _, err := fmt.Fprint(c.Writer, ": ping\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
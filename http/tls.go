@@ -0,0 +1,137 @@
+package http
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Certificate is the key pair served over TLS. It is swapped when the files
+// change, new handshakes use the new one and open connections are kept.
+type Certificate struct {
+	certFile string
+	keyFile  string
+
+	mu      sync.RWMutex
+	cert    *tls.Certificate
+	modTime time.Time
+}
+
+// NewCertificate load the key pair of certFile and keyFile, PEM encoded
+func NewCertificate(certFile, keyFile string) (*Certificate, error) {
+	certificate := &Certificate{certFile: certFile, keyFile: keyFile}
+	if err := certificate.Reload(); err != nil {
+		return nil, err
+	}
+	return certificate, nil
+}
+
+// filesModTime return the latest modification time of the two files
+func (certificate *Certificate) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, path := range []string{certificate.certFile, certificate.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// Reload read the files again. An invalid pair is reported and the served
+// one kept.
+func (certificate *Certificate) Reload() error {
+	if certificate == nil {
+		return nil
+	}
+	modTime, err := certificate.filesModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(certificate.certFile, certificate.keyFile)
+	if err != nil {
+		return err
+	}
+	certificate.mu.Lock()
+	reloaded := certificate.cert != nil
+	certificate.cert = &cert
+	certificate.modTime = modTime
+	certificate.mu.Unlock()
+	if reloaded {
+		log.WithField("cert", certificate.certFile).Info("certificate reloaded")
+	}
+	return nil
+}
+
+// Watch poll the modification time of the files every interval until ctx is done
+func (certificate *Certificate) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		modTime, err := certificate.filesModTime()
+		certificate.mu.RLock()
+		last := certificate.modTime
+		certificate.mu.RUnlock()
+		if err != nil || !modTime.After(last) {
+			continue
+		}
+		if err := certificate.Reload(); err != nil {
+			log.WithField("cert", certificate.certFile).WithError(err).Error("cannot reload certificate")
+		}
+	}
+}
+
+// GetCertificate implement tls.Config.GetCertificate
+func (certificate *Certificate) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certificate.mu.RLock()
+	defer certificate.mu.RUnlock()
+	return certificate.cert, nil
+}
+
+// EnableTLS serve https with cert, with HTTP/2. When redirectAddr is set a
+// plain http listener there redirect every request to https.
+func (httpServer *HTTPServer) EnableTLS(cert *Certificate, redirectAddr string) {
+	httpServer.cert = cert
+	httpServer.redirectAddr = redirectAddr
+}
+
+func (httpServer *HTTPServer) tlsConfig() *tls.Config {
+	return &tls.Config{
+		GetCertificate: httpServer.cert.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+}
+
+// redirectHandler send clients of the http listener to the same url over https
+func (httpServer *HTTPServer) redirectHandler() http.Handler {
+	_, port, _ := net.SplitHostPort(httpServer.host)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		status := http.StatusMovedPermanently
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), status)
+	})
+}
@@ -0,0 +1,69 @@
+package http
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/marknguyen85/server-api/tomochain"
+)
+
+const (
+	defaultChangesLimit = 100
+	maxChangesLimit     = 1000
+)
+
+// tokenChanges read the token list changes after the since query, at most
+// limit of them, oldest first
+func tokenChanges(c *gin.Context, network *Network) ([]tomochain.TokenChange, *APIError) {
+	since, limit := uint64(0), defaultChangesLimit
+	if value := c.Query("since"); value != "" {
+		var err error
+		if since, err = strconv.ParseUint(value, 10, 64); err != nil {
+			return nil, newAPIError(errBadRequest, "since must be a change seq")
+		}
+	}
+	if value := c.Query("limit"); value != "" {
+		var err error
+		if limit, err = strconv.Atoi(value); err != nil || limit < 1 || limit > maxChangesLimit {
+			return nil, newAPIError(errBadRequest, "limit must be between 1 and "+strconv.Itoa(maxChangesLimit))
+		}
+	}
+	if network.Bolt == nil {
+		return nil, newAPIError(errNotReady, "token changes are not recorded without a database")
+	}
+	changes, err := network.Bolt.GetTokenChanges(since, limit)
+	if err != nil {
+		return nil, newAPIError(errInternal, "cannot read token changes")
+	}
+	return changes, nil
+}
+
+//getTokenChanges func
+func (httpServer *HTTPServer) getTokenChanges(c *gin.Context) {
+	changes, apiErr := tokenChanges(c, httpServer.network(c))
+	if apiErr != nil {
+		status := errorStatus[apiErr.Code]
+		c.JSON(status, gin.H{"success": false, "error": apiErr.Message})
+		return
+	}
+	c.JSON(
+		http.StatusOK,
+		gin.H{"success": true, "data": changes},
+	)
+}
+
+func (httpServer *HTTPServer) v2TokenChanges(c *gin.Context, network *Network) (Response, *APIError) {
+	changes, apiErr := tokenChanges(c, network)
+	if apiErr != nil {
+		return Response{}, apiErr
+	}
+	var updatedAt int64
+	if len(changes) > 0 {
+		updatedAt = changes[len(changes)-1].Timestamp
+	}
+	return Response{
+		Data:      changes,
+		UpdatedAt: updatedAt,
+	}, nil
+}
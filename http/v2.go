@@ -0,0 +1,151 @@
+package http
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// error codes of the /v2 api
+const (
+	errBadRequest   = "bad_request"
+	errUnauthorized = "unauthorized"
+	errNotFound     = "not_found"
+	errRateLimited  = "rate_limited"
+	errNotReady     = "not_ready"
+	errInternal     = "internal"
+)
+
+// errorStatus map each error code to its HTTP status
+var errorStatus = map[string]int{
+	errBadRequest:   http.StatusBadRequest,
+	errUnauthorized: http.StatusUnauthorized,
+	errNotFound:     http.StatusNotFound,
+	errRateLimited:  http.StatusTooManyRequests,
+	errNotReady:     http.StatusServiceUnavailable,
+	errInternal:     http.StatusInternalServerError,
+}
+
+// APIError is the error of a failed /v2 request
+type APIError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Response is the envelope of every /v2 response. Data is null on error,
+// UpdatedAt is the unix time the data was fetched and Stale tell the last
+// refresh failed or is older than the max data age.
+type Response struct {
+	Data      interface{} `json:"data"`
+	Error     *APIError   `json:"error"`
+	UpdatedAt int64       `json:"updatedAt"`
+	Stale     bool        `json:"stale"`
+	// Block the data was read at, for rates
+	Block string `json:"block,omitempty"`
+}
+
+// v2Handler compute the response of a /v2 route for network
+type v2Handler func(c *gin.Context, network *Network) (Response, *APIError)
+
+func newAPIError(code, message string) *APIError {
+	return &APIError{Code: code, Message: message}
+}
+
+// respondError write the envelope of a failed request
+func respondError(c *gin.Context, apiErr *APIError) {
+	status, ok := errorStatus[apiErr.Code]
+	if !ok {
+		status = http.StatusInternalServerError
+	}
+	c.JSON(status, Response{Error: apiErr})
+}
+
+// serveV2 adapt handler to gin, writing the envelope
+func (httpServer *HTTPServer) serveV2(handler v2Handler) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		response, apiErr := handler(c, httpServer.network(c))
+		if apiErr != nil {
+			respondError(c, apiErr)
+			return
+		}
+		c.JSON(http.StatusOK, response)
+	}
+}
+
+// noRoute answer unknown /v2 paths with the envelope, other paths keep the
+// default plain text 404
+func noRoute(c *gin.Context) {
+	if strings.HasPrefix(c.Request.URL.Path, "/v2/") {
+		respondError(c, newAPIError(errNotFound, "no route "+c.Request.URL.Path))
+	}
+}
+
+// isStale tell whether dataset of network is older than the max data age
+func (httpServer *HTTPServer) isStale(network *Network, dataset string) bool {
+	return httpServer.datasetAges(network)[dataset].Stale
+}
+
+func (httpServer *HTTPServer) v2Rate(c *gin.Context, network *Network) (Response, *APIError) {
+	persister := network.Persister
+	updatedAt := persister.GetTimeUpdateRate()
+	if updatedAt == 0 {
+		return Response{}, newAPIError(errNotReady, "rates are not fetched yet")
+	}
+	return Response{
+		Data:      persister.GetRate(),
+		UpdatedAt: updatedAt,
+		Stale:     !persister.GetIsNewRate() || httpServer.isStale(network, "rate"),
+		Block:     persister.GetRateBlock(),
+	}, nil
+}
+
+func (httpServer *HTTPServer) v2RateUSD(c *gin.Context, network *Network) (Response, *APIError) {
+	persister := network.Persister
+	updatedAt := persister.GetTimeUpdateRateUSD()
+	if updatedAt == 0 {
+		return Response{}, newAPIError(errNotReady, "usd rates are not fetched yet")
+	}
+	return Response{
+		Data:      persister.GetRateUSD(),
+		UpdatedAt: updatedAt,
+		Stale:     !persister.GetIsNewRateUSD() || httpServer.isStale(network, "rateUSD"),
+	}, nil
+}
+
+func (httpServer *HTTPServer) v2RateTOMO(c *gin.Context, network *Network) (Response, *APIError) {
+	persister := network.Persister
+	updatedAt := persister.GetTimeUpdateRateUSD()
+	if updatedAt == 0 {
+		return Response{}, newAPIError(errNotReady, "usd rates are not fetched yet")
+	}
+	return Response{
+		Data:      persister.GetRateTOMO(),
+		UpdatedAt: updatedAt,
+		Stale:     !persister.GetIsNewRateUSD() || httpServer.isStale(network, "rateUSD"),
+	}, nil
+}
+
+func (httpServer *HTTPServer) v2Last7D(c *gin.Context, network *Network) (Response, *APIError) {
+	listTokens := c.Query("listToken")
+	if listTokens == "" {
+		return Response{}, newAPIError(errBadRequest, "listToken is required")
+	}
+	persister := network.Persister
+	updatedAt := persister.GetTimeUpdateMarketInfo()
+	if updatedAt == 0 {
+		return Response{}, newAPIError(errNotReady, "market data are not fetched yet")
+	}
+	return Response{
+		Data:      persister.GetLast7D(listTokens),
+		UpdatedAt: updatedAt,
+		Stale:     !persister.GetIsNewTrackerData() || httpServer.isStale(network, "marketInfo"),
+	}, nil
+}
+
+func (httpServer *HTTPServer) v2CacheVersion(c *gin.Context, network *Network) (Response, *APIError) {
+	return Response{
+		Data:      network.Persister.GetTimeVersion(),
+		UpdatedAt: httpServer.startedAt,
+	}, nil
+}
@@ -0,0 +1,62 @@
+package httpclient
+
+import (
+	"sync"
+	"time"
+)
+
+// breaker stop calling a host after consecutive failures. Once cooldown has
+// passed one probe call is let through: its success close the breaker, its
+// failure open it again.
+type breaker struct {
+	mu        sync.Mutex
+	failures  int
+	openUntil time.Time
+	probing   bool
+}
+
+// allow tell whether a call may be made now
+func (b *breaker) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.openUntil.IsZero() {
+		return true
+	}
+	if now.Before(b.openUntil) || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// success close the breaker, return true when it was open
+func (b *breaker) success() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	wasOpen := !b.openUntil.IsZero()
+	b.failures = 0
+	b.openUntil = time.Time{}
+	b.probing = false
+	return wasOpen
+}
+
+// abort end a call which neither failed nor succeeded, e.g. cancelled
+func (b *breaker) abort() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probing = false
+}
+
+// failure count a failed call, return true when it open the breaker
+func (b *breaker) failure(now time.Time, threshold int, cooldown time.Duration) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	if b.probing || (b.openUntil.IsZero() && b.failures >= threshold) {
+		opening := b.openUntil.IsZero()
+		b.openUntil = now.Add(cooldown)
+		b.probing = false
+		return opening
+	}
+	return false
+}
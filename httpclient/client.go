@@ -0,0 +1,305 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/marknguyen85/server-api/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxErrorBody is the part of a failed response body kept in StatusError
+const maxErrorBody = 512
+
+// ErrCircuitOpen is returned without calling a host whose breaker is open
+var ErrCircuitOpen = errors.New("circuit breaker open")
+
+// StatusError is a response with another status than 200
+type StatusError struct {
+	Code int
+	Body string
+}
+
+func (err *StatusError) Error() string {
+	return fmt.Sprintf("Status code is not 200: %d %s", err.Code, err.Body)
+}
+
+// Options of a Client, zero values take the defaults of DefaultOptions
+type Options struct {
+	// Timeout of one attempt
+	Timeout time.Duration
+	// HostTimeouts override Timeout for some hosts
+	HostTimeouts map[string]time.Duration
+	// Retries after the first attempt, on network errors, 429 and 5xx
+	Retries int
+	// BaseBackoff is the first delay between attempts, doubled on each retry
+	// and jittered. A Retry-After longer than MaxBackoff end the retries.
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// BreakerFailures consecutive failures open the breaker of a host for
+	// BreakerCooldown
+	BreakerFailures int
+	BreakerCooldown time.Duration
+	// Transport send the requests, nil for a pooled http transport. Tests
+	// and the record or replay modes set it.
+	Transport http.RoundTripper
+}
+
+// DefaultOptions return the options of the default client
+func DefaultOptions() Options {
+	return Options{
+		Timeout:         5 * time.Second,
+		Retries:         2,
+		BaseBackoff:     200 * time.Millisecond,
+		MaxBackoff:      10 * time.Second,
+		BreakerFailures: 5,
+		BreakerCooldown: 30 * time.Second,
+	}
+}
+
+// Client get urls through a pooled transport, retrying and tracking the
+// health of each host
+type Client struct {
+	client  *http.Client
+	options Options
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// New make a client
+func New(options Options) *Client {
+	defaults := DefaultOptions()
+	if options.Timeout <= 0 {
+		options.Timeout = defaults.Timeout
+	}
+	if options.Retries < 0 {
+		options.Retries = 0
+	}
+	if options.BaseBackoff <= 0 {
+		options.BaseBackoff = defaults.BaseBackoff
+	}
+	if options.MaxBackoff <= 0 {
+		options.MaxBackoff = defaults.MaxBackoff
+	}
+	if options.BreakerFailures <= 0 {
+		options.BreakerFailures = defaults.BreakerFailures
+	}
+	if options.BreakerCooldown <= 0 {
+		options.BreakerCooldown = defaults.BreakerCooldown
+	}
+	transport := options.Transport
+	if transport == nil {
+		transport = &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   5 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   10,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ExpectContinueTimeout: time.Second,
+		}
+	}
+	return &Client{
+		client:   &http.Client{Transport: transport},
+		options:  options,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+var (
+	defaultMu     sync.RWMutex
+	defaultClient = New(DefaultOptions())
+)
+
+// Default return the client shared by the fetchers
+func Default() *Client {
+	defaultMu.RLock()
+	defer defaultMu.RUnlock()
+	return defaultClient
+}
+
+// SetDefault replace the client shared by the fetchers
+func SetDefault(client *Client) {
+	defaultMu.Lock()
+	defer defaultMu.Unlock()
+	defaultClient = client
+}
+
+// Transport return the transport of the client, shared by the json rpc
+// connections over http
+func (client *Client) Transport() http.RoundTripper {
+	return client.client.Transport
+}
+
+func (client *Client) breaker(host string) *breaker {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	b, ok := client.breakers[host]
+	if !ok {
+		b = &breaker{}
+		client.breakers[host] = b
+	}
+	return b
+}
+
+func (client *Client) timeout(host string) time.Duration {
+	if timeout, ok := client.options.HostTimeouts[host]; ok {
+		return timeout
+	}
+	return client.options.Timeout
+}
+
+// Get return the body of rawURL. Network errors, 429 and 5xx are retried
+// with a jittered exponential backoff, waiting Retry-After when the server
+// send it. Other statuses fail at once with a StatusError.
+func (client *Client) Get(ctx context.Context, rawURL string) ([]byte, error) {
+	return client.send(ctx, http.MethodGet, rawURL, nil, nil)
+}
+
+// Post send body to rawURL with header and return the answer, any 2xx
+// status is a success. Failures are retried as for Get, the receiver must
+// accept the same body twice.
+func (client *Client) Post(ctx context.Context, rawURL string, header http.Header, body []byte) ([]byte, error) {
+	return client.send(ctx, http.MethodPost, rawURL, header, body)
+}
+
+// send make the request, retrying it, through the breaker of its host
+func (client *Client) send(ctx context.Context, method, rawURL string, header http.Header, payload []byte) ([]byte, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Hostname()
+	b := client.breaker(host)
+	hostLog := log.WithField("host", host)
+
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		if !b.allow(time.Now()) {
+			if lastErr != nil {
+				return nil, lastErr
+			}
+			return nil, ErrCircuitOpen
+		}
+		body, retryAfter, err := client.attempt(ctx, host, method, rawURL, header, payload)
+		lastErr = err
+		if err == nil {
+			if b.success() {
+				hostLog.Info("upstream recovered, circuit breaker closed")
+				metrics.SetCircuitOpen(host, false)
+			}
+			return body, nil
+		}
+		statusErr, isStatus := err.(*StatusError)
+		retryable := ctx.Err() == nil &&
+			(!isStatus || statusErr.Code == http.StatusTooManyRequests || statusErr.Code >= 500)
+		if !retryable {
+			// the host answered, a client error does not make it unhealthy
+			if isStatus {
+				b.success()
+			} else {
+				b.abort()
+			}
+			return nil, err
+		}
+		if b.failure(time.Now(), client.options.BreakerFailures, client.options.BreakerCooldown) {
+			hostLog.WithError(err).WithField("cooldown", client.options.BreakerCooldown.String()).
+				Warn("upstream failing, circuit breaker open")
+			metrics.SetCircuitOpen(host, true)
+		}
+		if attempt >= client.options.Retries {
+			return nil, err
+		}
+
+		delay := client.backoff(attempt)
+		if retryAfter > 0 {
+			if retryAfter > client.options.MaxBackoff {
+				return nil, err
+			}
+			delay = retryAfter
+		}
+		metrics.ObserveRetry(host)
+		hostLog.WithError(err).WithField("retry_in", delay.String()).Debug("retrying upstream call")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(delay):
+		}
+	}
+}
+
+// attempt make one request, returning the Retry-After of a failed response
+func (client *Client) attempt(ctx context.Context, host, method, rawURL string, header http.Header, payload []byte) ([]byte, time.Duration, error) {
+	ctx, cancel := context.WithTimeout(ctx, client.timeout(host))
+	defer cancel()
+	var requestBody io.Reader
+	if payload != nil {
+		requestBody = bytes.NewReader(payload)
+	}
+	request, err := http.NewRequest(method, rawURL, requestBody)
+	if err != nil {
+		return nil, 0, err
+	}
+	for name, values := range header {
+		request.Header[name] = values
+	}
+	response, err := client.client.Do(request.WithContext(ctx))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer response.Body.Close()
+	body, err := ioutil.ReadAll(response.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	success := response.StatusCode == http.StatusOK ||
+		(method != http.MethodGet && response.StatusCode/100 == 2)
+	if !success {
+		if len(body) > maxErrorBody {
+			body = body[:maxErrorBody]
+		}
+		return nil, parseRetryAfter(response.Header.Get("Retry-After")),
+			&StatusError{Code: response.StatusCode, Body: string(body)}
+	}
+	return body, 0, nil
+}
+
+// backoff return a random delay up to BaseBackoff * 2^attempt, capped
+func (client *Client) backoff(attempt int) time.Duration {
+	ceiling := client.options.BaseBackoff << uint(attempt)
+	if ceiling <= 0 || ceiling > client.options.MaxBackoff {
+		ceiling = client.options.MaxBackoff
+	}
+	return ceiling/2 + time.Duration(rand.Int63n(int64(ceiling/2)+1))
+}
+
+// parseRetryAfter read a Retry-After in seconds or as an http date
+func parseRetryAfter(value string) time.Duration {
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
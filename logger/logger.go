@@ -0,0 +1,93 @@
+package logger
+
+import (
+	"io"
+	stdlog "log"
+	"os"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	lumberjack "gopkg.in/natefinch/lumberjack.v2"
+)
+
+// Options configure where and how the standard logger write
+type Options struct {
+	Level    string
+	ToStdout bool
+
+	// rotation of File, used when ToStdout is false
+	File        string
+	MaxSizeMB   int
+	MaxBackups  int
+	MaxAgeDays  int
+	RotateEvery time.Duration
+}
+
+// Setup switch the standard logger to leveled JSON output. When logging to file
+// the file is rotated by size and every RotateEvery instead of being truncated,
+// the returned closer stop the rotation and close the file.
+func Setup(opts Options) (io.Closer, error) {
+	level, err := log.ParseLevel(opts.Level)
+	if err != nil {
+		return nil, err
+	}
+	log.SetLevel(level)
+	log.SetFormatter(&log.JSONFormatter{TimestampFormat: time.RFC3339Nano})
+	log.AddHook(Recent)
+
+	var (
+		out    io.Writer = os.Stdout
+		closer io.Closer = nopCloser{}
+	)
+	if !opts.ToStdout {
+		file := &rotatingFile{
+			Logger: &lumberjack.Logger{
+				Filename:   opts.File,
+				MaxSize:    opts.MaxSizeMB,
+				MaxBackups: opts.MaxBackups,
+				MaxAge:     opts.MaxAgeDays,
+			},
+			stop: make(chan struct{}),
+		}
+		if opts.RotateEvery > 0 {
+			go file.rotateEvery(opts.RotateEvery)
+		}
+		out, closer = file, file
+	}
+	log.SetOutput(out)
+
+	// libraries still using the std logger go through the same output
+	stdlog.SetFlags(0)
+	stdlog.SetOutput(log.StandardLogger().WriterLevel(log.InfoLevel))
+	return closer, nil
+}
+
+type rotatingFile struct {
+	*lumberjack.Logger
+	stop chan struct{}
+}
+
+func (file *rotatingFile) rotateEvery(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-file.stop:
+			return
+		case <-ticker.C:
+			if err := file.Rotate(); err != nil {
+				log.WithError(err).Error("cannot rotate log file")
+			}
+		}
+	}
+}
+
+// Close stop time based rotation and close current file
+func (file *rotatingFile) Close() error {
+	close(file.stop)
+	return file.Logger.Close()
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,116 @@
+package logger
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	recentSize = 2000
+	// accessField mark the access log entries, see Access
+	accessField = "access"
+)
+
+// Access mark entry as an access log line. Only the requests failing with an
+// error level are kept in Recent, so the traffic does not evict the errors.
+func Access(entry *log.Entry) *log.Entry {
+	return entry.WithField(accessField, true)
+}
+
+// Entry a log line kept in memory for the admin api
+type Entry struct {
+	Time    time.Time              `json:"time"`
+	Level   string                 `json:"level"`
+	Message string                 `json:"msg"`
+	Fields  map[string]interface{} `json:"fields,omitempty"`
+
+	level log.Level
+	text  string
+}
+
+// Ring is a logrus hook keeping the last entries in a fixed size buffer
+type Ring struct {
+	mu      sync.RWMutex
+	entries []Entry
+	next    int
+	full    bool
+}
+
+// NewRing make a ring buffer holding size entries
+func NewRing(size int) *Ring {
+	return &Ring{entries: make([]Entry, size)}
+}
+
+// Levels implement log.Hook
+func (ring *Ring) Levels() []log.Level {
+	return log.AllLevels
+}
+
+// Fire implement log.Hook
+func (ring *Ring) Fire(e *log.Entry) error {
+	if access, _ := e.Data[accessField].(bool); access && e.Level > log.ErrorLevel {
+		return nil
+	}
+	fields := make(map[string]interface{}, len(e.Data))
+	var text strings.Builder
+	text.WriteString(e.Message)
+	for k, v := range e.Data {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		fields[k] = v
+		fmt.Fprintf(&text, " %s=%v", k, v)
+	}
+	entry := Entry{
+		Time:    e.Time,
+		Level:   e.Level.String(),
+		Message: e.Message,
+		Fields:  fields,
+		level:   e.Level,
+		text:    strings.ToLower(text.String()),
+	}
+
+	ring.mu.Lock()
+	defer ring.mu.Unlock()
+	ring.entries[ring.next] = entry
+	ring.next = (ring.next + 1) % len(ring.entries)
+	if ring.next == 0 {
+		ring.full = true
+	}
+	return nil
+}
+
+// Tail return up to limit most recent entries at or above minLevel whose
+// message or fields contain substr, oldest first
+func (ring *Ring) Tail(minLevel log.Level, substr string, limit int) []Entry {
+	ring.mu.RLock()
+	defer ring.mu.RUnlock()
+	substr = strings.ToLower(substr)
+	size := ring.next
+	if ring.full {
+		size = len(ring.entries)
+	}
+	result := make([]Entry, 0)
+	for i := 1; i <= size && len(result) < limit; i++ {
+		entry := ring.entries[(ring.next-i+len(ring.entries))%len(ring.entries)]
+		// lower logrus level is more severe
+		if entry.level > minLevel {
+			continue
+		}
+		if substr != "" && !strings.Contains(entry.text, substr) {
+			continue
+		}
+		result = append(result, entry)
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// Recent hold entries logged since Setup
+var Recent = NewRing(recentSize)
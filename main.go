@@ -1,260 +1,199 @@
 package main
 
 import (
-	"fmt"
-	"log"
+	"context"
 	"os"
+	"os/signal"
 	"runtime"
+	"syscall"
 	"time"
 
-	"github.com/marknguyen85/server-api/common"
-	"github.com/marknguyen85/server-api/fetcher"
+	"github.com/marknguyen85/server-api/config"
 	"github.com/marknguyen85/server-api/http"
+	"github.com/marknguyen85/server-api/httpclient"
+	"github.com/marknguyen85/server-api/logger"
+	"github.com/marknguyen85/server-api/mock"
 	persister "github.com/marknguyen85/server-api/persister"
-	"github.com/marknguyen85/server-api/tomochain"
+	"github.com/marknguyen85/server-api/pipeline"
+	"github.com/marknguyen85/server-api/ratelimit"
+	"github.com/marknguyen85/server-api/replay"
+	"github.com/marknguyen85/server-api/scheduler"
+	"github.com/marknguyen85/server-api/stream"
+	"github.com/marknguyen85/server-api/webhook"
+	log "github.com/sirupsen/logrus"
 )
 
-type fetcherFunc func(persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher)
-
-func enableLogToFile() (*os.File, error) {
-	const logFileName = "log/error.log"
-	f, err := os.OpenFile(logFileName, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
-	if err != nil {
-		return nil, err
-	}
-
-	//clear error log file
-	if err = f.Truncate(0); err != nil {
-		log.Fatal(err)
+// loggerOptions map the log config, the file is rotated daily or when it reach MaxSizeMB
+func loggerOptions(cfg config.Log) logger.Options {
+	return logger.Options{
+		Level:       cfg.Level,
+		ToStdout:    cfg.ToStdout,
+		File:        cfg.File,
+		MaxSizeMB:   cfg.MaxSizeMB,
+		MaxBackups:  cfg.MaxBackups,
+		MaxAgeDays:  cfg.MaxAgeDays,
+		RotateEvery: 24 * time.Hour,
 	}
+}
 
-	log.SetOutput(f)
-	return f, nil
+func httpClientOptions(cfg config.HTTPClient) httpclient.Options {
+	options := httpclient.DefaultOptions()
+	options.Timeout = cfg.Timeout.Std()
+	options.Retries = cfg.Retries
+	options.MaxBackoff = cfg.MaxBackoff.Std()
+	options.BreakerFailures = cfg.BreakerFailures
+	options.BreakerCooldown = cfg.BreakerCooldown.Std()
+	options.HostTimeouts = make(map[string]time.Duration, len(cfg.HostTimeouts))
+	for host, timeout := range cfg.HostTimeouts {
+		options.HostTimeouts[host] = timeout.Std()
+	}
+	return options
 }
 
 func main() {
 	numCPU := runtime.NumCPU()
 	runtime.GOMAXPROCS(numCPU)
-	//set log for server
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-
-	if os.Getenv("LOG_TO_STDOUT") != "true" {
-		f, err := enableLogToFile()
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer f.Close()
-	}
-
-	chainTexENV := os.Getenv("CHAINTEX_ENV")
-	persisterIns, _ := persister.NewPersister("ram")
-	boltIns, err := persister.NewBoltStorage()
-	// boltIns, err := persister.NewInfluxStorage()
-	if err != nil {
-		log.Println("cannot init db: ", err.Error())
-	}
-	fertcherIns, err := fetcher.NewFetcher(chainTexENV)
+	cfg, err := config.Load(os.Args[1:])
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	err = fertcherIns.TryUpdateListToken()
+	//set log for server
+	logCloser, err := logger.Setup(loggerOptions(cfg.Log))
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
 	}
+	defer logCloser.Close()
 
-	tickerUpdateToken := time.NewTicker(300 * time.Second)
-	go func() {
-		for {
-			<-tickerUpdateToken.C
-			fertcherIns.TryUpdateListToken()
-		}
-	}()
-	var (
-		initRate   []tomochain.Rate
-		tomoSymbol = common.TOMOSymbol
-	)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
-	for symbol := range fertcherIns.GetListToken() {
-		if symbol == tomoSymbol {
-			tomoRate := tomochain.Rate{
-				Source:  tomoSymbol,
-				Dest:    tomoSymbol,
-				Rate:    "0",
-				Minrate: "0",
-			}
-			initRate = append(initRate, tomoRate, tomoRate)
-		} else {
-			buyRate := tomochain.Rate{
-				Source:  tomoSymbol,
-				Dest:    symbol,
-				Rate:    "0",
-				Minrate: "0",
-			}
-			sellRate := tomochain.Rate{
-				Source:  symbol,
-				Dest:    tomoSymbol,
-				Rate:    "0",
-				Minrate: "0",
-			}
-			initRate = append(initRate, buyRate, sellRate)
-		}
-	}
-	persisterIns.SaveRate(initRate, 0)
-	tokenNum := fertcherIns.GetNumTokens()
-	bonusTimeWait := 900
-	if tokenNum > 200 {
-		bonusTimeWait = 60
+	log.WithFields(log.Fields{
+		"env":      cfg.Env,
+		"config":   cfg.Path,
+		"networks": cfg.NetworkNames(),
+		"default":  cfg.DefaultNetwork,
+	}).Info("config loaded")
+	if cfg.AdminToken == "" && cfg.AdminSecret == "" {
+		log.Warn("admin_token and admin_secret are empty: /admin is not served")
 	}
-	intervalFetchGeneralInfoTokens := time.Duration((tokenNum * 7) + bonusTimeWait)
-
-	runFetchData(persisterIns, boltIns, fetchRateUSD, fertcherIns, 300) //5 minutes
-
-	runFetchData(persisterIns, boltIns, fetchGeneralInfoTokens, fertcherIns, intervalFetchGeneralInfoTokens)
-
-	runFetchData(persisterIns, boltIns, fetchRate7dData, fertcherIns, 300) //5 minutes
-
-	runFetchData(persisterIns, boltIns, fetchRate, fertcherIns, 15) //15 seconds
-	runFetchData(persisterIns, boltIns, fetchRateWithFallback, fertcherIns, 300)
-	//run server
-	server := http.NewHTTPServer(":3001", persisterIns, fertcherIns)
-	server.Run(chainTexENV)
-
-}
-
-func runFetchData(persister persister.Persister, boltIns persister.BoltInterface, fn fetcherFunc, fertcherIns *fetcher.Fetcher, interval time.Duration) {
-	ticker := time.NewTicker(interval * time.Second)
-	go func() {
-		for {
-			fn(persister, boltIns, fertcherIns)
-			<-ticker.C
-		}
-	}()
-}
 
-func fetchRateUSD(persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
-	rateUSD, err := fetcher.GetRateUsdTomo()
+	boltIns, err := persister.NewBoltStorage(cfg.BoltPath)
+	// boltIns, err := persister.NewInfluxStorage()
 	if err != nil {
-		log.Print(err)
-		persister.SetNewRateUSD(false)
-		return
-	}
-
-	if rateUSD == "" {
-		persister.SetNewRateUSD(false)
-		return
+		log.WithError(err).Error("cannot init db")
 	}
-
-	err = persister.SaveRateUSD(rateUSD)
+	clientOptions := httpClientOptions(cfg.HTTPClient)
+	clientOptions.Transport, err = replay.Transport(cfg.Upstream.Mode, cfg.Upstream.Fixtures)
 	if err != nil {
-		log.Print(err)
-		persister.SetNewRateUSD(false)
-		return
-	}
-}
-
-func makeMapRate(rates []tomochain.Rate) map[string]tomochain.Rate {
-	mapRate := make(map[string]tomochain.Rate)
-	for _, r := range rates {
-		mapRate[fmt.Sprintf("%s_%s", r.Source, r.Dest)] = r
-	}
-	return mapRate
-}
-
-func fetchGeneralInfoTokens(persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
-	generalInfo := fetcher.GetGeneralInfoTokens()
-	persister.SaveGeneralInfoTokens(generalInfo)
-	err := boltIns.StoreGeneralInfo(generalInfo)
+		log.WithError(err).Fatal("cannot load upstream fixtures")
+	}
+	// synthetic upstreams and chain, the networks read them as the real ones
+	if cfg.Mocked() {
+		market := mock.NewMarket(cfg.Mock)
+		clientOptions.Transport = market.Upstream(cfg.Chains())
+		market.Seed(cfg.Chains())
+		go market.Run(ctx, cfg.Mock.Step.Std(), cfg.Chains())
+		log.WithFields(log.Fields{
+			"seed":   cfg.Mock.Seed,
+			"tokens": cfg.Mock.Tokens,
+			"step":   cfg.Mock.Step.Std().String(),
+		}).Warn("mock environment, serving synthetic data")
+	}
+	if cfg.Upstream.Mode != "" {
+		log.WithFields(log.Fields{
+			"mode":     cfg.Upstream.Mode,
+			"fixtures": cfg.Upstream.Fixtures,
+		}).Warn("upstream calls are recorded or replayed")
+	}
+	httpclient.SetDefault(httpclient.New(clientOptions))
+
+	limits, err := ratelimit.New(cfg.RateLimit)
 	if err != nil {
-		log.Println(err.Error())
+		log.WithError(err).Fatal("cannot init rate limit")
 	}
-}
+	defer limits.Close()
 
-func fetchRate7dData(persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
-	data, err := fetcher.FetchRate7dData()
-	if err != nil {
-		log.Print(err)
-		if !persister.IsFailedToFetchTracker() {
-			return
+	// webhooks are not upstreams: posted for real, never recorded nor replayed
+	notifier := webhook.New(cfg.Webhooks, httpclient.New(httpClientOptions(cfg.HTTPClient)))
+	go notifier.Run(ctx)
+
+	hub := stream.NewHub(cfg.StreamBuffer)
+	chains := cfg.Chains()
+	networks := make([]*pipeline.Network, 0, len(chains))
+	for _, name := range cfg.NetworkNames() {
+		n, err := pipeline.NewNetwork(name, chains[name], boltIns, name == cfg.DefaultNetwork, hub, notifier)
+		if err != nil {
+			log.WithField("network", name).WithError(err).Fatal("cannot init network")
 		}
-		persister.SetIsNewTrackerData(false)
-	} else {
-		persister.SetIsNewTrackerData(true)
+		networks = append(networks, n)
 	}
-	mapToken := fetcher.GetListToken()
-	currentGeneral, err := boltIns.GetGeneralInfo(mapToken)
-	if err != nil {
-		log.Println(err.Error())
-		currentGeneral = make(map[string]*tomochain.TokenGeneralInfo)
-	}
-	persister.SaveMarketData(data, currentGeneral, mapToken)
-	// persister.SetIsNewMarketInfo(true)
-}
 
-func fetchRate(persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
-	timeNow := time.Now().UTC().Unix()
-	var result []tomochain.Rate
-	currentRate := persister.GetRate()
-	tokenPriority := fetcher.GetListTokenPriority()
-	rates, err := fetcher.GetRate(currentRate, persister.GetIsNewRate(), tokenPriority, false)
-	if err != nil {
-		log.Print(err)
-		persister.SetIsNewRate(false)
-		return
+	// connections and backup tokens follow the config file, SIGHUP and /admin/reload
+	watcher := config.NewWatcher(cfg, os.Args[1:], func(newCfg *config.Config) error {
+		return pipeline.Reload(networks, newCfg)
+	})
+	if cfg.ReloadInterval > 0 {
+		go watcher.Watch(ctx, cfg.ReloadInterval.Std())
 	}
-	mapRate := makeMapRate(rates)
-	for _, cr := range currentRate {
-		keyRate := fmt.Sprintf("%s_%s", cr.Source, cr.Dest)
-		if r, ok := mapRate[keyRate]; ok {
-			result = append(result, r)
-			delete(mapRate, keyRate)
-		} else {
-			result = append(result, cr)
+
+	// the certificate follow its files and SIGHUP
+	var cert *http.Certificate
+	if cfg.TLS.CertFile != "" {
+		cert, err = http.NewCertificate(cfg.TLS.CertFile, cfg.TLS.KeyFile)
+		if err != nil {
+			log.WithError(err).Fatal("cannot load certificate")
 		}
-	}
-	// add new token to current rate
-	if len(mapRate) > 0 {
-		for _, nr := range mapRate {
-			result = append(result, nr)
+		if cfg.ReloadInterval > 0 {
+			go cert.Watch(ctx, cfg.ReloadInterval.Std())
 		}
 	}
-	persister.SaveRate(result, timeNow)
-	persister.SetIsNewRate(true)
-}
 
-func fetchRateWithFallback(persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
-	var result []tomochain.Rate
-	currentRate := persister.GetRate()
-	listToken := fetcher.GetListToken()
-	newList := make(map[string]tomochain.Token)
-	for _, t := range listToken {
-		if !t.Priority {
-			newList[t.Symbol] = t
-		}
-	}
-	rates, err := fetcher.GetRate(currentRate, persister.GetIsNewRate(), newList, true)
-	if err != nil {
-		log.Print(err)
-		persister.SetIsNewRate(false)
-		return
-	}
-	mapRate := makeMapRate(rates)
-	for _, cr := range currentRate {
-		keyRate := fmt.Sprintf("%s_%s", cr.Source, cr.Dest)
-		if r, ok := mapRate[keyRate]; ok {
-			result = append(result, r)
-			if keyRate != "TOMO_TOMO" {
-				delete(mapRate, keyRate)
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		for sig := range sigs {
+			if sig == syscall.SIGHUP {
+				if err := watcher.Reload(); err != nil {
+					log.WithError(err).Error("cannot reload config")
+				}
+				if err := cert.Reload(); err != nil {
+					log.WithError(err).Error("cannot reload certificate")
+				}
+				continue
 			}
-		} else {
-			result = append(result, cr)
+			log.WithField("signal", sig.String()).Info("shutting down")
+			cancel()
+			return
 		}
+	}()
+
+	sched := scheduler.NewScheduler()
+	served := make([]*http.Network, 0, len(networks))
+	for _, n := range networks {
+		n.Schedule(ctx, sched, cfg.Intervals, cfg.Verification)
+		served = append(served, n.Network)
 	}
-	// add new token to current rate
-	if len(mapRate) > 1 {
-		for _, nr := range mapRate {
-			result = append(result, nr)
+	sched.Start(ctx)
+
+	//run server
+	server := http.NewHTTPServer(cfg.ListenAddr, served, cfg.DefaultNetwork, sched, cfg.MaxDataAge.Std(), cfg.AdminToken, cfg.AdminSecret, watcher, hub, limits, cfg.CORS)
+	if cert != nil {
+		server.EnableTLS(cert, cfg.TLS.RedirectAddr)
+	}
+	// validated with the config
+	proxies, _ := cfg.RateLimit.Proxies()
+	server.TrustProxies(proxies)
+	if err := server.Run(ctx); err != nil {
+		log.WithError(err).Error("http server stopped")
+	}
+
+	// server stopped: stop fetchers and wait for running jobs before closing db
+	cancel()
+	sched.Wait()
+	if boltIns != nil {
+		if err := boltIns.Close(); err != nil {
+			log.WithError(err).Error("cannot close db")
 		}
 	}
-	persister.SaveRate(result, 0)
 }
@@ -0,0 +1,133 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "chaintex_cache"
+
+var (
+	// Registry hold every metric exposed on /metrics
+	Registry = prometheus.NewRegistry()
+
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+
+	upstreamCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_calls_total",
+		Help:      "Number of calls to upstream services by fetcher type.",
+	}, []string{"fetcher"})
+
+	upstreamErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_errors_total",
+		Help:      "Number of failed calls to upstream services by fetcher type.",
+	}, []string{"fetcher"})
+
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_call_duration_seconds",
+		Help:      "Latency of calls to upstream services by fetcher type.",
+		Buckets:   []float64{.05, .1, .25, .5, 1, 2.5, 5, 10},
+	}, []string{"fetcher"})
+
+	upstreamRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_retries_total",
+		Help:      "Number of retried calls to upstream http services by host.",
+	}, []string{"host"})
+
+	upstreamCircuitOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "upstream_circuit_open",
+		Help:      "1 while the circuit breaker of an upstream host is open.",
+	}, []string{"host"})
+
+	hedgedCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "hedged_calls_total",
+		Help:      "Number of chain calls sent to another connection after the p95 delay, by fetcher type of the slow one.",
+	}, []string{"fetcher"})
+
+	verificationMismatches = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "verification_mismatches_total",
+		Help:      "Number of verifications where a connection answered unlike the others, by fetcher type.",
+	}, []string{"fetcher"})
+)
+
+func init() {
+	Registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		httpRequests,
+		httpDuration,
+		upstreamCalls,
+		upstreamErrors,
+		upstreamDuration,
+		upstreamRetries,
+		upstreamCircuitOpen,
+		hedgedCalls,
+		verificationMismatches,
+	)
+}
+
+// Handler serve metrics in Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{})
+}
+
+// ObserveHTTP record a served request, route is the registered path not the raw url
+func ObserveHTTP(route, method string, code int, start time.Time) {
+	httpRequests.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+	httpDuration.WithLabelValues(route, method).Observe(time.Since(start).Seconds())
+}
+
+// ObserveRetry record a call to host made again after a failure
+func ObserveRetry(host string) {
+	upstreamRetries.WithLabelValues(host).Inc()
+}
+
+// SetCircuitOpen record the state of the circuit breaker of host
+func SetCircuitOpen(host string, open bool) {
+	value := 0.0
+	if open {
+		value = 1
+	}
+	upstreamCircuitOpen.WithLabelValues(host).Set(value)
+}
+
+// ObserveHedge record a chain call sent again because a connection of fetcherType was slow
+func ObserveHedge(fetcherType string) {
+	hedgedCalls.WithLabelValues(fetcherType).Inc()
+}
+
+// ObserveMismatch record a connection of fetcherType disagreeing with the others
+func ObserveMismatch(fetcherType string) {
+	verificationMismatches.WithLabelValues(fetcherType).Inc()
+}
+
+// ObserveUpstream record a call to node, tomoscan, coingecko, cmc or http endpoints
+func ObserveUpstream(fetcherType string, start time.Time, err error) {
+	upstreamCalls.WithLabelValues(fetcherType).Inc()
+	upstreamDuration.WithLabelValues(fetcherType).Observe(time.Since(start).Seconds())
+	if err != nil {
+		upstreamErrors.WithLabelValues(fetcherType).Inc()
+	}
+}
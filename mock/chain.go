@@ -0,0 +1,84 @@
+package mock
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/marknguyen85/server-api/config"
+	bFetcher "github.com/marknguyen85/server-api/fetcher/blockchain-fetcher"
+	log "github.com/sirupsen/logrus"
+)
+
+// maxTrades is the most trades made on each network at every step
+const maxTrades = 2
+
+// simulations return the simulated networks the connections of chains read,
+// in the order of the chain names so the market draw the same trades for each
+func simulations(chains map[string]config.Chain) []*bFetcher.SimulatedNetwork {
+	names := make([]string, 0, len(chains))
+	for name := range chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	seen := make(map[*bFetcher.SimulatedNetwork]bool)
+	var networks []*bFetcher.SimulatedNetwork
+	for _, name := range names {
+		for _, connection := range chains[name].Connections {
+			if connection.Type != "simulated" {
+				continue
+			}
+			network := bFetcher.SimulationOf(connection.Endpoint)
+			if !seen[network] {
+				seen[network] = true
+				networks = append(networks, network)
+			}
+		}
+	}
+	return networks
+}
+
+// Seed set the rates of the market on the simulated networks of chains
+func (market *Market) Seed(chains map[string]config.Chain) {
+	rates := market.Rates()
+	for _, network := range simulations(chains) {
+		network.SetRates(rates)
+	}
+}
+
+// Run move the market every step and apply it to the simulated networks of
+// chains, with a few trades, until ctx is done. A zero step keep the seeded
+// market, the same for every run of a seed.
+func (market *Market) Run(ctx context.Context, step time.Duration, chains map[string]config.Chain) {
+	if step <= 0 {
+		return
+	}
+	networks := simulations(chains)
+	ticker := time.NewTicker(step)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		market.Step()
+		rates := market.Rates()
+		for _, network := range networks {
+			network.SetRates(rates)
+			for i := market.intn(maxTrades + 1); i > 0; i-- {
+				trader, src, dest, srcAmount, destAmount := market.trade()
+				if _, err := network.Trade(trader, src, dest, srcAmount, destAmount); err != nil {
+					log.WithError(err).Warn("cannot make mock trade")
+				}
+			}
+		}
+	}
+}
+
+func (market *Market) intn(n int) int {
+	market.mu.Lock()
+	defer market.mu.Unlock()
+	return market.rng.Intn(n)
+}
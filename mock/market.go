@@ -0,0 +1,227 @@
+// Package mock generate the data of the mock environment: a token list,
+// rates moving by a random walk, market info and a 7 day history, all
+// derived from a seed so the same seed serve the same data.
+package mock
+
+import (
+	"encoding/hex"
+	"fmt"
+	"math"
+	"math/big"
+	"math/rand"
+	"strings"
+	"sync"
+
+	"github.com/marknguyen85/server-api/common"
+	"github.com/marknguyen85/server-api/config"
+	bFetcher "github.com/marknguyen85/server-api/fetcher/blockchain-fetcher"
+	"github.com/marknguyen85/server-api/tomochain"
+)
+
+const (
+	// historyPoints is the 7 day history, a point every 6 hours
+	historyPoints = 29
+	// pointsPerDay of the history, to compute the 24h change
+	pointsPerDay = 4
+	// slippage is the share of the expected rate kept by the slippage rate
+	slippage = 0.97
+)
+
+// decimals of the generated tokens, most of them 18 as on chain
+var decimals = []int{18, 18, 18, 8, 6}
+
+// token is a generated token and its market
+type token struct {
+	tomochain.Token
+	// price in TOMO
+	price   float64
+	supply  float64
+	volume  float64
+	history []float64
+}
+
+// Market is the synthetic market of the mock environment. Every value come
+// from its seeded source, in a fixed order, so a seed always give the same
+// market after the same number of steps.
+type Market struct {
+	mu      sync.RWMutex
+	rng     *rand.Rand
+	tokens  []*token
+	tomoUSD float64
+	steps   int
+}
+
+// NewMarket generate settings.Tokens tokens, besides TOMO, with their price
+// and history
+func NewMarket(settings config.Mock) *Market {
+	market := &Market{rng: rand.New(rand.NewSource(int64(settings.Seed)))}
+	market.tomoUSD = 0.3 + market.rng.Float64()
+
+	used := map[string]bool{common.TOMOSymbol: true}
+	for i := 0; i < settings.Tokens; i++ {
+		symbol := market.symbol(used)
+		address := make([]byte, 20)
+		market.rng.Read(address)
+		t := &token{
+			Token: tomochain.Token{
+				Name:     symbol + " Token",
+				Symbol:   symbol,
+				Address:  "0x" + hex.EncodeToString(address),
+				Decimal:  decimals[market.rng.Intn(len(decimals))],
+				CGId:     "mock-" + strings.ToLower(symbol),
+				Priority: i < (settings.Tokens+1)/2,
+			},
+			// from 0.001 to 1000 TOMO
+			price:  math.Pow(10, market.rng.Float64()*6-3),
+			supply: math.Pow(10, 6+market.rng.Float64()*3),
+		}
+		t.volume = t.supply * (0.001 + market.rng.Float64()*0.05)
+		// walk the history back from the current price
+		t.history = make([]float64, historyPoints)
+		t.history[historyPoints-1] = t.price
+		for j := historyPoints - 2; j >= 0; j-- {
+			t.history[j] = t.history[j+1] * math.Exp(market.rng.NormFloat64()*0.03)
+		}
+		market.tokens = append(market.tokens, t)
+	}
+	return market
+}
+
+// symbol draw a symbol of 3 or 4 letters not in used
+func (market *Market) symbol(used map[string]bool) string {
+	for {
+		letters := make([]byte, 3+market.rng.Intn(2))
+		for i := range letters {
+			letters[i] = byte('A' + market.rng.Intn(26))
+		}
+		if symbol := string(letters); !used[symbol] {
+			used[symbol] = true
+			return symbol
+		}
+	}
+}
+
+// Step move every price by a random walk, TOMO included
+func (market *Market) Step() {
+	market.mu.Lock()
+	defer market.mu.Unlock()
+	market.tomoUSD *= math.Exp(market.rng.NormFloat64() * 0.005)
+	for _, t := range market.tokens {
+		t.price *= math.Exp(market.rng.NormFloat64() * 0.01)
+		t.history[historyPoints-1] = t.price
+	}
+	market.steps++
+}
+
+// Tokens return the token list, TOMO first
+func (market *Market) Tokens() []tomochain.Token {
+	market.mu.RLock()
+	defer market.mu.RUnlock()
+	tokens := []tomochain.Token{{
+		Name:     "TomoChain",
+		Symbol:   common.TOMOSymbol,
+		Address:  common.TOMOAddr,
+		Decimal:  18,
+		CGId:     "tomochain",
+		Priority: true,
+	}}
+	for _, t := range market.tokens {
+		tokens = append(tokens, t.Token)
+	}
+	return tokens
+}
+
+// TomoUSD return the price of TOMO in USD
+func (market *Market) TomoUSD() float64 {
+	market.mu.RLock()
+	defer market.mu.RUnlock()
+	return market.tomoUSD
+}
+
+// History return the current price and the 7 day history of every token, in
+// TOMO, as the tracker api
+func (market *Market) History() map[string]*tomochain.Rates {
+	market.mu.RLock()
+	defer market.mu.RUnlock()
+	result := make(map[string]*tomochain.Rates, len(market.tokens))
+	for _, t := range market.tokens {
+		result[t.Symbol] = &tomochain.Rates{
+			R: t.price,
+			P: append([]float64(nil), t.history...),
+		}
+	}
+	return result
+}
+
+// Info return the market info of the token of coingecko id, as coingecko
+func (market *Market) Info(cgID string) (tomochain.TokenInfoCoinGecko, bool) {
+	market.mu.RLock()
+	defer market.mu.RUnlock()
+	var info tomochain.TokenInfoCoinGecko
+	for _, t := range market.tokens {
+		if t.CGId != cgID {
+			continue
+		}
+		dayBefore := t.history[historyPoints-1-pointsPerDay]
+		info.MarketData.MarketCap = tomochain.CurrencyData{
+			TOMO: t.supply * t.price,
+			USD:  t.supply * t.price * market.tomoUSD,
+		}
+		info.MarketData.Volume24H = tomochain.CurrencyData{
+			TOMO: t.volume * t.price,
+			USD:  t.volume * t.price * market.tomoUSD,
+		}
+		info.MarketData.Change24H = fmt.Sprintf("%.6f", (t.price/dayBefore-1)*100)
+		return info, true
+	}
+	return info, false
+}
+
+// Rates return the rates of the network contract between TOMO and every
+// token, in both directions, and of TOMO to itself
+func (market *Market) Rates() []bFetcher.SimulatedRate {
+	market.mu.RLock()
+	defer market.mu.RUnlock()
+	rates := make([]bFetcher.SimulatedRate, 0, 2*len(market.tokens)+1)
+	rates = append(rates, simulatedRate(common.TOMOAddr, common.TOMOAddr, 1))
+	for _, t := range market.tokens {
+		rates = append(rates,
+			simulatedRate(t.Address, common.TOMOAddr, t.price),
+			simulatedRate(common.TOMOAddr, t.Address, 1/t.price))
+	}
+	return rates
+}
+
+// trade draw a trade between TOMO and a random token at its price, amounts
+// in wei
+func (market *Market) trade() (trader, src, dest string, srcAmount, destAmount *big.Int) {
+	market.mu.Lock()
+	defer market.mu.Unlock()
+	address := make([]byte, 20)
+	market.rng.Read(address)
+	t := market.tokens[market.rng.Intn(len(market.tokens))]
+	// from 1 to 1000 TOMO
+	tomo := math.Pow(10, market.rng.Float64()*3)
+	tomoWei, tokenWei := toWei(tomo, 18), toWei(tomo/t.price, t.Decimal)
+	if market.rng.Intn(2) == 0 {
+		return "0x" + hex.EncodeToString(address), common.TOMOAddr, t.Address, tomoWei, tokenWei
+	}
+	return "0x" + hex.EncodeToString(address), t.Address, common.TOMOAddr, tokenWei, tomoWei
+}
+
+// simulatedRate is the getExpectedRate answer from src to dest at rate
+func simulatedRate(src, dest string, rate float64) bFetcher.SimulatedRate {
+	return bFetcher.SimulatedRate{
+		Src:      src,
+		Dest:     dest,
+		Expected: toWei(rate, 18),
+		Slippage: toWei(rate*slippage, 18),
+	}
+}
+
+// toWei convert amount to its integer value with decimals
+func toWei(amount float64, decimals int) *big.Int {
+	unit := new(big.Float).SetInt(new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(decimals)), nil))
+	wei, _ := new(big.Float).Mul(big.NewFloat(amount), unit).Int(nil)
+	return wei
+}
@@ -0,0 +1,118 @@
+package mock
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/marknguyen85/server-api/common"
+	"github.com/marknguyen85/server-api/config"
+	"github.com/marknguyen85/server-api/tomochain"
+)
+
+// coingeckoCoins is the coingecko api the market fetcher read
+const coingeckoCoins = "https://api.coingecko.com/api/v3/coins/"
+
+// answer compute the body of a mocked upstream
+type answer func(market *Market) interface{}
+
+// Upstream answer the http calls of the networks from the market, without
+// network: the token list, tracker, token price and gas station endpoints
+// of every chain, and coingecko. Other urls are not found.
+type Upstream struct {
+	market *Market
+	routes map[string]answer
+}
+
+// Upstream return the transport answering the endpoints of chains
+func (market *Market) Upstream(chains map[string]config.Chain) *Upstream {
+	upstream := &Upstream{market: market, routes: make(map[string]answer)}
+	for _, chain := range chains {
+		upstream.route(chain.ConfigEndpoint, tokenList)
+		if chain.APIEndpoint != "" {
+			upstream.route(chain.APIEndpoint+"/rates7d", history)
+			upstream.route(chain.APIEndpoint+"/token_price", tokenPrice)
+		}
+		upstream.route(chain.GasStationEndpoint, gasStation)
+	}
+	return upstream
+}
+
+func (upstream *Upstream) route(endpoint string, fn answer) {
+	if endpoint != "" {
+		upstream.routes[withoutQuery(endpoint)] = fn
+	}
+}
+
+// RoundTrip implement http.RoundTripper
+func (upstream *Upstream) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Body != nil {
+		req.Body.Close()
+	}
+	rawURL := withoutQuery(req.URL.String())
+	if fn, ok := upstream.routes[rawURL]; ok {
+		return respond(req, http.StatusOK, fn(upstream.market))
+	}
+	if strings.HasPrefix(rawURL, coingeckoCoins) {
+		id := strings.TrimPrefix(rawURL, coingeckoCoins)
+		if id == "tomochain" {
+			var price tomochain.RateUSDCG
+			price.MarketData.CurrentPrice.USD = upstream.market.TomoUSD()
+			return respond(req, http.StatusOK, price)
+		}
+		if info, ok := upstream.market.Info(id); ok {
+			return respond(req, http.StatusOK, info)
+		}
+	}
+	return respond(req, http.StatusNotFound, map[string]string{"error": "no mock for " + rawURL})
+}
+
+func tokenList(market *Market) interface{} {
+	return tomochain.TokenConfig{Success: true, Data: market.Tokens()}
+}
+
+func history(market *Market) interface{} {
+	return market.History()
+}
+
+func tokenPrice(market *Market) interface{} {
+	return map[string]interface{}{
+		"error": false,
+		"data": []map[string]interface{}{
+			{"symbol": common.TOMOSymbol, "price": market.TomoUSD()},
+		},
+	}
+}
+
+// gasStation answer fixed prices, in tenth of gwei as the gas station api
+func gasStation(market *Market) interface{} {
+	return map[string]float64{"fast": 100, "average": 50, "safeLow": 10}
+}
+
+func withoutQuery(rawURL string) string {
+	if i := strings.IndexByte(rawURL, '?'); i >= 0 {
+		return rawURL[:i]
+	}
+	return rawURL
+}
+
+func respond(req *http.Request, status int, body interface{}) (*http.Response, error) {
+	data, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        http.Header{"Content-Type": {"application/json"}},
+		Body:          ioutil.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		Request:       req,
+	}, nil
+}
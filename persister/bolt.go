@@ -1,25 +1,33 @@
 package persister
 
 import (
+	"encoding/binary"
 	"encoding/json"
-	"log"
 
 	"github.com/boltdb/bolt"
 	"github.com/marknguyen85/server-api/tomochain"
 )
 
 const (
-	path   = "./persister/db/market.db"
 	bucket = "market_info"
+	// tokensBucket hold the token list of the last refresh and, in its
+	// changes bucket, the changes between refreshes
+	tokensBucket  = "tokens"
+	changesBucket = "changes"
+	snapshotKey   = "snapshot"
+	// MaxTokenChanges is the most changes kept, the oldest are dropped
+	MaxTokenChanges = 10000
 )
 
 // BoltStorage storage for cache
 type BoltStorage struct {
 	marketDB *bolt.DB
+	bucket   string
+	tokens   string
 }
 
-// NewBoltStorage make bolt instance
-func NewBoltStorage() (*BoltStorage, error) {
+// NewBoltStorage make bolt instance backed by the file at path
+func NewBoltStorage(path string) (*BoltStorage, error) {
 	marketDB, err := bolt.Open(path, 0600, nil)
 	if err != nil {
 		return nil, err
@@ -28,13 +36,48 @@ func NewBoltStorage() (*BoltStorage, error) {
 		if _, cErr := tx.CreateBucketIfNotExists([]byte(bucket)); cErr != nil {
 			return cErr
 		}
-		return nil
+		return createTokensBucket(tx, tokensBucket)
 	})
 	if err != nil {
 		return nil, err
 	}
 	return &BoltStorage{
 		marketDB: marketDB,
+		bucket:   bucket,
+		tokens:   tokensBucket,
+	}, nil
+}
+
+func createTokensBucket(tx *bolt.Tx, name string) error {
+	tokens, err := tx.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+	_, err = tokens.CreateBucketIfNotExists([]byte(changesBucket))
+	return err
+}
+
+// Namespace return a storage sharing the database but keeping its data in
+// the bucket of network. The empty name is the original bucket.
+func (bs *BoltStorage) Namespace(network string) (*BoltStorage, error) {
+	if network == "" {
+		return bs, nil
+	}
+	name := bucket + "_" + network
+	tokens := tokensBucket + "_" + network
+	err := bs.marketDB.Update(func(tx *bolt.Tx) error {
+		if _, cErr := tx.CreateBucketIfNotExists([]byte(name)); cErr != nil {
+			return cErr
+		}
+		return createTokensBucket(tx, tokens)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &BoltStorage{
+		marketDB: bs.marketDB,
+		bucket:   name,
+		tokens:   tokens,
 	}, nil
 }
 
@@ -43,7 +86,7 @@ func (bs *BoltStorage) StoreGeneralInfo(mapInfo map[string]*tomochain.TokenGener
 	var err error
 	err = bs.marketDB.Update(func(tx *bolt.Tx) error {
 		var errS error
-		b := tx.Bucket([]byte(bucket))
+		b := tx.Bucket([]byte(bs.bucket))
 		for k, v := range mapInfo {
 			var dataJSON []byte
 			dataJSON, errS = json.Marshal(*v)
@@ -58,7 +101,6 @@ func (bs *BoltStorage) StoreGeneralInfo(mapInfo map[string]*tomochain.TokenGener
 		return nil
 	})
 	if err != nil {
-		log.Println(err.Error())
 		return err
 	}
 	return nil
@@ -70,7 +112,7 @@ func (bs *BoltStorage) GetGeneralInfo(mapToken map[string]tomochain.Token) (map[
 	result := make(map[string]*tomochain.TokenGeneralInfo)
 	err = bs.marketDB.View(func(tx *bolt.Tx) error {
 		var errV error
-		b := tx.Bucket([]byte(bucket))
+		b := tx.Bucket([]byte(bs.bucket))
 		if errV = b.ForEach(func(k, v []byte) error {
 			var tokenInfo tomochain.TokenGeneralInfo
 			errLoop := json.Unmarshal(v, &tokenInfo)
@@ -80,7 +122,6 @@ func (bs *BoltStorage) GetGeneralInfo(mapToken map[string]tomochain.Token) (map[
 			result[string(k)] = &tokenInfo
 			return nil
 		}); errV != nil {
-			log.Println(errV.Error())
 			return errV
 		}
 		return nil
@@ -90,3 +131,98 @@ func (bs *BoltStorage) GetGeneralInfo(mapToken map[string]tomochain.Token) (map[
 	}
 	return result, nil
 }
+
+// GetTokenSnapshot return the token list stored with the last changes, ok is
+// false until a list is stored
+func (bs *BoltStorage) GetTokenSnapshot() (tokens map[string]tomochain.Token, ok bool, err error) {
+	err = bs.marketDB.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(bs.tokens)).Get([]byte(snapshotKey))
+		if data == nil {
+			return nil
+		}
+		ok = true
+		return json.Unmarshal(data, &tokens)
+	})
+	return tokens, ok, err
+}
+
+// StoreTokenChanges store changes, numbered from the last stored one, and
+// tokens, the list after them, at once. The changes are returned with their
+// number.
+func (bs *BoltStorage) StoreTokenChanges(tokens map[string]tomochain.Token, changes []tomochain.TokenChange) ([]tomochain.TokenChange, error) {
+	snapshot, err := json.Marshal(tokens)
+	if err != nil {
+		return nil, err
+	}
+	stored := make([]tomochain.TokenChange, 0, len(changes))
+	err = bs.marketDB.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(bs.tokens))
+		if errP := b.Put([]byte(snapshotKey), snapshot); errP != nil {
+			return errP
+		}
+		changesB := b.Bucket([]byte(changesBucket))
+		for _, change := range changes {
+			seq, errS := changesB.NextSequence()
+			if errS != nil {
+				return errS
+			}
+			change.Seq = seq
+			data, errS := json.Marshal(change)
+			if errS != nil {
+				return errS
+			}
+			if errS = changesB.Put(seqKey(seq), data); errS != nil {
+				return errS
+			}
+			stored = append(stored, change)
+		}
+		// drop the oldest changes past the limit, the stored seqs follow each
+		// other up to the last one
+		last := changesB.Sequence()
+		var old [][]byte
+		cursor := changesB.Cursor()
+		for k, _ := cursor.First(); k != nil && last-binary.BigEndian.Uint64(k) >= MaxTokenChanges; k, _ = cursor.Next() {
+			old = append(old, k)
+		}
+		for _, k := range old {
+			if errD := changesB.Delete(k); errD != nil {
+				return errD
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// GetTokenChanges return up to limit changes numbered after since, oldest
+// first
+func (bs *BoltStorage) GetTokenChanges(since uint64, limit int) ([]tomochain.TokenChange, error) {
+	changes := make([]tomochain.TokenChange, 0)
+	err := bs.marketDB.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket([]byte(bs.tokens)).Bucket([]byte(changesBucket)).Cursor()
+		for k, v := cursor.Seek(seqKey(since + 1)); k != nil && len(changes) < limit; k, v = cursor.Next() {
+			var change tomochain.TokenChange
+			if errU := json.Unmarshal(v, &change); errU != nil {
+				return errU
+			}
+			changes = append(changes, change)
+		}
+		return nil
+	})
+	return changes, err
+}
+
+// seqKey encode seq so keys sort by number
+func seqKey(seq uint64) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, seq)
+	return key
+}
+
+// Close release the database file lock, shared by every namespace
+func (bs *BoltStorage) Close() error {
+	return bs.marketDB.Close()
+}
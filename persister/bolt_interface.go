@@ -5,4 +5,8 @@ import "github.com/marknguyen85/server-api/tomochain"
 type BoltInterface interface {
 	StoreGeneralInfo(map[string]*tomochain.TokenGeneralInfo) error
 	GetGeneralInfo(map[string]tomochain.Token) (map[string]*tomochain.TokenGeneralInfo, error)
+	GetTokenSnapshot() (map[string]tomochain.Token, bool, error)
+	StoreTokenChanges(map[string]tomochain.Token, []tomochain.TokenChange) ([]tomochain.TokenChange, error)
+	GetTokenChanges(since uint64, limit int) ([]tomochain.TokenChange, error)
+	Close() error
 }
@@ -1,6 +1,7 @@
 package persister
 
 import (
+	"github.com/marknguyen85/server-api/stream"
 	"github.com/marknguyen85/server-api/tomochain"
 )
 
@@ -14,8 +15,11 @@ type Persister interface {
 	GetIsNewRate() bool
 	SetIsNewRate(bool)
 	GetTimeUpdateRate() int64
+	// GetRateBlock return the block the last rates were read at, empty when unknown
+	GetRateBlock() string
 
-	SaveRate([]tomochain.Rate, int64)
+	// SaveRate keep rates read at block, timestamp 0 keep the update time
+	SaveRate(rates []tomochain.Rate, block string, timestamp int64)
 
 	SaveGeneralInfoTokens(map[string]*tomochain.TokenGeneralInfo)
 	GetTokenInfo() map[string]*tomochain.TokenGeneralInfo
@@ -23,6 +27,7 @@ type Persister interface {
 	GetRateUSD() []RateUSD
 	GetRateTOMO() string
 	GetIsNewRateUSD() bool
+	GetTimeUpdateRateUSD() int64
 	SaveRateUSD(string) error
 	SetNewRateUSD(bool)
 
@@ -34,10 +39,22 @@ type Persister interface {
 	SetIsNewTrackerData(isNewTrackerData bool)
 	SetIsNewMarketInfo(isNewMarketInfo bool)
 	GetIsNewMarketInfo() bool
+	GetTimeUpdateMarketInfo() int64
 	// GetIsNewMarketInfoCG() bool
 	GetTimeVersion() string
+	// GetVersion return the version of a dataset, see the Dataset constants
+	GetVersion(dataset string) Version
 
 	IsFailedToFetchTracker() bool
+
+	GetLatestBlock() string
+	SaveLatestBlock(string) error
+	GetEvents() []tomochain.EventHistory
+	SaveEvents([]tomochain.EventHistory, map[string]tomochain.Token)
+	// SaveTokenChanges publish the recorded changes of the token list
+	SaveTokenChanges([]tomochain.TokenChange)
+
+	SetPublisher(stream.Publisher)
 }
 
 //var transactionPersistent = models.NewTransactionPersister()
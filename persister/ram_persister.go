@@ -3,13 +3,15 @@ package persister
 import (
 	"errors"
 	"fmt"
-	"log"
 	"math/big"
+	"reflect"
 	"strings"
 	"sync"
 	"time"
 
+	"github.com/marknguyen85/server-api/stream"
 	"github.com/marknguyen85/server-api/tomochain"
+	log "github.com/sirupsen/logrus"
 )
 
 const (
@@ -36,13 +38,15 @@ type RamPersister struct {
 	rates     []tomochain.Rate
 	isNewRate bool
 	updatedAt int64
+	rateBlock string
 
 	latestBlock      string
 	isNewLatestBlock bool
 
-	rateUSD      []RateUSD
-	rateTOMO     string
-	isNewRateUsd bool
+	rateUSD          []RateUSD
+	rateTOMO         string
+	isNewRateUsd     bool
+	updatedAtRateUSD int64
 
 	// rateUSDCG      []RateUSD
 	// rateTOMOCG      string
@@ -72,9 +76,39 @@ type RamPersister struct {
 
 	rightMarketInfo map[string]*tomochain.RightMarketInfo
 	// rightMarketInfoCG map[string]*tomochain.RightMarketInfo
+	updatedAtMarketInfo int64
 
 	isNewMarketInfo bool
 	// isNewMarketInfoCG bool
+
+	// publisher receive what changed on each save, nil when not streaming
+	publisher stream.Publisher
+
+	versions versions
+}
+
+// Trade is a trade event with the symbols of its tokens
+type Trade struct {
+	tomochain.EventHistory
+	SourceSymbol string `json:"sourceSymbol"`
+	DestSymbol   string `json:"destSymbol"`
+}
+
+// SetPublisher make every save publish its changes to publisher
+func (rPersister *RamPersister) SetPublisher(publisher stream.Publisher) {
+	rPersister.mu.Lock()
+	defer rPersister.mu.Unlock()
+	rPersister.publisher = publisher
+}
+
+// publish send items to the publisher, must be called without holding mu
+func (rPersister *RamPersister) publish(channel string, items []stream.Item) {
+	rPersister.mu.RLock()
+	publisher := rPersister.publisher
+	rPersister.mu.RUnlock()
+	if publisher != nil {
+		publisher.Publish(channel, items)
+	}
 }
 
 func NewRamPersister() (*RamPersister, error) {
@@ -157,6 +191,7 @@ func NewRamPersister() (*RamPersister, error) {
 		// rightMarketInfoCG: rightMarketInfoCG,
 		isNewMarketInfo: isNewMarketInfo,
 		// isNewMarketInfoCG: isNewMarketInfoCG,
+		versions: versions{},
 	}
 	return persister, nil
 }
@@ -188,9 +223,12 @@ func (rPersister *RamPersister) GetTimeUpdateRate() int64 {
 }
 
 func (rPersister *RamPersister) SetIsNewRate(isNewRate bool) {
-	rPersister.mu.RLock()
-	defer rPersister.mu.RUnlock()
+	rPersister.mu.Lock()
+	defer rPersister.mu.Unlock()
 	// return rPersister.rates
+	if rPersister.isNewRate != isNewRate {
+		rPersister.versions.bump(DatasetRate)
+	}
 	rPersister.isNewRate = isNewRate
 }
 
@@ -200,13 +238,35 @@ func (rPersister *RamPersister) GetIsNewRate() bool {
 	return rPersister.isNewRate
 }
 
-func (rPersister *RamPersister) SaveRate(rates []tomochain.Rate, timestamp int64) {
+func (rPersister *RamPersister) GetRateBlock() string {
+	rPersister.mu.RLock()
+	defer rPersister.mu.RUnlock()
+	return rPersister.rateBlock
+}
+
+func (rPersister *RamPersister) SaveRate(rates []tomochain.Rate, block string, timestamp int64) {
 	rPersister.mu.Lock()
-	defer rPersister.mu.Unlock()
+	previous := make(map[string]tomochain.Rate, len(rPersister.rates))
+	for _, r := range rPersister.rates {
+		previous[r.Source+"_"+r.Dest] = r
+	}
 	rPersister.rates = rates
 	if timestamp != 0 {
 		rPersister.updatedAt = timestamp
 	}
+	if block != "" {
+		rPersister.rateBlock = block
+	}
+	rPersister.versions.bump(DatasetRate)
+	rPersister.mu.Unlock()
+
+	var changed []stream.Item
+	for _, r := range rates {
+		if old, ok := previous[r.Source+"_"+r.Dest]; !ok || old != r {
+			changed = append(changed, stream.Item{Symbols: []string{r.Source, r.Dest}, Value: r})
+		}
+	}
+	rPersister.publish(stream.Rates, changed)
 }
 
 //--------------------------------------------------------
@@ -313,6 +373,10 @@ func (rPersister *RamPersister) GetIsNewRateUSD() bool {
 func (rPersister *RamPersister) SaveRateUSD(rateUSDEth string) error {
 	rPersister.mu.Lock()
 	defer rPersister.mu.Unlock()
+	previous := make(map[string]string, len(rPersister.rateUSD))
+	for _, r := range rPersister.rateUSD {
+		previous[r.Symbol] = r.PriceUsd
+	}
 
 	rates := make([]RateUSD, 0)
 	// ratesCG := make([]RateUSD, 0)
@@ -325,7 +389,10 @@ func (rPersister *RamPersister) SaveRateUSD(rateUSDEth string) error {
 		if item.Source != "TOMO" {
 			priceUsd, err := CalculateRateUSD(item.Rate, rateUSDEth)
 			if err != nil {
-				log.Print(err)
+				log.WithField("token", item.Source).WithError(err).Warn("cannot calculate rate usd")
+				if rPersister.isNewRateUsd {
+					rPersister.versions.bump(DatasetRateUSD)
+				}
 				rPersister.isNewRateUsd = false
 				return nil
 			}
@@ -342,7 +409,19 @@ func (rPersister *RamPersister) SaveRateUSD(rateUSDEth string) error {
 	rPersister.rateUSD = rates
 	rPersister.rateTOMO = rateUSDEth
 	rPersister.isNewRateUsd = true
+	rPersister.updatedAtRateUSD = time.Now().UTC().Unix()
+	rPersister.versions.bump(DatasetRateUSD)
 
+	var changed []stream.Item
+	for _, r := range rates {
+		if old, ok := previous[r.Symbol]; !ok || old != r.PriceUsd {
+			changed = append(changed, stream.Item{Symbols: []string{r.Symbol}, Value: r})
+		}
+	}
+	if publisher := rPersister.publisher; publisher != nil {
+		// mu is held: publish does not block
+		publisher.Publish(stream.RateUSD, changed)
+	}
 	return nil
 }
 
@@ -366,9 +445,19 @@ func CalculateRateUSD(rateEther string, rateUSD string) (string, error) {
 	return rateUSDNormal.String(), nil
 }
 
+// GetTimeUpdateRateUSD return unix time of the last rate usd saved
+func (rPersister *RamPersister) GetTimeUpdateRateUSD() int64 {
+	rPersister.mu.RLock()
+	defer rPersister.mu.RUnlock()
+	return rPersister.updatedAtRateUSD
+}
+
 func (rPersister *RamPersister) SetNewRateUSD(isNew bool) {
 	rPersister.mu.Lock()
 	defer rPersister.mu.Unlock()
+	if rPersister.isNewRateUsd != isNew {
+		rPersister.versions.bump(DatasetRateUSD)
+	}
 	rPersister.isNewRateUsd = isNew
 }
 
@@ -380,12 +469,71 @@ func (rPersister *RamPersister) GetLatestBlock() string {
 
 func (rPersister *RamPersister) SaveLatestBlock(blockNumber string) error {
 	rPersister.mu.Lock()
-	defer rPersister.mu.Unlock()
+	changed := rPersister.latestBlock != blockNumber
 	rPersister.latestBlock = blockNumber
 	rPersister.isNewLatestBlock = true
+	rPersister.mu.Unlock()
+
+	if changed {
+		rPersister.publish(stream.Block, []stream.Item{{Value: map[string]string{"blockNumber": blockNumber}}})
+	}
 	return nil
 }
 
+// GetEvents return the latest trade events
+func (rPersister *RamPersister) GetEvents() []tomochain.EventHistory {
+	rPersister.mu.RLock()
+	defer rPersister.mu.RUnlock()
+	return rPersister.events
+}
+
+// SaveEvents keep the latest trade events and publish the new ones, tokens
+// give the symbol of their addresses
+func (rPersister *RamPersister) SaveEvents(events []tomochain.EventHistory, tokens map[string]tomochain.Token) {
+	rPersister.mu.Lock()
+	seen := make(map[string]bool, len(rPersister.events))
+	for _, e := range rPersister.events {
+		seen[e.Txhash] = true
+	}
+	rPersister.events = events
+	rPersister.isNewEvent = true
+	rPersister.mu.Unlock()
+
+	symbols := make(map[string]string, len(tokens))
+	for _, t := range tokens {
+		symbols[strings.ToLower(t.Address)] = t.Symbol
+	}
+	var added []stream.Item
+	for _, e := range events {
+		if seen[e.Txhash] {
+			continue
+		}
+		trade := Trade{
+			EventHistory: e,
+			SourceSymbol: symbols[strings.ToLower(e.Source)],
+			DestSymbol:   symbols[strings.ToLower(e.Dest)],
+		}
+		added = append(added, stream.Item{Symbols: []string{trade.SourceSymbol, trade.DestSymbol}, Value: trade})
+	}
+	rPersister.publish(stream.Trades, added)
+}
+
+// SaveTokenChanges publish changes, the token list changes just recorded
+func (rPersister *RamPersister) SaveTokenChanges(changes []tomochain.TokenChange) {
+	if len(changes) == 0 {
+		return
+	}
+	rPersister.mu.Lock()
+	rPersister.versions.bump(DatasetTokenChanges)
+	rPersister.mu.Unlock()
+
+	items := make([]stream.Item, 0, len(changes))
+	for _, change := range changes {
+		items = append(items, stream.Item{Symbols: []string{change.Symbol}, Value: change})
+	}
+	rPersister.publish(stream.Tokens, items)
+}
+
 func (rPersister *RamPersister) GetIsNewLatestBlock() bool {
 	rPersister.mu.RLock()
 	defer rPersister.mu.RUnlock()
@@ -417,6 +565,9 @@ func (rPersister *RamPersister) GetIsNewTrackerData() bool {
 func (rPersister *RamPersister) SetIsNewTrackerData(isNewTrackerData bool) {
 	rPersister.mu.Lock()
 	defer rPersister.mu.Unlock()
+	if rPersister.isNewTrackerData != isNewTrackerData {
+		rPersister.versions.bump(DatasetMarketInfo)
+	}
 	rPersister.isNewTrackerData = isNewTrackerData
 	rPersister.numRequestFailedTracker = 0
 }
@@ -464,9 +615,33 @@ func (rPersister *RamPersister) SaveMarketData(marketRate map[string]*tomochain.
 	}
 
 	rPersister.mu.Lock()
-	defer rPersister.mu.Unlock()
+	previous := rPersister.rightMarketInfo
 	rPersister.last7D = lastSevenDays
 	rPersister.rightMarketInfo = newResult
+	rPersister.updatedAtMarketInfo = time.Now().UTC().Unix()
+	rPersister.versions.bump(DatasetMarketInfo)
+	rPersister.mu.Unlock()
+
+	var changed []stream.Item
+	for symbol, info := range newResult {
+		if old, ok := previous[symbol]; !ok || !reflect.DeepEqual(old, info) {
+			changed = append(changed, stream.Item{
+				Symbols: []string{symbol},
+				Value: struct {
+					Symbol string `json:"symbol"`
+					*tomochain.RightMarketInfo
+				}{symbol, info},
+			})
+		}
+	}
+	rPersister.publish(stream.Market, changed)
+}
+
+// GetTimeUpdateMarketInfo return unix time of the last market data saved
+func (rPersister *RamPersister) GetTimeUpdateMarketInfo() int64 {
+	rPersister.mu.RLock()
+	defer rPersister.mu.RUnlock()
+	return rPersister.updatedAtMarketInfo
 }
 
 func (rPersister *RamPersister) SetIsNewMarketInfo(isNewMarketInfo bool) {
@@ -481,6 +656,13 @@ func (rPersister *RamPersister) GetIsNewMarketInfo() bool {
 	return rPersister.isNewMarketInfo
 }
 
+// GetVersion return the version of dataset, zero for a dataset never saved
+func (rPersister *RamPersister) GetVersion(dataset string) Version {
+	rPersister.mu.RLock()
+	defer rPersister.mu.RUnlock()
+	return rPersister.versions[dataset]
+}
+
 func (rPersister *RamPersister) GetTimeVersion() string {
 	rPersister.mu.Lock()
 	defer rPersister.mu.Unlock()
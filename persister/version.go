@@ -0,0 +1,30 @@
+package persister
+
+import "time"
+
+// datasets carrying a version, named as in the health report
+const (
+	DatasetRate       = "rate"
+	DatasetRateUSD    = "rateUSD"
+	DatasetMarketInfo = "marketInfo"
+	// DatasetTokenChanges change whenever token list changes are recorded
+	DatasetTokenChanges = "tokenChanges"
+)
+
+// Version identify the state of a dataset: Seq change on every save and
+// every change of its freshness flag, ModTime is the time of that change
+type Version struct {
+	Seq     uint64
+	ModTime time.Time
+}
+
+// versions of the datasets, guarded by the mutex of the persister
+type versions map[string]Version
+
+// bump record a change of dataset
+func (v versions) bump(dataset string) {
+	version := v[dataset]
+	version.Seq++
+	version.ModTime = time.Now().UTC()
+	v[dataset] = version
+}
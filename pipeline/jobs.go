@@ -0,0 +1,206 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/marknguyen85/server-api/fetcher"
+	bFetcher "github.com/marknguyen85/server-api/fetcher/blockchain-fetcher"
+	persister "github.com/marknguyen85/server-api/persister"
+	"github.com/marknguyen85/server-api/tomochain"
+	log "github.com/sirupsen/logrus"
+)
+
+// fetcherFunc is a fetch job: read with fetcher, keep in persister and boltIns
+type fetcherFunc func(ctx context.Context, jobLog *log.Entry, persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher)
+
+func fetchRateUSD(ctx context.Context, jobLog *log.Entry, persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
+	rateUSD, err := fetcher.GetRateUsdTomo(ctx)
+	if err != nil {
+		jobLog.WithError(err).Error("cannot get rate usd")
+		persister.SetNewRateUSD(false)
+		return
+	}
+
+	if rateUSD == "" {
+		persister.SetNewRateUSD(false)
+		return
+	}
+
+	err = persister.SaveRateUSD(rateUSD)
+	if err != nil {
+		jobLog.WithError(err).Error("cannot save rate usd")
+		persister.SetNewRateUSD(false)
+		return
+	}
+}
+
+func makeMapRate(rates []tomochain.Rate) map[string]tomochain.Rate {
+	mapRate := make(map[string]tomochain.Rate)
+	for _, r := range rates {
+		mapRate[fmt.Sprintf("%s_%s", r.Source, r.Dest)] = r
+	}
+	return mapRate
+}
+
+func fetchGeneralInfoTokens(ctx context.Context, jobLog *log.Entry, persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
+	generalInfo, err := fetcher.GetGeneralInfoTokens(ctx)
+	if err != nil {
+		jobLog.WithError(err).Error("cannot get general info")
+		return
+	}
+	persister.SaveGeneralInfoTokens(generalInfo)
+	err = boltIns.StoreGeneralInfo(generalInfo)
+	if err != nil {
+		jobLog.WithError(err).Error("cannot store general info")
+	}
+}
+
+func fetchRate7dData(ctx context.Context, jobLog *log.Entry, persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
+	data, err := fetcher.FetchRate7dData(ctx)
+	if err != nil {
+		jobLog.WithError(err).Error("cannot fetch rate 7d")
+		if !persister.IsFailedToFetchTracker() {
+			return
+		}
+		persister.SetIsNewTrackerData(false)
+	} else {
+		persister.SetIsNewTrackerData(true)
+	}
+	mapToken := fetcher.GetListToken()
+	currentGeneral, err := boltIns.GetGeneralInfo(mapToken)
+	if err != nil {
+		jobLog.WithError(err).Error("cannot read general info")
+		currentGeneral = make(map[string]*tomochain.TokenGeneralInfo)
+	}
+	persister.SaveMarketData(data, currentGeneral, mapToken)
+	// persister.SetIsNewMarketInfo(true)
+}
+
+// pinBlock return the latest block every connection can read, every rate of a
+// run is read at it so they match and can be read again later. The latest
+// state is read when unknown.
+func pinBlock(ctx context.Context, jobLog *log.Entry, fetcher *fetcher.Fetcher) string {
+	block, err := fetcher.PinBlock(ctx)
+	if err != nil {
+		jobLog.WithError(err).Warn("cannot pin rates to a block, reading the latest state")
+		return bFetcher.LatestBlock
+	}
+	return block
+}
+
+func fetchRate(ctx context.Context, jobLog *log.Entry, persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
+	timeNow := time.Now().UTC().Unix()
+	var result []tomochain.Rate
+	currentRate := persister.GetRate()
+	tokenPriority := fetcher.GetListTokenPriority()
+	block := pinBlock(ctx, jobLog, fetcher)
+	rates, err := fetcher.GetRate(ctx, block, currentRate, persister.GetIsNewRate(), tokenPriority, false)
+	if err != nil {
+		jobLog.WithError(err).Error("cannot get rate")
+		persister.SetIsNewRate(false)
+		return
+	}
+	mapRate := makeMapRate(rates)
+	for _, cr := range currentRate {
+		keyRate := fmt.Sprintf("%s_%s", cr.Source, cr.Dest)
+		if r, ok := mapRate[keyRate]; ok {
+			result = append(result, r)
+			delete(mapRate, keyRate)
+		} else {
+			result = append(result, cr)
+		}
+	}
+	// add new token to current rate
+	if len(mapRate) > 0 {
+		for _, nr := range mapRate {
+			result = append(result, nr)
+		}
+	}
+	persister.SaveRate(result, savedBlock(block), timeNow)
+	persister.SetIsNewRate(true)
+}
+
+func fetchRateWithFallback(ctx context.Context, jobLog *log.Entry, persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
+	var result []tomochain.Rate
+	currentRate := persister.GetRate()
+	listToken := fetcher.GetListToken()
+	newList := make(map[string]tomochain.Token)
+	for _, t := range listToken {
+		if !t.Priority {
+			newList[t.Symbol] = t
+		}
+	}
+	block := pinBlock(ctx, jobLog, fetcher)
+	rates, err := fetcher.GetRate(ctx, block, currentRate, persister.GetIsNewRate(), newList, true)
+	if err != nil {
+		jobLog.WithError(err).Error("cannot get rate")
+		persister.SetIsNewRate(false)
+		return
+	}
+	mapRate := makeMapRate(rates)
+	for _, cr := range currentRate {
+		keyRate := fmt.Sprintf("%s_%s", cr.Source, cr.Dest)
+		if r, ok := mapRate[keyRate]; ok {
+			result = append(result, r)
+			if keyRate != "TOMO_TOMO" {
+				delete(mapRate, keyRate)
+			}
+		} else {
+			result = append(result, cr)
+		}
+	}
+	// add new token to current rate
+	if len(mapRate) > 1 {
+		for _, nr := range mapRate {
+			result = append(result, nr)
+		}
+	}
+	persister.SaveRate(result, savedBlock(block), 0)
+}
+
+// savedBlock is the block kept with the rates read at block, none for the latest state
+func savedBlock(block string) string {
+	if block == bFetcher.LatestBlock {
+		return ""
+	}
+	return block
+}
+
+func probeConnections(ctx context.Context, jobLog *log.Entry, persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
+	fetcher.ProbeConnections(ctx)
+}
+
+func fetchLatestBlock(ctx context.Context, jobLog *log.Entry, persister persister.Persister, boltIns persister.BoltInterface, fetcher *fetcher.Fetcher) {
+	previous := persister.GetLatestBlock()
+	blockNumber, err := fetcher.GetLatestBlock(ctx)
+	if err != nil {
+		jobLog.WithError(err).Error("cannot get latest block")
+		return
+	}
+	if blockNumber == previous {
+		return
+	}
+	persister.SaveLatestBlock(blockNumber)
+	// pushed by the logs subscription while it is up
+	if fetcher.TradesSubscribed() {
+		return
+	}
+
+	// trades are read from the block after the last one seen, not from genesis
+	last, ok := new(big.Int).SetString(previous, 10)
+	if !ok || last.Sign() == 0 {
+		return
+	}
+	fromBlock := last.Add(last, big.NewInt(1)).String()
+	events, err := fetcher.GetTradeEvents(ctx, fromBlock, blockNumber)
+	if err != nil {
+		jobLog.WithError(err).Warn("cannot get trade events")
+		return
+	}
+	if len(events) > 0 {
+		persister.SaveEvents(events, fetcher.GetListToken())
+	}
+}
@@ -0,0 +1,244 @@
+// Package pipeline fetch the data of each network on schedule and keep it
+// in the caches served over http
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/marknguyen85/server-api/common"
+	"github.com/marknguyen85/server-api/config"
+	"github.com/marknguyen85/server-api/fetcher"
+	"github.com/marknguyen85/server-api/http"
+	persister "github.com/marknguyen85/server-api/persister"
+	"github.com/marknguyen85/server-api/scheduler"
+	"github.com/marknguyen85/server-api/stream"
+	"github.com/marknguyen85/server-api/tomochain"
+	log "github.com/sirupsen/logrus"
+)
+
+// Network is a chain served by the process, with its own fetcher, cache and
+// bolt bucket
+type Network struct {
+	*http.Network
+	boltIns  persister.BoltInterface
+	notifier TokenNotifier
+}
+
+// TokenNotifier is told the token list changes of a network, see the webhook
+// package
+type TokenNotifier interface {
+	Notify(network string, changes []tomochain.TokenChange)
+}
+
+// NewNetwork build the fetcher and cache of chain, publishing its changes to
+// hub and the token list changes to notifier, which may be nil. The default
+// network keep the original bolt bucket so its data survive the upgrade.
+func NewNetwork(name string, chain config.Chain, boltIns *persister.BoltStorage, isDefault bool, hub *stream.Hub, notifier TokenNotifier) (*Network, error) {
+	fertcherIns, err := fetcher.NewFetcher(chain)
+	if err != nil {
+		return nil, err
+	}
+	persisterIns, _ := persister.NewPersister("ram")
+	persisterIns.SetPublisher(hub.Network(name))
+
+	var boltNet *persister.BoltStorage
+	if boltIns != nil {
+		bucket := name
+		if isDefault {
+			bucket = ""
+		}
+		boltNet, err = boltIns.Namespace(bucket)
+		if err != nil {
+			return nil, err
+		}
+	}
+	n := &Network{
+		Network: &http.Network{
+			Name:      name,
+			Fetcher:   fertcherIns,
+			Persister: persisterIns,
+		},
+		boltIns:  boltNet,
+		notifier: notifier,
+	}
+	// the changes are kept in bolt, none are tracked without it
+	if boltNet != nil {
+		n.Bolt = boltNet
+		fertcherIns.OnTokenList(n.trackTokenChanges)
+	}
+	return n, nil
+}
+
+// trackTokenChanges record the changes from the last token list to tokens,
+// publish them and notify the webhooks. The first list ever seen is only
+// kept to compare the next ones with.
+func (n *Network) trackTokenChanges(tokens map[string]tomochain.Token) {
+	netLog := log.WithField("network", n.Name)
+	before, ok, err := n.Bolt.GetTokenSnapshot()
+	if err != nil {
+		netLog.WithError(err).Error("cannot read last token list")
+		return
+	}
+	var changes []tomochain.TokenChange
+	if ok {
+		changes = fetcher.DiffTokens(before, tokens, time.Now().Unix())
+		if len(changes) == 0 {
+			return
+		}
+	}
+	changes, err = n.Bolt.StoreTokenChanges(tokens, changes)
+	if err != nil {
+		netLog.WithError(err).Error("cannot store token list changes")
+		return
+	}
+	if !ok {
+		netLog.WithField("tokens", len(tokens)).Info("first token list recorded, later changes are tracked")
+		return
+	}
+	for _, change := range changes {
+		netLog.WithFields(log.Fields{
+			"change":      change.Type,
+			"token":       change.TokenID,
+			"address":     change.Address,
+			"old_address": change.OldAddress,
+		}).Info("token list changed")
+	}
+	n.Persister.SaveTokenChanges(changes)
+	if n.notifier != nil {
+		n.notifier.Notify(n.Name, changes)
+	}
+}
+
+// Schedule load the token list, seed the rates and add the fetch jobs of the network
+func (n *Network) Schedule(ctx context.Context, sched *scheduler.Scheduler, intervals config.Intervals, verification config.Verification) {
+	fertcherIns, persisterIns := n.Fetcher, n.Persister
+	err := fertcherIns.TryUpdateListToken(ctx)
+	if err != nil {
+		log.WithField("network", n.Name).WithError(err).Error("cannot update list token")
+	}
+
+	sched.Add(scheduler.Job{
+		Name:      n.Name + "/listToken",
+		Interval:  intervals.ListToken.Std(),
+		SkipFirst: true,
+		Run: func(ctx context.Context) {
+			fertcherIns.TryUpdateListToken(ctx)
+		},
+	})
+	var (
+		initRate   []tomochain.Rate
+		tomoSymbol = common.TOMOSymbol
+	)
+
+	for symbol := range fertcherIns.GetListToken() {
+		if symbol == tomoSymbol {
+			tomoRate := tomochain.Rate{
+				Source:  tomoSymbol,
+				Dest:    tomoSymbol,
+				Rate:    "0",
+				Minrate: "0",
+			}
+			initRate = append(initRate, tomoRate, tomoRate)
+		} else {
+			buyRate := tomochain.Rate{
+				Source:  tomoSymbol,
+				Dest:    symbol,
+				Rate:    "0",
+				Minrate: "0",
+			}
+			sellRate := tomochain.Rate{
+				Source:  symbol,
+				Dest:    tomoSymbol,
+				Rate:    "0",
+				Minrate: "0",
+			}
+			initRate = append(initRate, buyRate, sellRate)
+		}
+	}
+	persisterIns.SaveRate(initRate, "", 0)
+	intervalFetchGeneralInfoTokens := intervals.GeneralInfo.Std()
+	if intervalFetchGeneralInfoTokens == 0 {
+		tokenNum := fertcherIns.GetNumTokens()
+		bonusTimeWait := 900
+		if tokenNum > 200 {
+			bonusTimeWait = 60
+		}
+		intervalFetchGeneralInfoTokens = time.Duration((tokenNum*7)+bonusTimeWait) * time.Second
+	}
+
+	n.runFetchData(sched, "rateUSD", fetchRateUSD, intervals.RateUSD.Std())
+
+	n.runFetchData(sched, "generalInfo", fetchGeneralInfoTokens, intervalFetchGeneralInfoTokens)
+
+	n.runFetchData(sched, "rate7d", fetchRate7dData, intervals.Rate7d.Std())
+
+	n.runFetchData(sched, "rate", fetchRate, intervals.Rate.Std())
+	n.runFetchData(sched, "rateFallback", fetchRateWithFallback, intervals.RateFallback.Std())
+	n.runFetchData(sched, "block", fetchLatestBlock, intervals.Block.Std())
+	n.runFetchData(sched, "connections", probeConnections, intervals.Connections.Std())
+	if verification.Interval > 0 {
+		verifyLog := log.WithFields(log.Fields{
+			"network": n.Name,
+			"job":     "verify",
+		})
+		sched.Add(scheduler.Job{
+			Name:      n.Name + "/verify",
+			Interval:  verification.Interval.Std(),
+			SkipFirst: true,
+			Run: func(ctx context.Context) {
+				if err := fertcherIns.VerifyConnections(ctx, verification); err != nil {
+					verifyLog.WithError(err).Warn("cannot verify connections")
+				}
+			},
+		})
+	}
+
+	// with a ws connection the rates and block follow each new block, the
+	// tickers above remain as the fallback
+	go fertcherIns.WatchBlocks(ctx, func(block string) {
+		sched.Trigger(n.Name + "/rate")
+		sched.Trigger(n.Name + "/block")
+	}, func(events []tomochain.EventHistory) {
+		persisterIns.SaveEvents(events, fertcherIns.GetListToken())
+	})
+}
+
+func (n *Network) runFetchData(sched *scheduler.Scheduler, name string, fn fetcherFunc, interval time.Duration) {
+	jobLog := log.WithFields(log.Fields{
+		"network": n.Name,
+		"job":     name,
+	})
+	sched.Add(scheduler.Job{
+		Name:     n.Name + "/" + name,
+		Interval: interval,
+		Run: func(ctx context.Context) {
+			fn(ctx, jobLog, n.Persister, n.boltIns, n.Fetcher)
+		},
+	})
+}
+
+// Reload apply the new connections and backup tokens of each network.
+// Networks can only be added or removed by a restart.
+func Reload(networks []*Network, cfg *config.Config) error {
+	chains := cfg.Chains()
+	served := make(map[string]bool, len(networks))
+	for _, n := range networks {
+		served[n.Name] = true
+		chain, ok := chains[n.Name]
+		if !ok {
+			log.WithField("network", n.Name).Warn("network removed from config, restart to apply")
+			continue
+		}
+		if err := n.Fetcher.Reload(chain); err != nil {
+			return fmt.Errorf("network %s: %v", n.Name, err)
+		}
+	}
+	for name := range chains {
+		if !served[name] {
+			log.WithField("network", name).Warn("network added to config, restart to apply")
+		}
+	}
+	return nil
+}
@@ -0,0 +1,349 @@
+package pipeline
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	nethttp "net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marknguyen85/server-api/config"
+	"github.com/marknguyen85/server-api/http"
+	"github.com/marknguyen85/server-api/httpclient"
+	persister "github.com/marknguyen85/server-api/persister"
+	"github.com/marknguyen85/server-api/replay"
+	"github.com/marknguyen85/server-api/scheduler"
+	"github.com/marknguyen85/server-api/stream"
+	"github.com/marknguyen85/server-api/tomochain"
+	"github.com/tomochain/tomochain/crypto"
+)
+
+const (
+	networkName = "tomochain"
+	tomoAddr    = "eeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeeee"
+	tokenAddr   = "1111111111111111111111111111111111111111"
+	// rates answered by the nodes, in wei of dest per unit of source
+	sellRate = "2000000000000000000"
+	buyRate  = "500000000000000000"
+)
+
+// localNodes send the requests of the fake nodes, on the loopback, and
+// replay every other one
+type localNodes struct {
+	replayer *replay.Replayer
+}
+
+func (transport localNodes) RoundTrip(req *nethttp.Request) (*nethttp.Response, error) {
+	if strings.HasPrefix(req.URL.Host, "127.0.0.1:") {
+		return nethttp.DefaultTransport.RoundTrip(req)
+	}
+	return transport.replayer.RoundTrip(req)
+}
+
+// rateCall is the prefix of the getExpectedRate calls from source
+func rateCall(source string) string {
+	selector := crypto.Keccak256([]byte("getExpectedRate(address,address,uint256)"))[:4]
+	return hex.EncodeToString(selector) + strings.Repeat("0", 24) + source
+}
+
+// rateResult encode the expected and slippage rates of a getExpectedRate answer
+func rateResult(rate string) string {
+	expected, _ := new(big.Int).SetString(rate, 10)
+	slippage := new(big.Int).Div(new(big.Int).Mul(expected, big.NewInt(97)), big.NewInt(100))
+	return fmt.Sprintf("0x%064x%064x", expected, slippage)
+}
+
+func newNode(block uint64) *replay.Node {
+	node := replay.NewNode()
+	node.SetBlock(block)
+	node.SetCall(rateCall(tokenAddr), rateResult(sellRate))
+	node.SetCall(rateCall(tomoAddr), rateResult(buyRate))
+	return node
+}
+
+// runJob trigger the job of the network and wait for its run
+func runJob(t *testing.T, sched *scheduler.Scheduler, job string) scheduler.JobState {
+	name := networkName + "/" + job
+	runs := jobState(sched, name).Runs
+	if !sched.Trigger(name) {
+		t.Fatalf("no job %s", name)
+	}
+	var state scheduler.JobState
+	waitFor(t, name+" to run", func() bool {
+		state = jobState(sched, name)
+		return state.Runs > runs
+	})
+	return state
+}
+
+func jobState(sched *scheduler.Scheduler, name string) scheduler.JobState {
+	for _, state := range sched.States() {
+		if state.Name == name {
+			return state
+		}
+	}
+	return scheduler.JobState{}
+}
+
+func waitFor(t *testing.T, what string, done func() bool) {
+	deadline := time.Now().Add(10 * time.Second)
+	for !done() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timeout waiting for %s", what)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func getJSON(t *testing.T, server nethttp.Handler, path string, result interface{}) {
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+	if recorder.Code != nethttp.StatusOK {
+		t.Fatalf("GET %s: status %d %s", path, recorder.Code, recorder.Body.String())
+	}
+	if err := json.Unmarshal(recorder.Body.Bytes(), result); err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+}
+
+// legacyRates is the /rate answer
+type legacyRates struct {
+	Success bool   `json:"success"`
+	Block   string `json:"block"`
+	Data    []struct {
+		Source string `json:"source"`
+		Dest   string `json:"dest"`
+		Rate   string `json:"rate"`
+	} `json:"data"`
+}
+
+func (rates legacyRates) rate(source, dest string) string {
+	for _, r := range rates.Data {
+		if r.Source == source && r.Dest == dest {
+			return r.Rate
+		}
+	}
+	return ""
+}
+
+// notifications record the token changes notified, as the webhooks
+type notifications chan []tomochain.TokenChange
+
+func (n notifications) Notify(network string, changes []tomochain.TokenChange) {
+	n <- changes
+}
+
+// tokenChanges is the /tokens/changes answer
+type tokenChanges struct {
+	Success bool                    `json:"success"`
+	Data    []tomochain.TokenChange `json:"data"`
+}
+
+// TestPipeline run the fetch jobs of a network against fake nodes and
+// replayed upstreams, and read the result over http
+func TestPipeline(t *testing.T) {
+	replayer, err := replay.LoadReplayer(filepath.Join("testdata", "fixtures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	options := httpclient.DefaultOptions()
+	options.Timeout = 300 * time.Millisecond
+	options.Retries = 0
+	options.Transport = localNodes{replayer: replayer}
+	httpclient.SetDefault(httpclient.New(options))
+
+	// the first node fail every call, the pool fail over to the second one
+	broken, healthy := newNode(100), newNode(100)
+	defer broken.Close()
+	defer healthy.Close()
+	broken.Fail("eth_call", "missing trie node")
+
+	dir, err := ioutil.TempDir("", "pipeline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	boltIns, err := persister.NewBoltStorage(filepath.Join(dir, "market.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer boltIns.Close()
+
+	chain := config.Chain{
+		Network:          "0x" + strings.Repeat("2", 40),
+		TradeTopic:       "0x" + strings.Repeat("3", 64),
+		AverageBlockTime: 2,
+		ConfigEndpoint:   "https://config.chaintex.test/tokens",
+		APIEndpoint:      "https://tracker.chaintex.test",
+		Connections: []config.Connection{
+			{Type: "node", Endpoint: broken.URL},
+			{Type: "node", Endpoint: healthy.URL},
+		},
+	}
+	hub := stream.NewHub(16)
+	notified := make(notifications, 4)
+	network, err := NewNetwork(networkName, chain, boltIns, true, hub, notified)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := config.Default()
+	ctx, cancel := context.WithCancel(context.Background())
+	sched := scheduler.NewScheduler()
+	network.Schedule(ctx, sched, cfg.Intervals, config.Verification{})
+	sched.Start(ctx)
+	defer sched.Wait()
+	defer cancel()
+
+	server := http.NewHTTPServer("", []*http.Network{network.Network}, networkName, sched,
+		cfg.MaxDataAge.Std(), "", "", nil, hub, nil, cfg.CORS).Handler()
+
+	persisterIns := network.Persister
+	waitFor(t, "rates", persisterIns.GetIsNewRate)
+	waitFor(t, "rate usd", persisterIns.GetIsNewRateUSD)
+	waitFor(t, "tracker data", persisterIns.GetIsNewTrackerData)
+
+	var rates legacyRates
+	getJSON(t, server, "/rate", &rates)
+	if !rates.Success || rates.Block != "100" {
+		t.Fatalf("rates: success %v at block %q, want block 100", rates.Success, rates.Block)
+	}
+	if rate := rates.rate("TKN", "TOMO"); rate != sellRate {
+		t.Errorf("TKN to TOMO rate %s, want %s", rate, sellRate)
+	}
+	if rate := rates.rate("TOMO", "TKN"); rate != buyRate {
+		t.Errorf("TOMO to TKN rate %s, want %s", rate, buyRate)
+	}
+	if healthy.Requests("eth_call") == 0 {
+		t.Error("no call failed over to the healthy node")
+	}
+
+	var v2 http.Response
+	getJSON(t, server, "/v2/"+networkName+"/rate", &v2)
+	if v2.Block != "100" || v2.Stale || v2.Error != nil {
+		t.Errorf("v2 rate: block %q stale %v error %v", v2.Block, v2.Stale, v2.Error)
+	}
+
+	var usd struct {
+		Success bool        `json:"success"`
+		Data    interface{} `json:"data"`
+	}
+	getJSON(t, server, "/rateUSD", &usd)
+	if !usd.Success || !strings.Contains(fmt.Sprint(usd.Data), "0.512345") {
+		t.Errorf("rate usd: %+v", usd)
+	}
+
+	var last7D struct {
+		Data   map[string][]float64 `json:"data"`
+		Status string               `json:"status"`
+	}
+	query := url.Values{"listToken": {"TKN"}}
+	getJSON(t, server, "/last7D?"+query.Encode(), &last7D)
+	if len(last7D.Data["TKN"]) != 3 || last7D.Status != "latest" {
+		t.Errorf("last 7d: %+v", last7D)
+	}
+	// unknown and repeated symbols share the cached body of the known ones
+	var unknown struct {
+		Data map[string][]float64 `json:"data"`
+	}
+	getJSON(t, server, "/last7D?"+url.Values{"listToken": {"FOO-TKN-TKN"}}.Encode(), &unknown)
+	if len(unknown.Data) != 1 || len(unknown.Data["TKN"]) != 3 {
+		t.Errorf("last 7d of unknown and repeated symbols: %+v", unknown)
+	}
+
+	// the upstreams now fail: coingecko with a 500, the tracker never answer
+	failures, err := replay.LoadFixtures(filepath.Join("testdata", "failures"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fixture := range failures {
+		replayer.Add(fixture)
+	}
+
+	runJob(t, sched, "rateUSD")
+	getJSON(t, server, "/rateUSD", &usd)
+	if usd.Success {
+		t.Error("rate usd still served after the upstream failed")
+	}
+
+	state := runJob(t, sched, "rate7d")
+	if state.LastDuration < options.Timeout {
+		t.Errorf("tracker failed after %v, before the timeout", state.LastDuration)
+	}
+	// a failed refresh keep the last data
+	getJSON(t, server, "/last7D?"+query.Encode(), &last7D)
+	if len(last7D.Data["TKN"]) != 3 {
+		t.Errorf("last 7d after the tracker timeout: %+v", last7D)
+	}
+
+	// rates follow the chain
+	healthy.SetBlock(101)
+	broken.SetBlock(101)
+	runJob(t, sched, "rate")
+	getJSON(t, server, "/rate", &rates)
+	if rates.Block != "101" {
+		t.Errorf("rates at block %q, want 101", rates.Block)
+	}
+
+	// the first token list is the baseline, without change
+	var changes tokenChanges
+	getJSON(t, server, "/tokens/changes", &changes)
+	if !changes.Success || len(changes.Data) != 0 {
+		t.Fatalf("changes of the first token list: %+v", changes)
+	}
+
+	// TKN move and NEW is listed
+	newAddr := "0x" + strings.Repeat("3", 40)
+	replayer.Add(replay.Fixture{
+		Method: "GET",
+		URL:    chain.ConfigEndpoint,
+		Status: nethttp.StatusOK,
+		Response: `{"success":true,"data":[` +
+			`{"name":"TomoChain","symbol":"TOMO","address":"0x` + tomoAddr + `","decimals":18,"priority":true},` +
+			`{"name":"Token","symbol":"TKN","address":"0x` + strings.Repeat("2", 40) + `","decimals":18,"priority":true},` +
+			`{"name":"New","symbol":"NEW","address":"` + newAddr + `","decimals":6}]}`,
+	})
+	runJob(t, sched, "listToken")
+	select {
+	case sent := <-notified:
+		if len(sent) != 2 {
+			t.Errorf("notified %+v, want 2 changes", sent)
+		}
+	case <-time.After(time.Second):
+		t.Error("token changes not notified")
+	}
+	var v2Changes struct {
+		Data []tomochain.TokenChange `json:"data"`
+	}
+	getJSON(t, server, "/v2/"+networkName+"/tokens/changes", &v2Changes)
+	if len(v2Changes.Data) != 2 {
+		t.Fatalf("changes %+v, want NEW listed and TKN moved", v2Changes.Data)
+	}
+	if listed := v2Changes.Data[0]; listed.Seq != 1 || listed.Type != tomochain.TokenListed || listed.Address != newAddr {
+		t.Errorf("listing %+v", listed)
+	}
+	if moved := v2Changes.Data[1]; moved.Seq != 2 || moved.Type != tomochain.TokenAddressChanged || moved.OldAddress != "0x"+tokenAddr {
+		t.Errorf("address change %+v", moved)
+	}
+
+	// an unchanged list record nothing
+	runJob(t, sched, "listToken")
+	getJSON(t, server, "/tokens/changes?since=1", &changes)
+	if len(changes.Data) != 1 || changes.Data[0].Seq != 2 {
+		t.Errorf("changes since 1: %+v", changes.Data)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, httptest.NewRequest("GET", "/tokens/changes?limit=0", nil))
+	if recorder.Code != nethttp.StatusBadRequest {
+		t.Errorf("limit 0: status %d", recorder.Code)
+	}
+}
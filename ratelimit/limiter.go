@@ -0,0 +1,79 @@
+package ratelimit
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// idle buckets are forgotten after this long, they are full again by then
+const sweepInterval = time.Minute
+
+// Limit is a token bucket refilled with Rate tokens per second up to Burst
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// Limiter take tokens from buckets shared by every request of a client
+type Limiter interface {
+	// Allow take one token from the bucket key, refilled at limit. When it
+	// is empty it return false and how long until a token is available.
+	Allow(key string, limit Limit) (bool, time.Duration, error)
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+// MemoryLimiter keep the buckets in the process, each server count its own
+// requests
+type MemoryLimiter struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryLimiter contruct
+func NewMemoryLimiter() *MemoryLimiter {
+	return &MemoryLimiter{
+		buckets:   make(map[string]*bucket),
+		lastSweep: time.Now(),
+	}
+}
+
+// Allow implement Limiter
+func (limiter *MemoryLimiter) Allow(key string, limit Limit) (bool, time.Duration, error) {
+	now := time.Now()
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+	limiter.sweep(now)
+
+	b, ok := limiter.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		limiter.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+	wait := time.Duration((1 - b.tokens) / limit.Rate * float64(time.Second))
+	return false, wait, nil
+}
+
+// sweep drop the buckets not used since the last sweep, must hold mu
+func (limiter *MemoryLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.lastSweep) < sweepInterval {
+		return
+	}
+	for key, b := range limiter.buckets {
+		if b.updated.Before(limiter.lastSweep) {
+			delete(limiter.buckets, key)
+		}
+	}
+	limiter.lastSweep = now
+}
@@ -0,0 +1,109 @@
+package ratelimit
+
+import (
+	"errors"
+	"time"
+
+	"github.com/marknguyen85/server-api/config"
+)
+
+// ErrUnknownKey is returned for a request with an api key not configured
+var ErrUnknownKey = errors.New("unknown api key")
+
+// Decision is the outcome of a request
+type Decision struct {
+	Allowed bool
+	// RetryAfter is the time until the client may try again when not allowed
+	RetryAfter time.Duration
+	// Limit applied to the client, zero when not limited
+	Limit Limit
+}
+
+// Policy limit the requests of each route group: anonymous clients by ip
+// with the limit of the group, clients sending an api key with the quota of
+// their key
+type Policy struct {
+	limiter Limiter
+	groups  map[string]Limit
+	keys    map[string]key
+}
+
+type key struct {
+	name  string
+	limit Limit
+}
+
+// New build the policy of cfg, nil when no limit is configured
+func New(cfg config.RateLimit) (*Policy, error) {
+	if len(cfg.Groups) == 0 && len(cfg.APIKeys) == 0 {
+		return nil, nil
+	}
+	var limiter Limiter
+	switch cfg.Store {
+	case "redis":
+		redisLimiter, err := NewRedisLimiter(cfg.RedisAddr, cfg.RedisPassword)
+		if err != nil {
+			return nil, err
+		}
+		limiter = redisLimiter
+	default:
+		limiter = NewMemoryLimiter()
+	}
+
+	policy := &Policy{
+		limiter: limiter,
+		groups:  make(map[string]Limit, len(cfg.Groups)),
+		keys:    make(map[string]key, len(cfg.APIKeys)),
+	}
+	for name, limit := range cfg.Groups {
+		policy.groups[name] = Limit{Rate: limit.Rate, Burst: limit.Burst}
+	}
+	for _, apiKey := range cfg.APIKeys {
+		policy.keys[apiKey.Key] = key{
+			name:  apiKey.Name,
+			limit: Limit{Rate: apiKey.Rate, Burst: apiKey.Burst},
+		}
+	}
+	return policy, nil
+}
+
+// Allow take a token for a request of group, from the bucket of apiKey when
+// set, of clientIP otherwise. A nil policy allow everything. When the store
+// fail the request is allowed and the error returned.
+func (policy *Policy) Allow(group, apiKey, clientIP string) (Decision, error) {
+	if policy == nil {
+		return Decision{Allowed: true}, nil
+	}
+	var bucket string
+	var limit Limit
+	if apiKey != "" {
+		k, ok := policy.keys[apiKey]
+		if !ok {
+			return Decision{}, ErrUnknownKey
+		}
+		bucket, limit = "key:"+k.name+":"+group, k.limit
+	} else {
+		var ok bool
+		if limit, ok = policy.groups[group]; !ok {
+			return Decision{Allowed: true}, nil
+		}
+		bucket = "ip:" + clientIP + ":" + group
+	}
+
+	allowed, wait, err := policy.limiter.Allow(bucket, limit)
+	if err != nil {
+		return Decision{Allowed: true, Limit: limit}, err
+	}
+	return Decision{Allowed: allowed, RetryAfter: wait, Limit: limit}, nil
+}
+
+// Close release the store of the policy
+func (policy *Policy) Close() error {
+	if policy == nil {
+		return nil
+	}
+	if closer, ok := policy.limiter.(interface{ Close() error }); ok {
+		return closer.Close()
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package ratelimit
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-redis/redis"
+)
+
+const redisKeyPrefix = "chaintex:ratelimit:"
+
+// takeToken refill then take one token of the bucket KEYS[1] atomically.
+// ARGV: rate per second, burst, now in milliseconds. Return {allowed, wait ms}.
+var takeToken = redis.NewScript(`
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local state = redis.call("HMGET", KEYS[1], "tokens", "updated")
+local tokens = tonumber(state[1]) or burst
+local updated = tonumber(state[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) / 1000 * rate)
+local allowed, wait = 0, 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	wait = math.ceil((1 - tokens) / rate * 1000)
+end
+redis.call("HMSET", KEYS[1], "tokens", tostring(tokens), "updated", tostring(now))
+redis.call("PEXPIRE", KEYS[1], math.ceil(burst / rate * 1000) + 1000)
+return {allowed, wait}
+`)
+
+// RedisLimiter keep the buckets in redis, shared by every server
+type RedisLimiter struct {
+	client *redis.Client
+}
+
+// NewRedisLimiter connect to the redis server at addr
+func NewRedisLimiter(addr, password string) (*RedisLimiter, error) {
+	client := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     password,
+		DialTimeout:  2 * time.Second,
+		ReadTimeout:  time.Second,
+		WriteTimeout: time.Second,
+	})
+	if err := client.Ping().Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("redis %s: %v", addr, err)
+	}
+	return &RedisLimiter{client: client}, nil
+}
+
+// Allow implement Limiter
+func (limiter *RedisLimiter) Allow(key string, limit Limit) (bool, time.Duration, error) {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	result, err := takeToken.Run(limiter.client, []string{redisKeyPrefix + key},
+		strconv.FormatFloat(limit.Rate, 'f', -1, 64), limit.Burst, now).Result()
+	if err != nil {
+		return false, 0, err
+	}
+	values, ok := result.([]interface{})
+	if !ok || len(values) != 2 {
+		return false, 0, fmt.Errorf("unexpected redis reply %v", result)
+	}
+	allowed, _ := values[0].(int64)
+	wait, _ := values[1].(int64)
+	return allowed == 1, time.Duration(wait) * time.Millisecond, nil
+}
+
+// Close the connections to redis
+func (limiter *RedisLimiter) Close() error {
+	return limiter.client.Close()
+}
@@ -0,0 +1,141 @@
+// Package replay record the responses of the upstream services to fixture
+// files and serve them back, so the fetchers can run without network. It also
+// provide a fake json rpc node.
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ErrorTimeout is the Error of a fixture whose request never get an answer
+const ErrorTimeout = "timeout"
+
+// redactedParams are query parameters holding credentials, not written to
+// fixtures and ignored when matching
+var redactedParams = []string{"apikey", "api_key", "key", "token"}
+
+var unsafeName = regexp.MustCompile(`[^a-zA-Z0-9.-]+`)
+
+// Fixture is one exchange with an upstream
+type Fixture struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	// Body of the request, json rpc calls are matched on it without their id
+	Body string `json:"body,omitempty"`
+
+	Status   int         `json:"status,omitempty"`
+	Header   http.Header `json:"header,omitempty"`
+	Response string      `json:"response,omitempty"`
+	// Error replace the response: ErrorTimeout block until the request is
+	// cancelled, any other value is returned as a transport error
+	Error string `json:"error,omitempty"`
+	// DelayMs wait before answering
+	DelayMs int `json:"delay_ms,omitempty"`
+}
+
+// key identify the request of the fixture
+func (fixture *Fixture) key() string {
+	return requestKey(fixture.Method, fixture.URL, fixture.Body)
+}
+
+func requestKey(method, rawURL, body string) string {
+	return method + " " + redactURL(rawURL) + " " + normalizeBody(body)
+}
+
+// redactURL replace the credentials of rawURL
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	query := parsed.Query()
+	for _, name := range redactedParams {
+		if _, ok := query[name]; ok {
+			query.Set(name, "REDACTED")
+		}
+	}
+	parsed.RawQuery = query.Encode()
+	return parsed.String()
+}
+
+// normalizeBody drop the id of a json rpc call, it change on every call
+func normalizeBody(body string) string {
+	var call map[string]interface{}
+	if json.Unmarshal([]byte(body), &call) != nil {
+		return body
+	}
+	if _, ok := call["jsonrpc"]; !ok {
+		return body
+	}
+	delete(call, "id")
+	normalized, err := json.Marshal(call)
+	if err != nil {
+		return body
+	}
+	return string(normalized)
+}
+
+// fileName of the fixture in a directory: its host and a hash of its request
+func (fixture *Fixture) fileName() string {
+	host := "upstream"
+	if parsed, err := url.Parse(fixture.URL); err == nil && parsed.Host != "" {
+		host = parsed.Host
+	}
+	h := fnv.New64a()
+	h.Write([]byte(fixture.key()))
+	return fmt.Sprintf("%s-%x.json", unsafeName.ReplaceAllString(host, "_"), h.Sum64())
+}
+
+// save write the fixture to dir, credentials redacted
+func (fixture Fixture) save(dir string) error {
+	fixture.URL = redactURL(fixture.URL)
+	data, err := json.MarshalIndent(fixture, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, fixture.fileName()), append(data, '\n'), 0644)
+}
+
+// LoadFixtures read every .json file of dir, each holding a fixture or a
+// list of them
+func LoadFixtures(dir string) ([]Fixture, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	var fixtures []Fixture
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		data = bytes.TrimSpace(data)
+		if strings.HasPrefix(string(data), "[") {
+			var list []Fixture
+			if err := json.Unmarshal(data, &list); err != nil {
+				return nil, fmt.Errorf("%s: %v", path, err)
+			}
+			fixtures = append(fixtures, list...)
+			continue
+		}
+		var fixture Fixture
+		if err := json.Unmarshal(data, &fixture); err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		fixtures = append(fixtures, fixture)
+	}
+	return fixtures, nil
+}
@@ -0,0 +1,193 @@
+package replay
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marknguyen85/server-api/tomochain"
+	"github.com/tomochain/tomochain/common/hexutil"
+)
+
+// errorExecution is the json rpc code of a failed call
+const errorExecution = -32000
+
+// rpcError is the error member of a json rpc answer
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Node is a fake json rpc node over http. It answer eth_blockNumber,
+// eth_call and eth_getLogs with what the test set, and can fail or delay any
+// method.
+type Node struct {
+	URL string
+
+	server *httptest.Server
+
+	mu       sync.Mutex
+	block    uint64
+	calls    map[string]string
+	logs     []tomochain.EventRaw
+	failures map[string]rpcError
+	delays   map[string]time.Duration
+	requests map[string]int
+}
+
+// NewNode start a node at block 1
+func NewNode() *Node {
+	node := &Node{
+		block:    1,
+		calls:    make(map[string]string),
+		failures: make(map[string]rpcError),
+		delays:   make(map[string]time.Duration),
+		requests: make(map[string]int),
+	}
+	node.server = httptest.NewServer(http.HandlerFunc(node.serve))
+	node.URL = node.server.URL
+	return node
+}
+
+// Close stop the node
+func (node *Node) Close() {
+	node.server.Close()
+}
+
+// SetBlock set the latest block, calls at a later block fail
+func (node *Node) SetBlock(block uint64) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.block = block
+}
+
+// SetCall answer result, a hex string, to the eth_call whose data start with
+// prefix. The longest matching prefix wins; calls matching none fail.
+func (node *Node) SetCall(prefix, result string) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.calls[strings.ToLower(strings.TrimPrefix(prefix, "0x"))] = result
+}
+
+// SetLogs answer logs to every eth_getLogs
+func (node *Node) SetLogs(logs []tomochain.EventRaw) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.logs = logs
+}
+
+// Fail answer every call of method with an error, an empty message stop failing
+func (node *Node) Fail(method, message string) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	if message == "" {
+		delete(node.failures, method)
+		return
+	}
+	node.failures[method] = rpcError{Code: errorExecution, Message: message}
+}
+
+// Delay wait delay before answering method, past the timeout of the client
+// to simulate a node not answering
+func (node *Node) Delay(method string, delay time.Duration) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	node.delays[method] = delay
+}
+
+// Requests return how many calls of method the node received
+func (node *Node) Requests(method string) int {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	return node.requests[method]
+}
+
+func (node *Node) serve(w http.ResponseWriter, r *http.Request) {
+	var call struct {
+		ID     json.RawMessage   `json:"id"`
+		Method string            `json:"method"`
+		Params []json.RawMessage `json:"params"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&call); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	node.mu.Lock()
+	node.requests[call.Method]++
+	delay := node.delays[call.Method]
+	failure, failed := node.failures[call.Method]
+	node.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(delay):
+		}
+	}
+
+	answer := map[string]interface{}{"jsonrpc": "2.0", "id": call.ID}
+	var (
+		result interface{}
+		err    *rpcError
+	)
+	if failed {
+		err = &failure
+	} else {
+		result, err = node.answer(call.Method, call.Params)
+	}
+	if err != nil {
+		answer["error"] = err
+	} else {
+		answer["result"] = result
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(answer)
+}
+
+func (node *Node) answer(method string, params []json.RawMessage) (interface{}, *rpcError) {
+	node.mu.Lock()
+	defer node.mu.Unlock()
+	switch method {
+	case "eth_blockNumber":
+		return hexutil.EncodeUint64(node.block), nil
+	case "eth_call":
+		var args struct {
+			Data string `json:"data"`
+		}
+		var block string
+		if len(params) < 2 || json.Unmarshal(params[0], &args) != nil || json.Unmarshal(params[1], &block) != nil {
+			return nil, &rpcError{Code: -32602, Message: "invalid params"}
+		}
+		if block != "latest" {
+			number, err := hexutil.DecodeUint64(block)
+			if err != nil || number > node.block {
+				return nil, &rpcError{Code: errorExecution, Message: "header not found"}
+			}
+		}
+		data := strings.ToLower(strings.TrimPrefix(args.Data, "0x"))
+		match := ""
+		result, found := "", false
+		for prefix, value := range node.calls {
+			if strings.HasPrefix(data, prefix) && len(prefix) >= len(match) {
+				match, result, found = prefix, value, true
+			}
+		}
+		if !found {
+			return nil, &rpcError{Code: errorExecution, Message: "execution reverted"}
+		}
+		return result, nil
+	case "eth_getLogs":
+		logs := node.logs
+		if logs == nil {
+			logs = []tomochain.EventRaw{}
+		}
+		return logs, nil
+	}
+	return nil, &rpcError{Code: -32601, Message: fmt.Sprintf("the method %s does not exist", method)}
+}
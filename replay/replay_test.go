@@ -0,0 +1,173 @@
+package replay
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func get(t *testing.T, client *http.Client, url string) (int, string) {
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp.StatusCode, string(body)
+}
+
+func TestRecordThenReplay(t *testing.T) {
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/broken" {
+			http.Error(w, "broken", http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"price":"` + r.URL.Query().Get("symbol") + `"}`))
+	}))
+	recording := &http.Client{Transport: NewRecorder(dir, nil)}
+	if status, body := get(t, recording, upstream.URL+"/price?symbol=TOMO&apikey=secret"); status != http.StatusOK || body != `{"price":"TOMO"}` {
+		t.Fatalf("recorded %d %s", status, body)
+	}
+	get(t, recording, upstream.URL+"/broken")
+	upstream.Close()
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("recorded %d fixtures, want 2", len(files))
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(dir + "/" + file.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(string(data), "secret") {
+			t.Fatalf("%s hold the api key", file.Name())
+		}
+	}
+
+	replayer, err := LoadReplayer(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replaying := &http.Client{Transport: replayer}
+	// the key differ, requests match without their credentials
+	if status, body := get(t, replaying, upstream.URL+"/price?symbol=TOMO&apikey=other"); status != http.StatusOK || body != `{"price":"TOMO"}` {
+		t.Fatalf("replayed %d %s", status, body)
+	}
+	if status, _ := get(t, replaying, upstream.URL+"/broken"); status != http.StatusInternalServerError {
+		t.Fatalf("replayed status %d, want 500", status)
+	}
+	if _, err := replaying.Get(upstream.URL + "/price?symbol=BTC"); err == nil || !strings.Contains(err.Error(), ErrNoFixture.Error()) {
+		t.Fatalf("unknown request: %v", err)
+	}
+}
+
+func TestReplayFailures(t *testing.T) {
+	replayer := NewReplayer([]Fixture{
+		{Method: "GET", URL: "https://upstream.test/refused", Error: "connection refused"},
+		{Method: "GET", URL: "https://upstream.test/hang", Error: ErrorTimeout},
+		{Method: "GET", URL: "https://upstream.test/slow", DelayMs: 50, Response: "late"},
+	})
+	client := &http.Client{Transport: replayer}
+
+	if _, err := client.Get("https://upstream.test/refused"); err == nil || !strings.Contains(err.Error(), "connection refused") {
+		t.Fatalf("refused: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	req, _ := http.NewRequest("GET", "https://upstream.test/hang", nil)
+	start := time.Now()
+	if _, err := client.Do(req.WithContext(ctx)); err == nil {
+		t.Fatal("hang: answered")
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("hang: failed after %v, before the deadline", elapsed)
+	}
+
+	start = time.Now()
+	if _, body := get(t, client, "https://upstream.test/slow"); body != "late" {
+		t.Fatalf("slow: %s", body)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Fatalf("slow: answered after %v", elapsed)
+	}
+}
+
+func TestReplayRPCID(t *testing.T) {
+	replayer := NewReplayer([]Fixture{{
+		Method:   "POST",
+		URL:      "https://node.test",
+		Body:     `{"jsonrpc":"2.0","id":1,"method":"eth_blockNumber","params":[]}`,
+		Response: `{"jsonrpc":"2.0","id":1,"result":"0x10"}`,
+	}})
+	client := &http.Client{Transport: replayer}
+	resp, err := client.Post("https://node.test", "application/json",
+		strings.NewReader(`{"jsonrpc":"2.0","id":7,"method":"eth_blockNumber","params":[]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if !strings.Contains(string(body), `"id":7`) || !strings.Contains(string(body), `"0x10"`) {
+		t.Fatalf("answer %s", body)
+	}
+}
+
+func TestNode(t *testing.T) {
+	node := NewNode()
+	defer node.Close()
+	node.SetBlock(10)
+	node.SetCall("0xabcd", "0x01")
+	node.SetCall("0xabcdef", "0x02")
+
+	call := func(data, block string) string {
+		resp, err := http.Post(node.URL, "application/json", strings.NewReader(
+			`{"jsonrpc":"2.0","id":1,"method":"eth_call","params":[{"to":"0x0","data":"`+data+`"},"`+block+`"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return string(body)
+	}
+	if answer := call("0xabcdef01", "0xa"); !strings.Contains(answer, `"0x02"`) {
+		t.Fatalf("longest prefix: %s", answer)
+	}
+	if answer := call("0xabcd01", "latest"); !strings.Contains(answer, `"0x01"`) {
+		t.Fatalf("prefix: %s", answer)
+	}
+	if answer := call("0xabcd01", "0xb"); !strings.Contains(answer, "header not found") {
+		t.Fatalf("future block: %s", answer)
+	}
+	node.Fail("eth_call", "boom")
+	if answer := call("0xabcd01", "latest"); !strings.Contains(answer, "boom") {
+		t.Fatalf("failure: %s", answer)
+	}
+	if node.Requests("eth_call") != 4 {
+		t.Fatalf("requests %d, want 4", node.Requests("eth_call"))
+	}
+}
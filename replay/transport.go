@@ -0,0 +1,209 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/marknguyen85/server-api/config"
+	log "github.com/sirupsen/logrus"
+)
+
+// ErrNoFixture is returned by a Replayer for a request it has no fixture of
+var ErrNoFixture = errors.New("no fixture for request")
+
+// Transport return the transport of mode for the fixtures of dir, nil to
+// call the upstreams
+func Transport(mode, dir string) (http.RoundTripper, error) {
+	switch mode {
+	case "":
+		return nil, nil
+	case config.UpstreamRecord:
+		return NewRecorder(dir, nil), nil
+	case config.UpstreamReplay:
+		return LoadReplayer(dir)
+	}
+	return nil, fmt.Errorf("unknown upstream mode %q", mode)
+}
+
+// Recorder send the requests with Next and write every exchange to Dir,
+// failures included
+type Recorder struct {
+	Dir  string
+	Next http.RoundTripper
+}
+
+// NewRecorder record to dir the exchanges of next, http.DefaultTransport when nil
+func NewRecorder(dir string, next http.RoundTripper) *Recorder {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	return &Recorder{Dir: dir, Next: next}
+}
+
+// RoundTrip implement http.RoundTripper
+func (recorder *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	fixture := Fixture{Method: req.Method, URL: req.URL.String(), Body: body}
+
+	start := time.Now()
+	resp, err := recorder.Next.RoundTrip(req)
+	if err != nil {
+		fixture.Error = err.Error()
+		if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+			fixture.Error = ErrorTimeout
+		}
+		fixture.DelayMs = int(time.Since(start) / time.Millisecond)
+		recorder.save(fixture)
+		return nil, err
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(data))
+
+	fixture.Status = resp.StatusCode
+	fixture.Header = http.Header{}
+	for _, name := range []string{"Content-Type", "Retry-After"} {
+		if value := resp.Header.Get(name); value != "" {
+			fixture.Header.Set(name, value)
+		}
+	}
+	fixture.Response = string(data)
+	recorder.save(fixture)
+	return resp, nil
+}
+
+func (recorder *Recorder) save(fixture Fixture) {
+	if err := fixture.save(recorder.Dir); err != nil {
+		log.WithField("url", redactURL(fixture.URL)).WithError(err).Warn("cannot record fixture")
+	}
+}
+
+// Replayer answer the requests from fixtures, without network
+type Replayer struct {
+	mu       sync.RWMutex
+	fixtures map[string]Fixture
+}
+
+// NewReplayer serve fixtures, the last one of a request wins
+func NewReplayer(fixtures []Fixture) *Replayer {
+	replayer := &Replayer{fixtures: make(map[string]Fixture, len(fixtures))}
+	for _, fixture := range fixtures {
+		replayer.Add(fixture)
+	}
+	return replayer
+}
+
+// LoadReplayer serve the fixtures of dir
+func LoadReplayer(dir string) (*Replayer, error) {
+	fixtures, err := LoadFixtures(dir)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayer(fixtures), nil
+}
+
+// Add serve fixture, replacing the one of the same request
+func (replayer *Replayer) Add(fixture Fixture) {
+	replayer.mu.Lock()
+	defer replayer.mu.Unlock()
+	replayer.fixtures[fixture.key()] = fixture
+}
+
+// RoundTrip implement http.RoundTripper
+func (replayer *Replayer) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readBody(req)
+	if err != nil {
+		return nil, err
+	}
+	key := requestKey(req.Method, req.URL.String(), body)
+	replayer.mu.RLock()
+	fixture, ok := replayer.fixtures[key]
+	replayer.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%v: %s", ErrNoFixture, key)
+	}
+
+	ctx := req.Context()
+	if fixture.DelayMs > 0 {
+		timer := time.NewTimer(time.Duration(fixture.DelayMs) * time.Millisecond)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		case <-timer.C:
+		}
+	}
+	switch fixture.Error {
+	case "":
+	case ErrorTimeout:
+		<-ctx.Done()
+		return nil, ctx.Err()
+	default:
+		return nil, errors.New(fixture.Error)
+	}
+
+	status := fixture.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	header := http.Header{}
+	for name, values := range fixture.Header {
+		header[name] = values
+	}
+	response := withRequestID(fixture.Response, body)
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		StatusCode:    status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          ioutil.NopCloser(bytes.NewReader([]byte(response))),
+		ContentLength: int64(len(response)),
+		Request:       req,
+	}, nil
+}
+
+// withRequestID give a recorded json rpc answer the id of the call replayed
+func withRequestID(response, body string) string {
+	var call, answer map[string]json.RawMessage
+	if json.Unmarshal([]byte(body), &call) != nil || call["id"] == nil {
+		return response
+	}
+	if json.Unmarshal([]byte(response), &answer) != nil || answer["jsonrpc"] == nil {
+		return response
+	}
+	answer["id"] = call["id"]
+	data, err := json.Marshal(answer)
+	if err != nil {
+		return response
+	}
+	return string(data)
+}
+
+// readBody return the body of req and leave it readable again
+func readBody(req *http.Request) (string, error) {
+	if req.Body == nil {
+		return "", nil
+	}
+	data, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return "", err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return string(data), nil
+}
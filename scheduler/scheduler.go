@@ -0,0 +1,152 @@
+package scheduler
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Job describe a task run periodically by scheduler
+type Job struct {
+	Name     string
+	Interval time.Duration
+	// SkipFirst wait for the first tick instead of running right after start
+	SkipFirst bool
+	Run       func(ctx context.Context)
+}
+
+// JobState is a snapshot of a job for monitoring
+type JobState struct {
+	Name         string        `json:"name"`
+	Interval     time.Duration `json:"interval"`
+	Running      bool          `json:"running"`
+	Runs         int64         `json:"runs"`
+	LastStart    time.Time     `json:"lastStart"`
+	LastDuration time.Duration `json:"lastDuration"`
+	NextRun      time.Time     `json:"nextRun"`
+}
+
+// Scheduler run jobs on their own ticker until context is cancelled
+type Scheduler struct {
+	mu       sync.Mutex
+	jobs     []Job
+	states   map[string]*JobState
+	triggers map[string]chan struct{}
+	wg       sync.WaitGroup
+}
+
+// NewScheduler contruct
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		states:   make(map[string]*JobState),
+		triggers: make(map[string]chan struct{}),
+	}
+}
+
+// Add register a job, must be called before Start
+func (scheduler *Scheduler) Add(job Job) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	scheduler.jobs = append(scheduler.jobs, job)
+	scheduler.states[job.Name] = &JobState{
+		Name:     job.Name,
+		Interval: job.Interval,
+	}
+	scheduler.triggers[job.Name] = make(chan struct{}, 1)
+}
+
+// Trigger run the job now instead of at its next tick, which is pushed back
+// by a full interval. Triggers received while the job run are merged into one.
+// Return false for an unknown job.
+func (scheduler *Scheduler) Trigger(name string) bool {
+	scheduler.mu.Lock()
+	trigger, ok := scheduler.triggers[name]
+	scheduler.mu.Unlock()
+	if !ok {
+		return false
+	}
+	select {
+	case trigger <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// Start run every registered job in its own goroutine
+func (scheduler *Scheduler) Start(ctx context.Context) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	for _, job := range scheduler.jobs {
+		scheduler.wg.Add(1)
+		go scheduler.loop(ctx, job)
+	}
+}
+
+// Wait block until all jobs returned after context is cancelled
+func (scheduler *Scheduler) Wait() {
+	scheduler.wg.Wait()
+}
+
+// States return a snapshot of every job in registration order
+func (scheduler *Scheduler) States() []JobState {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	result := make([]JobState, 0, len(scheduler.jobs))
+	for _, job := range scheduler.jobs {
+		result = append(result, *scheduler.states[job.Name])
+	}
+	return result
+}
+
+func (scheduler *Scheduler) loop(ctx context.Context, job Job) {
+	defer scheduler.wg.Done()
+	scheduler.mu.Lock()
+	trigger := scheduler.triggers[job.Name]
+	scheduler.mu.Unlock()
+	ticker := time.NewTicker(job.Interval)
+	defer func() {
+		ticker.Stop()
+	}()
+
+	if job.SkipFirst {
+		scheduler.setNextRun(job.Name, time.Now().Add(job.Interval))
+	} else {
+		scheduler.run(ctx, job)
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			scheduler.run(ctx, job)
+		case <-trigger:
+			ticker.Stop()
+			ticker = time.NewTicker(job.Interval)
+			scheduler.run(ctx, job)
+		}
+	}
+}
+
+func (scheduler *Scheduler) run(ctx context.Context, job Job) {
+	start := time.Now()
+	scheduler.mu.Lock()
+	state := scheduler.states[job.Name]
+	state.Running = true
+	state.LastStart = start
+	scheduler.mu.Unlock()
+
+	job.Run(ctx)
+
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	state.Running = false
+	state.Runs++
+	state.LastDuration = time.Since(start)
+	state.NextRun = start.Add(job.Interval)
+}
+
+func (scheduler *Scheduler) setNextRun(name string, next time.Time) {
+	scheduler.mu.Lock()
+	defer scheduler.mu.Unlock()
+	scheduler.states[name].NextRun = next
+}
@@ -0,0 +1,227 @@
+package stream
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// channels a client can subscribe to
+const (
+	Rates   = "rates"
+	RateUSD = "rateUSD"
+	Market  = "market"
+	Block   = "block"
+	Trades  = "trades"
+	Tokens  = "tokens"
+)
+
+// reasons a subscription is closed by the hub
+const (
+	// SlowConsumer is a client not reading fast enough to keep its buffer free
+	SlowConsumer = "slow_consumer"
+	// ShuttingDown is the server stopping, see Hub.Close
+	ShuttingDown = "shutting_down"
+)
+
+// Channels list every channel, in the order they are documented
+var Channels = []string{Rates, RateUSD, Market, Block, Trades, Tokens}
+
+// Item is one changed value of a channel and the token symbols it concern.
+// Items without symbols reach every subscriber.
+type Item struct {
+	Symbols []string
+	Value   interface{}
+}
+
+// Message is sent to a subscriber, Data hold the values of the items
+// matching its symbol filter
+type Message struct {
+	Channel string        `json:"channel"`
+	Network string        `json:"network"`
+	Time    int64         `json:"time"`
+	Data    []interface{} `json:"data"`
+}
+
+// Publisher publish the changes of one network
+type Publisher interface {
+	Publish(channel string, items []Item)
+}
+
+// Subscription receive the messages of a network on some channels. It is
+// closed when the client does not read fast enough to keep its buffer free.
+type Subscription struct {
+	network  string
+	channels map[string]bool
+	symbols  map[string]bool
+
+	messages chan Message
+	done     chan struct{}
+	once     sync.Once
+	reason   string
+}
+
+// Messages return the channel the subscription messages are delivered on
+func (sub *Subscription) Messages() <-chan Message {
+	return sub.messages
+}
+
+// Done is closed when the hub drop the subscription, see Reason
+func (sub *Subscription) Done() <-chan struct{} {
+	return sub.done
+}
+
+// Reason tell why the hub dropped the subscription once Done is closed:
+// SlowConsumer or ShuttingDown, empty when unsubscribed
+func (sub *Subscription) Reason() string {
+	<-sub.done
+	return sub.reason
+}
+
+func (sub *Subscription) close(reason string) {
+	sub.once.Do(func() {
+		sub.reason = reason
+		close(sub.done)
+	})
+}
+
+// filter return the values of items the subscription want
+func (sub *Subscription) filter(items []Item) []interface{} {
+	data := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		if len(sub.symbols) == 0 || len(item.Symbols) == 0 {
+			data = append(data, item.Value)
+			continue
+		}
+		for _, symbol := range item.Symbols {
+			if sub.symbols[strings.ToUpper(symbol)] {
+				data = append(data, item.Value)
+				break
+			}
+		}
+	}
+	return data
+}
+
+// Hub fan out the changes of every network to the subscriptions
+type Hub struct {
+	mu         sync.RWMutex
+	subs       map[*Subscription]struct{}
+	bufferSize int
+	closed     bool
+}
+
+// NewHub make a hub buffering at most bufferSize messages per subscription
+func NewHub(bufferSize int) *Hub {
+	return &Hub{
+		subs:       make(map[*Subscription]struct{}),
+		bufferSize: bufferSize,
+	}
+}
+
+// Subscribe to channels of network, every channel when empty, only keeping
+// the items of symbols when not empty
+func (hub *Hub) Subscribe(network string, channels, symbols []string) *Subscription {
+	sub := &Subscription{
+		network:  network,
+		channels: make(map[string]bool),
+		symbols:  make(map[string]bool),
+		messages: make(chan Message, hub.bufferSize),
+		done:     make(chan struct{}),
+	}
+	if len(channels) == 0 {
+		channels = Channels
+	}
+	for _, channel := range channels {
+		sub.channels[channel] = true
+	}
+	for _, symbol := range symbols {
+		sub.symbols[strings.ToUpper(symbol)] = true
+	}
+
+	hub.mu.Lock()
+	defer hub.mu.Unlock()
+	if hub.closed {
+		sub.close(ShuttingDown)
+		return sub
+	}
+	hub.subs[sub] = struct{}{}
+	return sub
+}
+
+// Unsubscribe stop delivering messages to sub
+func (hub *Hub) Unsubscribe(sub *Subscription) {
+	hub.drop(sub, "")
+}
+
+func (hub *Hub) drop(sub *Subscription, reason string) {
+	hub.mu.Lock()
+	delete(hub.subs, sub)
+	hub.mu.Unlock()
+	sub.close(reason)
+}
+
+// Close drop every subscription, and the later ones at once, so the streams
+// end when the server shut down
+func (hub *Hub) Close() {
+	hub.mu.Lock()
+	hub.closed = true
+	subs := hub.subs
+	hub.subs = make(map[*Subscription]struct{})
+	hub.mu.Unlock()
+	for sub := range subs {
+		sub.close(ShuttingDown)
+	}
+}
+
+// Count return the number of subscriptions
+func (hub *Hub) Count() int {
+	hub.mu.RLock()
+	defer hub.mu.RUnlock()
+	return len(hub.subs)
+}
+
+// Network return the publisher of network
+func (hub *Hub) Network(network string) Publisher {
+	return networkPublisher{hub: hub, network: network}
+}
+
+// publish never block: a subscription with a full buffer is dropped
+func (hub *Hub) publish(network, channel string, items []Item) {
+	if len(items) == 0 {
+		return
+	}
+	now := time.Now().UTC().Unix()
+	var slow []*Subscription
+
+	hub.mu.RLock()
+	for sub := range hub.subs {
+		if sub.network != network || !sub.channels[channel] {
+			continue
+		}
+		data := sub.filter(items)
+		if len(data) == 0 {
+			continue
+		}
+		select {
+		case sub.messages <- Message{Channel: channel, Network: network, Time: now, Data: data}:
+		default:
+			slow = append(slow, sub)
+		}
+	}
+	hub.mu.RUnlock()
+
+	for _, sub := range slow {
+		hub.drop(sub, SlowConsumer)
+	}
+}
+
+type networkPublisher struct {
+	hub     *Hub
+	network string
+}
+
+// Publish implement Publisher
+func (publisher networkPublisher) Publish(channel string, items []Item) {
+	publisher.hub.publish(publisher.network, channel, items)
+}
@@ -46,6 +46,30 @@ type Token struct {
 	TokenID    string `json:"token_id"`
 }
 
+// types of TokenChange
+const (
+	TokenListed         = "listed"
+	TokenDelisted       = "delisted"
+	TokenAddressChanged = "address_changed"
+)
+
+// TokenChange is a change of the token list of a network between two
+// refreshes
+type TokenChange struct {
+	// Seq order the changes of a network, from 1
+	Seq     uint64 `json:"seq"`
+	Type    string `json:"type"`
+	TokenID string `json:"token_id"`
+	Symbol  string `json:"symbol"`
+	Address string `json:"address"`
+	// OldAddress is the address before an address change
+	OldAddress string `json:"old_address,omitempty"`
+	// DelistTime announced by the list for a delisted token
+	DelistTime uint64 `json:"delist_time,omitempty"`
+	// Timestamp is the unix time the change was seen
+	Timestamp int64 `json:"timestamp"`
+}
+
 type TokenAPI struct {
 	Symbol      string `json:"symbol"`
 	Name        string `json:"name"`
@@ -0,0 +1,132 @@
+// Package webhook post the token list changes of the networks to the
+// configured webhooks.
+package webhook
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/marknguyen85/server-api/config"
+	"github.com/marknguyen85/server-api/httpclient"
+	"github.com/marknguyen85/server-api/metrics"
+	"github.com/marknguyen85/server-api/tomochain"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// queueSize is the most payloads waiting for delivery, later ones are
+	// dropped
+	queueSize = 256
+	// Event is the X-ChainTeX-Event header of the posts
+	Event = "token_changes"
+	// typeName of the webhook calls in the upstream metrics
+	typeName = "webhook"
+)
+
+// Payload is the body posted to a webhook
+type Payload struct {
+	Network string                  `json:"network"`
+	Changes []tomochain.TokenChange `json:"changes"`
+}
+
+type delivery struct {
+	hook config.Webhook
+	body []byte
+}
+
+// Notifier queue the changes and post them, one at a time, so a slow webhook
+// never hold a refresh
+type Notifier struct {
+	hooks  []config.Webhook
+	client *httpclient.Client
+	queue  chan delivery
+}
+
+// New make a notifier of hooks posting through client
+func New(hooks []config.Webhook, client *httpclient.Client) *Notifier {
+	return &Notifier{
+		hooks:  hooks,
+		client: client,
+		queue:  make(chan delivery, queueSize),
+	}
+}
+
+// Notify queue the changes of network for the webhooks of network, it never
+// block
+func (notifier *Notifier) Notify(network string, changes []tomochain.TokenChange) {
+	if notifier == nil || len(changes) == 0 || len(notifier.hooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(Payload{Network: network, Changes: changes})
+	if err != nil {
+		log.WithError(err).Error("cannot encode token changes")
+		return
+	}
+	for _, hook := range notifier.hooks {
+		if !wants(hook, network) {
+			continue
+		}
+		select {
+		case notifier.queue <- delivery{hook: hook, body: body}:
+		default:
+			log.WithFields(log.Fields{
+				"url":     hook.URL,
+				"network": network,
+				"changes": len(changes),
+			}).Error("webhook queue full, token changes dropped")
+		}
+	}
+}
+
+// wants tell whether hook receive the changes of network
+func wants(hook config.Webhook, network string) bool {
+	if len(hook.Networks) == 0 {
+		return true
+	}
+	for _, name := range hook.Networks {
+		if name == network {
+			return true
+		}
+	}
+	return false
+}
+
+// Run post the queued changes until ctx is done
+func (notifier *Notifier) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case d := <-notifier.queue:
+			notifier.post(ctx, d)
+		}
+	}
+}
+
+func (notifier *Notifier) post(ctx context.Context, d delivery) {
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set("X-ChainTeX-Event", Event)
+	if d.hook.Secret != "" {
+		header.Set("X-ChainTeX-Signature", Sign(d.hook.Secret, d.body))
+	}
+	start := time.Now()
+	_, err := notifier.client.Post(ctx, d.hook.URL, header, d.body)
+	metrics.ObserveUpstream(typeName, start, err)
+	if err != nil && ctx.Err() == nil {
+		log.WithField("url", d.hook.URL).WithError(err).Error("cannot post token changes to webhook")
+	}
+}
+
+// Sign return the X-ChainTeX-Signature of body: sha256= and the hex
+// HMAC-SHA256 of body by secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
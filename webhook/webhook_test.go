@@ -0,0 +1,68 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/marknguyen85/server-api/config"
+	"github.com/marknguyen85/server-api/httpclient"
+	"github.com/marknguyen85/server-api/tomochain"
+)
+
+type received struct {
+	header http.Header
+	body   []byte
+}
+
+func TestNotifier(t *testing.T) {
+	posts := make(chan received, 4)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		posts <- received{header: r.Header, body: body}
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	notifier := New([]config.Webhook{
+		{URL: server.URL + "/signed", Secret: "secret"},
+		{URL: server.URL + "/testnet", Networks: []string{"testnet"}},
+	}, httpclient.New(httpclient.DefaultOptions()))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go notifier.Run(ctx)
+
+	changes := []tomochain.TokenChange{{Seq: 1, Type: tomochain.TokenListed, TokenID: "TKN", Symbol: "TKN", Timestamp: 100}}
+	notifier.Notify("mainnet", changes)
+
+	var post received
+	select {
+	case post = <-posts:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no post")
+	}
+	if post.header.Get("X-ChainTeX-Event") != Event || post.header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers %v", post.header)
+	}
+	if signature := post.header.Get("X-ChainTeX-Signature"); signature != Sign("secret", post.body) {
+		t.Errorf("signature %q, want %q", signature, Sign("secret", post.body))
+	}
+	var payload Payload
+	if err := json.Unmarshal(post.body, &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Network != "mainnet" || len(payload.Changes) != 1 || payload.Changes[0] != changes[0] {
+		t.Errorf("payload %+v", payload)
+	}
+
+	// the testnet hook is not told the mainnet changes
+	select {
+	case post = <-posts:
+		t.Errorf("unexpected post %s", post.body)
+	case <-time.After(100 * time.Millisecond):
+	}
+}